@@ -53,22 +53,24 @@ func (Analytics) PostEvent(clientID string, category string, action string, labe
 }
 
 func readAnalyticsIDFromPersistedPairing() (id string, err error) {
-	krdir, err := KrDir()
+	pairingDir, err := PairingDir()
 	if err != nil {
 		return
 	}
 	persister := FilePersister{
-		PairingDir: krdir,
+		PairingDir: pairingDir,
 	}
-	pairing, err := persister.LoadPairing()
+	pairings, err := persister.LoadPairings()
 	if err != nil {
 		return
 	}
-	if pairing.trackingID == nil {
-		err = errors.New("no tracking ID")
-		return
+	for _, pairing := range pairings {
+		if pairing.trackingID != nil {
+			id = *pairing.trackingID
+			return
+		}
 	}
-	id = *pairing.trackingID
+	err = errors.New("no tracking ID")
 	return
 }
 
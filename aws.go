@@ -8,8 +8,10 @@ import (
 	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sqs"
@@ -17,10 +19,6 @@ import (
 
 const SQS_BASE_QUEUE_URL = "https://sqs.us-east-1.amazonaws.com/911777333295/"
 
-func queueNameToURL(name string) string {
-	return SQS_BASE_QUEUE_URL + name
-}
-
 var AWS_ENV_VARS_TO_UNSET = []string{
 	"AWS_ACCESS_KEY_ID",
 	"AWS_SECRET_ACCESS_KEY",
@@ -50,7 +48,7 @@ func unsetAWSEnvVars() {
 
 var unsetAWSEnvVarsOnce sync.Once
 
-func getAWSSession() (conf client.ConfigProvider, err error) {
+func getAWSSession(relay *RelayConfig) (conf client.ConfigProvider, err error) {
 	unsetAWSEnvVarsOnce.Do(unsetAWSEnvVars)
 
 	//	Public restricted credentials for SQS/SNS
@@ -60,7 +58,7 @@ func getAWSSession() (conf client.ConfigProvider, err error) {
 		return
 	}
 
-	cfg := aws.NewConfig().WithRegion("us-east-1").WithCredentials(creds)
+	cfg := aws.NewConfig().WithRegion(relay.region()).WithCredentials(creds)
 	conf, err = session.NewSession(cfg)
 	if err != nil {
 		return
@@ -68,25 +66,59 @@ func getAWSSession() (conf client.ConfigProvider, err error) {
 	return
 }
 
-func getSQSService() (sqsService *sqs.SQS, err error) {
-	session, err := getAWSSession()
+type awsServices struct {
+	sqs *sqs.SQS
+	sns *sns.SNS
+}
+
+//	SQS and SNS clients by relay, reused so that repeated sends share
+//	connections. A pairing whose relay config changes looks up another entry.
+var awsServicesByRelay = map[RelayConfig]*awsServices{}
+var awsServicesMutex sync.Mutex
+
+func getAWSServices(relay *RelayConfig) (services *awsServices, err error) {
+	//	a nil relay is the hosted relay, as is an empty one
+	var key RelayConfig
+	if relay != nil {
+		key = *relay
+	}
+	awsServicesMutex.Lock()
+	defer awsServicesMutex.Unlock()
+	services, ok := awsServicesByRelay[key]
+	if ok {
+		return
+	}
+	session, err := getAWSSession(relay)
+	if err != nil {
+		return
+	}
+	services = &awsServices{
+		sqs: sqs.New(session, relay.sqsConfig()),
+		sns: sns.New(session, relay.snsConfig()),
+	}
+	awsServicesByRelay[key] = services
+	return
+}
+
+func getSQSService(relay *RelayConfig) (sqsService *sqs.SQS, err error) {
+	services, err := getAWSServices(relay)
 	if err != nil {
 		return
 	}
-	sqsService = sqs.New(session)
+	sqsService = services.sqs
 	return
 }
 
-func getSNSService() (snsService *sns.SNS, err error) {
-	session, err := getAWSSession()
+func getSNSService(relay *RelayConfig) (snsService *sns.SNS, err error) {
+	services, err := getAWSServices(relay)
 	if err != nil {
 		return
 	}
-	snsService = sns.New(session)
+	snsService = services.sns
 	return
 }
 
-func PushAlertToSNSEndpoint(alertText, requestCiphertext, endpointARN, sqsQueueName string) (err error) {
+func PushAlertToSNSEndpoint(relay *RelayConfig, alertText, requestCiphertext, endpointARN, sqsQueueName string) (err error) {
 	apnsPayload, _ := json.Marshal(
 		map[string]interface{}{
 			"aps": map[string]interface{}{
@@ -109,11 +141,11 @@ func PushAlertToSNSEndpoint(alertText, requestCiphertext, endpointARN, sqsQueueN
 			"priority":         "high",
 			"time_to_live":     0,
 		})
-	err = pushToSNS(endpointARN, apnsPayload, gcmPayload)
+	err = pushToSNS(relay, endpointARN, apnsPayload, gcmPayload)
 	return
 }
 
-func PushToSNSEndpoint(requestCiphertext, endpointARN, sqsQueueName string) (err error) {
+func PushToSNSEndpoint(relay *RelayConfig, requestCiphertext, endpointARN, sqsQueueName string) (err error) {
 
 	apnsPayload, _ := json.Marshal(
 		map[string]interface{}{
@@ -135,12 +167,12 @@ func PushToSNSEndpoint(requestCiphertext, endpointARN, sqsQueueName string) (err
 				"queue":            sqsQueueName,
 			},
 		})
-	err = pushToSNS(endpointARN, apnsPayload, gcmPayload)
+	err = pushToSNS(relay, endpointARN, apnsPayload, gcmPayload)
 	return
 }
 
-func pushToSNS(endpointARN string, apnsPayload []byte, gcmPayload []byte) (err error) {
-	snsService, err := getSNSService()
+func pushToSNS(relay *RelayConfig, endpointARN string, apnsPayload []byte, gcmPayload []byte) (err error) {
+	snsService, err := getSNSService(relay)
 	if err != nil {
 		return
 	}
@@ -161,7 +193,7 @@ func pushToSNS(endpointARN string, apnsPayload []byte, gcmPayload []byte) (err e
 	_, err = snsService.Publish(publishInput)
 	if err != nil {
 		if strings.Contains(err.Error(), "EndpointDisabled") {
-			enableErr := enableSNSEndpoint(endpointARN)
+			enableErr := enableSNSEndpoint(relay, endpointARN)
 			if enableErr != nil {
 				log.Error("EnableSNSEndpoint error:", enableErr)
 				return
@@ -174,8 +206,8 @@ func pushToSNS(endpointARN string, apnsPayload []byte, gcmPayload []byte) (err e
 	return
 }
 
-func enableSNSEndpoint(arn string) (err error) {
-	snsService, err := getSNSService()
+func enableSNSEndpoint(relay *RelayConfig, arn string) (err error) {
+	snsService, err := getSNSService(relay)
 	if err != nil {
 		return
 	}
@@ -189,8 +221,8 @@ func enableSNSEndpoint(arn string) (err error) {
 	return
 }
 
-func ReceiveAndDeleteFromQueue(queueName string) (messages []string, err error) {
-	sqsService, err := getSQSService()
+func ReceiveAndDeleteFromQueue(relay *RelayConfig, queueName string) (messages []string, err error) {
+	sqsService, err := getSQSService(relay)
 	if err != nil {
 		log.Error(err)
 		return
@@ -198,7 +230,7 @@ func ReceiveAndDeleteFromQueue(queueName string) (messages []string, err error)
 
 	receiveMessageInput := &sqs.ReceiveMessageInput{
 		MaxNumberOfMessages: aws.Int64(10),
-		QueueUrl:            aws.String(queueNameToURL(queueName)),
+		QueueUrl:            aws.String(relay.queueURL(queueName)),
 		WaitTimeSeconds:     aws.Int64(3),
 	}
 
@@ -206,7 +238,7 @@ func ReceiveAndDeleteFromQueue(queueName string) (messages []string, err error)
 	if err != nil {
 		if strings.Contains(err.Error(), "AWS.SimpleQueueService.NonExistentQueue") {
 			log.Warning("SQS queue " + queueName + " does not exist, creating")
-			_, err = CreateQueue(queueName)
+			_, err = CreateQueue(relay, queueName)
 			if err != nil {
 				return
 			}
@@ -229,7 +261,7 @@ func ReceiveAndDeleteFromQueue(queueName string) (messages []string, err error)
 	}
 	if len(messages) > 0 {
 		deleteMessageInput := &sqs.DeleteMessageBatchInput{
-			QueueUrl: aws.String(queueNameToURL(queueName)),
+			QueueUrl: aws.String(relay.queueURL(queueName)),
 			Entries:  deleteRequestEntries,
 		}
 
@@ -243,8 +275,8 @@ func ReceiveAndDeleteFromQueue(queueName string) (messages []string, err error)
 	return
 }
 
-func SendToQueue(queueName string, message string) (err error) {
-	sqsService, err := getSQSService()
+func SendToQueue(relay *RelayConfig, queueName string, message string) (err error) {
+	sqsService, err := getSQSService(relay)
 	if err != nil {
 		log.Error(err)
 		return
@@ -252,14 +284,14 @@ func SendToQueue(queueName string, message string) (err error) {
 
 	sendMessageInput := &sqs.SendMessageInput{
 		MessageBody: aws.String(message),
-		QueueUrl:    aws.String(queueNameToURL(queueName)),
+		QueueUrl:    aws.String(relay.queueURL(queueName)),
 	}
 
 	_, err = sqsService.SendMessage(sendMessageInput)
 	if err != nil {
 		if strings.Contains(err.Error(), "AWS.SimpleQueueService.NonExistentQueue") {
 			log.Warning("SQS queue " + queueName + " does not exist, creating")
-			_, err = CreateQueue(queueName)
+			_, err = CreateQueue(relay, queueName)
 			if err != nil {
 				return
 			}
@@ -274,8 +306,8 @@ func SendToQueue(queueName string, message string) (err error) {
 }
 
 // Return URL for queue named `queueName`
-func CreateQueue(queue string) (queueURL string, err error) {
-	sqsService, err := getSQSService()
+func CreateQueue(relay *RelayConfig, queue string) (queueURL string, err error) {
+	sqsService, err := getSQSService(relay)
 	if err != nil {
 		log.Error(err)
 		return
@@ -295,3 +327,50 @@ func CreateQueue(queue string) (queueURL string, err error) {
 	queueURL = *createQueueResponse.QueueUrl
 	return
 }
+
+//	Codes SQS and SNS use for rate limited requests beyond those the SDK
+//	already recognizes
+var AWS_THROTTLING_ERROR_CODES = []string{
+	"OverLimit",
+	"AWS.SimpleQueueService.RequestThrottled",
+	"Throttled",
+}
+
+//	Codes SQS and SNS use for requests that fail however often they are
+//	retried, e.g. due to revoked credentials
+var AWS_PERMANENT_ERROR_CODES = []string{
+	"AccessDenied",
+	"AccessDeniedException",
+	"AuthorizationError",
+	"InvalidClientTokenId",
+	"SignatureDoesNotMatch",
+	"UnrecognizedClientException",
+}
+
+//	Whether err is AWS refusing a request in a way retrying cannot fix. Any
+//	other error, e.g. a network failure, may be transient.
+func IsPermanentAWSError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		for _, code := range AWS_PERMANENT_ERROR_CODES {
+			if awsErr.Code() == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//	Whether err is AWS rejecting a request for exceeding a rate limit
+func IsThrottlingError(err error) bool {
+	if request.IsErrorThrottle(err) {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		for _, code := range AWS_THROTTLING_ERROR_CODES {
+			if awsErr.Code() == code {
+				return true
+			}
+		}
+	}
+	return false
+}
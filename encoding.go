@@ -2,16 +2,29 @@ package kr
 
 import (
 	"crypto/rsa"
+	"errors"
 	"math/big"
 
 	"golang.org/x/crypto/ssh"
 )
 
+var ErrNotRSAKey = errors.New("Public key is not an RSA key")
+
 func SSHWireRSAPublicKeyToRSAPublicKey(wire []byte) (pk *rsa.PublicKey, err error) {
 	//	parse RSA SSH wire format
 	//  https://github.com/golang/crypto/blob/077efaa604f994162e3307fafe5954640763fc08/ssh/keys.go#L302
+	var keyType struct {
+		Type string
+		Rest []byte `ssh:"rest"`
+	}
+	if err = ssh.Unmarshal(wire, &keyType); err != nil {
+		return
+	}
+	if keyType.Type != ssh.KeyAlgoRSA {
+		err = ErrNotRSAKey
+		return
+	}
 	var w struct {
-		//	assume type RSA
 		Type string
 		E    *big.Int
 		N    *big.Int
@@ -67,41 +67,148 @@ func (fp FilePersister) SaveMySSHPubKey(me Profile) (err error) {
 	return
 }
 
-func (fp FilePersister) LoadPairing() (pairingSecret *PairingSecret, err error) {
+//	The pairing file holds an array of pairings, or the single pairing object
+//	saved by earlier versions. A corrupt pairing file falls back to the backup.
+func (fp FilePersister) LoadPairings() (pairingSecrets []*PairingSecret, err error) {
 	path := filepath.Join(fp.PairingDir, PAIRING_FILENAME)
+	pairingJson, err := ioutil.ReadFile(path)
 	if err != nil {
 		return
 	}
-	pairingJson, err := ioutil.ReadFile(path)
+	pairingSecrets, err = parsePairings(pairingJson)
+	if err == nil {
+		return
+	}
+	log.Error("pairing file corrupt, loading backup:", err.Error())
+	backupJson, backupErr := ioutil.ReadFile(filepath.Join(fp.PairingDir, PAIRING_BACKUP_FILENAME))
+	if backupErr != nil {
+		return
+	}
+	if backupPairings, backupErr := parsePairings(backupJson); backupErr == nil {
+		pairingSecrets, err = backupPairings, nil
+	}
+	return
+}
+
+func parsePairings(pairingJson []byte) (pairingSecrets []*PairingSecret, err error) {
+	var pps []persistedPairing
+	if arrayErr := json.Unmarshal(pairingJson, &pps); arrayErr != nil {
+		var pp persistedPairing
+		err = json.Unmarshal(pairingJson, &pp)
+		if err != nil {
+			return
+		}
+		pps = []persistedPairing{pp}
+	}
+	for i := range pps {
+		pairingSecrets = append(pairingSecrets, pairingFromPersisted(&pps[i]))
+	}
+	return
+}
+
+func (fp FilePersister) SavePairings(pairingSecrets []*PairingSecret) (err error) {
+	path := filepath.Join(fp.PairingDir, PAIRING_FILENAME)
+	pps := []persistedPairing{}
+	for _, ps := range pairingSecrets {
+		pps = append(pps, pairingToPersisted(ps))
+	}
+	pairingJson, err := json.Marshal(pps)
 	if err != nil {
 		return
 	}
-	var pp persistedPairing
-	err = json.Unmarshal(pairingJson, &pp)
+	err = writeFileAtomic(path, pairingJson, os.FileMode(0700))
 	if err != nil {
 		return
 	}
-	ps := pairingFromPersisted(&pp)
-	pairingSecret = ps
+	err = writeFileAtomic(filepath.Join(fp.PairingDir, PAIRING_BACKUP_FILENAME), pairingJson, os.FileMode(0700))
 	return
 }
-func (fp FilePersister) SavePairing(pairingSecret *PairingSecret) (err error) {
+
+func (fp FilePersister) DeletePairings() (err error) {
 	path := filepath.Join(fp.PairingDir, PAIRING_FILENAME)
+	err = os.Remove(path)
+	if backupErr := os.Remove(filepath.Join(fp.PairingDir, PAIRING_BACKUP_FILENAME)); err == nil && !os.IsNotExist(backupErr) {
+		err = backupErr
+	}
+	return
+}
+
+//	Write to a temporary file and rename it into place, so that path holds
+//	either the old or the new contents if the process dies mid-write
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
 	if err != nil {
 		return
 	}
-	pairingJson, err := json.Marshal(pairingToPersisted(pairingSecret))
+	defer func() {
+		if err != nil {
+			os.Remove(tmpFile.Name())
+		}
+	}()
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return
 	}
-	err = ioutil.WriteFile(path, pairingJson, os.FileMode(0700))
+	err = os.Chmod(tmpFile.Name(), perm)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmpFile.Name(), path)
 	return
 }
-func (fp FilePersister) DeletePairing() (pairingSecret *PairingSecret, err error) {
-	path := filepath.Join(fp.PairingDir, PAIRING_FILENAME)
+
+func (fp FilePersister) LoadQueuedMessages() (queue []QueuedMessage, err error) {
+	path := filepath.Join(fp.PairingDir, OUTGOING_QUEUE_FILENAME)
+	queueJson, err := ioutil.ReadFile(path)
 	if err != nil {
 		return
 	}
-	err = os.Remove(path)
+	err = json.Unmarshal(queueJson, &queue)
+	return
+}
+
+//	Queued messages are not yet encrypted, so the queue file holds the
+//	requests, e.g. data to sign and host names, as plaintext JSON. Like the
+//	pairing file it is only readable by the user.
+func (fp FilePersister) SaveQueuedMessages(queue []QueuedMessage) (err error) {
+	path := filepath.Join(fp.PairingDir, OUTGOING_QUEUE_FILENAME)
+	if len(queue) == 0 {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	queueJson, err := json.Marshal(queue)
+	if err != nil {
+		return
+	}
+	err = writeFileAtomic(path, queueJson, os.FileMode(0700))
+	return
+}
+
+func (fp FilePersister) LoadHostApprovals() (approvals HostApprovals, err error) {
+	path := filepath.Join(fp.PairingDir, HOST_APPROVALS_FILENAME)
+	approvalsJson, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(approvalsJson, &approvals)
+	return
+}
+
+func (fp FilePersister) SaveHostApprovals(approvals HostApprovals) (err error) {
+	path := filepath.Join(fp.PairingDir, HOST_APPROVALS_FILENAME)
+	approvalsJson, err := json.Marshal(approvals)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(path, approvalsJson, os.FileMode(0700))
 	return
 }
@@ -0,0 +1,53 @@
+package kr
+
+import (
+	"time"
+)
+
+//	An approval of, or revocation of earlier approvals for, a host at At,
+//	relevant until Expires
+type HostApproval struct {
+	At      time.Time `json:"at"`
+	Expires time.Time `json:"expires"`
+}
+
+//	The workstation's record of which hosts the phone may sign for without
+//	prompting, kept across daemon restarts
+type HostApprovals struct {
+	//	last prompted approval by host
+	Approved map[string]HostApproval `json:"approved,omitempty"`
+	//	last revocation by host, disregarding approvals made before it
+	Forgotten map[string]HostApproval `json:"forgotten,omitempty"`
+	//	revocation of every host's approvals
+	AllForgotten *HostApproval `json:"all_forgotten,omitempty"`
+}
+
+func NewHostApprovals() HostApprovals {
+	return HostApprovals{
+		Approved:  map[string]HostApproval{},
+		Forgotten: map[string]HostApproval{},
+	}
+}
+
+//	Drop entries that expired before now
+func (approvals *HostApprovals) Expire(now time.Time) {
+	if approvals.Approved == nil {
+		approvals.Approved = map[string]HostApproval{}
+	}
+	if approvals.Forgotten == nil {
+		approvals.Forgotten = map[string]HostApproval{}
+	}
+	for host, approval := range approvals.Approved {
+		if now.After(approval.Expires) {
+			delete(approvals.Approved, host)
+		}
+	}
+	for host, forgotten := range approvals.Forgotten {
+		if now.After(forgotten.Expires) {
+			delete(approvals.Forgotten, host)
+		}
+	}
+	if approvals.AllForgotten != nil && now.After(approvals.AllForgotten.Expires) {
+		approvals.AllForgotten = nil
+	}
+}
@@ -0,0 +1,36 @@
+package kr
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHostApprovalsPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kr-host-approvals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	persister := FilePersister{PairingDir: dir}
+
+	now := time.Now()
+	approvals := NewHostApprovals()
+	approvals.Approved["example.com"] = HostApproval{At: now, Expires: now.Add(time.Hour)}
+	approvals.Forgotten["old.example.com"] = HostApproval{At: now.Add(-2 * time.Hour), Expires: now.Add(-time.Hour)}
+	if err = persister.SaveHostApprovals(approvals); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := persister.LoadHostApprovals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Expire(now)
+	if approval, ok := loaded.Approved["example.com"]; !ok || !approval.Expires.Equal(now.Add(time.Hour)) {
+		t.Fatal("expected the approval loaded, got", loaded)
+	}
+	if _, ok := loaded.Forgotten["old.example.com"]; ok {
+		t.Fatal("expected the expired entry dropped")
+	}
+}
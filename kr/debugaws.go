@@ -20,13 +20,14 @@ func debugAWSCommand(c *cli.Context) (_ error) {
 	go func() {
 		kr.Analytics{}.PostEventUsingPersistedTrackingID("kr", "debugaws", nil, nil)
 	}()
+	relay := kr.RelayConfigFromEnv()
 	queueName, err := kr.Rand256Base62()
 	if err != nil {
 		PrintFatal(os.Stderr, err.Error())
 	}
 
 	timeFunc("Create SQS Queue", func() {
-		_, err = kr.CreateQueue(queueName)
+		_, err = kr.CreateQueue(relay, queueName)
 		if err != nil {
 			PrintFatal(os.Stderr, err.Error())
 		}
@@ -37,7 +38,7 @@ func debugAWSCommand(c *cli.Context) (_ error) {
 		PrintFatal(os.Stderr, err.Error())
 	}
 	timeFunc("Send SQS Message", func() {
-		err = kr.SendToQueue(queueName, message)
+		err = kr.SendToQueue(relay, queueName, message)
 		if err != nil {
 			PrintFatal(os.Stderr, err.Error())
 		}
@@ -49,7 +50,7 @@ func debugAWSCommand(c *cli.Context) (_ error) {
 			PrintFatal(os.Stderr, "Took longer than 10s to receive SQS message")
 		}()
 		for {
-			messages, err := kr.ReceiveAndDeleteFromQueue(queueName)
+			messages, err := kr.ReceiveAndDeleteFromQueue(relay, queueName)
 			if err != nil {
 				PrintFatal(os.Stderr, err.Error())
 			}
@@ -7,11 +7,15 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -51,6 +55,30 @@ func confirmOrFatal(stderr io.ReadWriter, message string) {
 	}
 }
 
+//	Flags for commands that prompt before destroying state. --yes skips the
+//	prompt, e.g. in provisioning scripts, and --prompt replaces its text.
+var destructiveFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "yes, force",
+		Usage: "Do not prompt for confirmation",
+	},
+	cli.StringFlag{
+		Name:  "prompt",
+		Usage: "Confirmation prompt to show instead of the default",
+	},
+}
+
+//	confirmOrFatal honoring destructiveFlags
+func confirmDestructiveOrFatal(c *cli.Context, stderr io.ReadWriter, message string) {
+	if c.Bool("yes") {
+		return
+	}
+	if prompt := c.String("prompt"); prompt != "" {
+		message = prompt
+	}
+	confirmOrFatal(stderr, message)
+}
+
 func confirm(stderr io.ReadWriter, message string) bool {
 	stderr.Write([]byte(fmt.Sprintf(message + " [y/N] ")))
 	in := []byte{0, 0}
@@ -81,7 +109,12 @@ func pairCommand(c *cli.Context) (err error) {
 	if *nameOpt == "" {
 		nameOpt = nil
 	}
-	return pairOver(kr.DaemonSocketOrFatal(), c.Bool("force"), nameOpt, os.Stdout, os.Stderr)
+	pairingOptions := kr.PairingOptions{
+		WorkstationName: nameOpt,
+		ReadOnly:        c.Bool("read-only"),
+		Add:             c.Bool("add"),
+	}
+	return pairOver(kr.DaemonSocketOrFatal(), c.Bool("force"), pairingOptions, os.Stdout, os.Stderr)
 }
 
 func pairCommandForce() (err error) {
@@ -100,10 +133,10 @@ func pairCommandForce() (err error) {
 		<-time.After(2 * time.Second)
 	}
 
-	return pairOver(kr.DaemonSocketOrFatal(), true, nil, os.Stdout, os.Stderr)
+	return pairOver(kr.DaemonSocketOrFatal(), true, kr.PairingOptions{}, os.Stdout, os.Stderr)
 }
 
-func pairOver(unixFile string, forceUnpair bool, name *string, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+func pairOver(unixFile string, forceUnpair bool, pairingOptions kr.PairingOptions, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
 	//	Listen for incompatible enclave notifications
 	go func() {
 		r, err := kr.OpenNotificationReader("")
@@ -129,7 +162,7 @@ func pairOver(unixFile string, forceUnpair bool, name *string, stdout io.ReadWri
 			printedMessages[str] = true
 		}
 	}()
-	if !forceUnpair {
+	if !forceUnpair && !pairingOptions.Add {
 		meConn, err := kr.DaemonDialWithTimeout(unixFile)
 		if err != nil {
 			PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
@@ -145,8 +178,6 @@ func pairOver(unixFile string, forceUnpair bool, name *string, stdout io.ReadWri
 	}
 	defer putConn.Close()
 
-	var pairingOptions kr.PairingOptions
-	pairingOptions.WorkstationName = name
 	body, err := json.Marshal(pairingOptions)
 	if err != nil {
 		PrintFatal(stderr, err.Error())
@@ -175,23 +206,50 @@ func pairOver(unixFile string, forceUnpair bool, name *string, stdout io.ReadWri
 		PrintFatal(stderr, "Pairing failed, ensure your phone and workstation are connected to the internet and try again.")
 	}
 
-	qr, err := QREncode(responseBytes)
+	awaitPairingScan(unixFile, responseBytes, stdout, stderr)
+	return
+}
+
+//	Pairing QR code image, written while pairing if the terminal is too small
+//	to show the code
+const PAIRING_QR_FILENAME = "pairing_qr.png"
+
+//	Show the QR code for a pairing and wait for the phone to complete it
+func awaitPairingScan(unixFile string, pairingSecretJson []byte, stdout io.ReadWriter, stderr io.ReadWriter) {
+	qr, err := QREncode(pairingSecretJson)
 	if err != nil {
 		PrintFatal(stderr, err.Error())
 	}
 
-	stdout.Write([]byte("\r\n"))
-	stdout.Write([]byte(qr.Terminal))
-	stdout.Write([]byte("\r\n"))
-	stdout.Write([]byte("Scan this QR Code with the Krypton mobile app to connect it with this workstation. Maximize the window and/or lower your font size if the QR code does not fit."))
-	stdout.Write([]byte("\r\n"))
+	var columns, rows int
+	if stdoutFile, ok := stdout.(*os.File); ok {
+		columns, rows, _ = terminalSize(stdoutFile)
+	}
+	if rendered, ok := qr.Fit(columns, rows); ok {
+		stdout.Write([]byte("\r\n"))
+		stdout.Write([]byte(rendered))
+		stdout.Write([]byte("\r\n"))
+		stdout.Write([]byte("Scan this QR Code with the Krypton mobile app to connect it with this workstation. Maximize the window and/or lower your font size if the QR code does not fit."))
+		stdout.Write([]byte("\r\n"))
+	} else {
+		//	the phone can scan the code from an image viewer instead
+		qrPath, err := kr.KrDirFile(PAIRING_QR_FILENAME)
+		if err != nil {
+			PrintFatal(stderr, "%s", err.Error())
+		}
+		if err = ioutil.WriteFile(qrPath, qr.PNG, 0600); err != nil {
+			PrintFatal(stderr, "%s", err.Error())
+		}
+		defer os.Remove(qrPath)
+		PrintErr(stderr, "%s", kr.Yellow(fmt.Sprintf("Krypton ▶ Your terminal is too small to show the pairing QR code. Enlarge the window or lower your font size and run \"kr pair\" again, or open the QR code saved to %s and scan it.", qrPath)))
+	}
 
 	//	Check/wait for pairing
 	getConn, err := kr.DaemonDialWithTimeout(unixFile)
 	if err != nil {
 		PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
 	}
-	defer putConn.Close()
+	defer getConn.Close()
 	me, err := krdclient.RequestMeForceRefreshOver(getConn, nil)
 
 	clearCommand := exec.Command("clear")
@@ -209,22 +267,86 @@ func pairOver(unixFile string, forceUnpair bool, name *string, stdout io.ReadWri
 	}
 	stdout.Write([]byte(authorizedKey))
 	stdout.Write([]byte("\r\n"))
+}
+
+func rotateCommand(c *cli.Context) (err error) {
+	return rotateOver(kr.DaemonSocketOrFatal(), os.Stdout, os.Stderr)
+}
+
+//	Rotate the pairing keys, falling back to showing a QR code to re-pair when
+//	the phone does not support rotation
+func rotateOver(unixFile string, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+	conn, err := kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
+	}
+	defer conn.Close()
+
+	rotateRequest, err := http.NewRequest("PUT", "/pair/rotate", nil)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	err = rotateRequest.Write(conn)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	rotateResponse, err := http.ReadResponse(bufio.NewReader(conn), rotateRequest)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	responseBytes, err := ioutil.ReadAll(rotateResponse.Body)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	switch rotateResponse.StatusCode {
+	case http.StatusOK:
+		stdout.Write([]byte("Rotated pairing keys.\r\n"))
+	case http.StatusAccepted:
+		PrintErr(stderr, "%s", kr.Yellow("Krypton ▶ Your phone does not support rotating pairing keys, scan the QR code to re-pair."))
+		awaitPairingScan(unixFile, responseBytes, stdout, stderr)
+	case http.StatusNotFound:
+		PrintFatal(stderr, "%s", kr.ErrNotPaired.Error())
+	default:
+		PrintFatal(stderr, "Rotating pairing keys failed: %s", string(responseBytes))
+	}
 	return
 }
 
 func unpairCommand(c *cli.Context) (err error) {
 	kr.Analytics{}.PostEventUsingPersistedTrackingID("kr", "unpair", nil, nil)
+	if device := c.String("device"); device != "" {
+		confirmDestructiveOrFatal(c, os.Stderr, "Unpair device "+device+"?")
+		return unpairDeviceOver(kr.DaemonSocketOrFatal(), device, os.Stdout, os.Stderr)
+	}
 	return unpairOver(kr.DaemonSocketOrFatal(), os.Stdout, os.Stderr)
 }
 
 func unpairOver(unixFile string, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+	deletePairOver(unixFile, "", stderr)
+	stdout.Write([]byte("Unpaired Krypton.\r\n"))
+	return
+}
+
+//	Unpair one device, as listed by kr devices, keeping other pairings
+func unpairDeviceOver(unixFile string, device string, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+	deletePairOver(unixFile, device, stderr)
+	stdout.Write([]byte("Unpaired device " + device + ".\r\n"))
+	return
+}
+
+//	Unpair the given device, or every device if empty
+func deletePairOver(unixFile string, device string, stderr io.ReadWriter) {
 	conn, err := kr.DaemonDialWithTimeout(unixFile)
 	if err != nil {
 		PrintFatal(stderr, err.Error())
 	}
 	defer conn.Close()
 
-	deletePair, err := http.NewRequest("DELETE", "/pair", nil)
+	path := "/pair"
+	if device != "" {
+		path += "?" + url.Values{"id": {device}}.Encode()
+	}
+	deletePair, err := http.NewRequest("DELETE", path, nil)
 	if err != nil {
 		PrintFatal(stderr, err.Error())
 	}
@@ -240,13 +362,379 @@ func unpairOver(unixFile string, stdout io.ReadWriter, stderr io.ReadWriter) (er
 		PrintFatal(stderr, err.Error())
 	}
 	switch response.StatusCode {
-	case http.StatusNotFound, http.StatusInternalServerError:
+	case http.StatusNotFound:
+		if device != "" {
+			PrintFatal(stderr, "No paired device with ID %s, run \"kr devices\" to list them.", device)
+		}
+		PrintFatal(stderr, "Unpair failed, ensure the Krypton daemon is running with \"kr restart\".")
+	case http.StatusInternalServerError:
 		PrintFatal(stderr, "Unpair failed, ensure the Krypton daemon is running with \"kr restart\".")
 	case http.StatusOK:
 	default:
 		PrintFatal(stderr, "Unpair failed with error %d", response.StatusCode)
 	}
-	stdout.Write([]byte("Unpaired Krypton.\r\n"))
+}
+
+func statusCommand(c *cli.Context) (err error) {
+	err = statusOver(kr.DaemonSocketOrFatal(), os.Stdout, os.Stderr)
+	if err == kr.ErrNotPaired {
+		os.Exit(1)
+	}
+	return
+}
+
+//	Print the pairing and daemon state, returning kr.ErrNotPaired if there
+//	is no pairing
+func statusOver(unixFile string, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+	conn, err := kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
+	}
+	defer conn.Close()
+
+	status, err := krdclient.RequestStatusOver(conn)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+
+	if status.Paired {
+		fmt.Fprintf(stdout, "Paired:          %s\r\n", kr.Green("yes"))
+	} else {
+		fmt.Fprintf(stdout, "Paired:          %s\r\n", kr.Red("no"))
+	}
+	if status.Email != nil {
+		fmt.Fprintf(stdout, "Email:           %s\r\n", *status.Email)
+	}
+	if status.PublicKeyFingerprint != nil {
+		fmt.Fprintf(stdout, "Key:             %s\r\n", *status.PublicKeyFingerprint)
+	}
+	bluetooth := status.Bluetooth
+	if status.BluetoothError != nil {
+		bluetooth += " (" + *status.BluetoothError + ")"
+	}
+	fmt.Fprintf(stdout, "Bluetooth:       %s\r\n", bluetooth)
+	if status.LastSignature != nil {
+		fmt.Fprintf(stdout, "Last signature:  %s (%s ago)\r\n", status.LastSignature.Format(time.RFC1123), time.Since(*status.LastSignature).Round(time.Second))
+	} else {
+		fmt.Fprintf(stdout, "Last signature:  none\r\n")
+	}
+	fmt.Fprintf(stdout, "Pending:         %d requests, %d queued messages\r\n", status.PendingRequests, status.OutgoingQueueDepth)
+
+	if !status.Paired {
+		err = kr.ErrNotPaired
+	}
+	return
+}
+
+func doctorCommand(c *cli.Context) (err error) {
+	return doctorOver(kr.DaemonSocketOrFatal(), c.Bool("json"), os.Stdout, os.Stderr)
+}
+
+//	Print a snapshot of the daemon for bug reports, including a live ping of
+//	the phone if paired
+func doctorOver(unixFile string, asJSON bool, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+	conn, err := kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
+	}
+	defer conn.Close()
+
+	if !asJSON {
+		PrintErr(stderr, "Krypton ▶ Pinging your phone...")
+	}
+	diagnosticsRequest, err := http.NewRequest("GET", "/diagnostics?ping=true", nil)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	err = diagnosticsRequest.Write(conn)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	diagnosticsResponse, err := http.ReadResponse(bufio.NewReader(conn), diagnosticsRequest)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	if diagnosticsResponse.StatusCode != http.StatusOK {
+		PrintFatal(stderr, "Krypton daemon does not support diagnostics, update it with \"kr upgrade\".")
+	}
+	var diagnostics kr.Diagnostics
+	err = json.NewDecoder(diagnosticsResponse.Body).Decode(&diagnostics)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	if asJSON {
+		err = printJSON(stdout, diagnostics)
+		return
+	}
+
+	fmt.Fprintf(stdout, "Version:         %s\r\n", diagnostics.Version)
+	if diagnostics.Paired {
+		fmt.Fprintf(stdout, "Paired:          %s\r\n", kr.Green("yes"))
+	} else {
+		fmt.Fprintf(stdout, "Paired:          %s\r\n", kr.Red("no"))
+	}
+	for _, pairing := range diagnostics.Pairings {
+		phoneVersion := "unknown"
+		if pairing.EnclaveVersion != nil {
+			phoneVersion = *pairing.EnclaveVersion
+		}
+		fmt.Fprintf(stdout, "Device:          %s (paired: %t, phone version: %s, push: %t)\r\n", pairing.ID, pairing.Paired, phoneVersion, pairing.HasSNSEndpoint)
+	}
+	bluetooth := diagnostics.Bluetooth
+	if diagnostics.BluetoothError != nil {
+		bluetooth += " (" + *diagnostics.BluetoothError + ")"
+	}
+	fmt.Fprintf(stdout, "Bluetooth:       %s\r\n", bluetooth)
+	var transports []string
+	for _, transport := range diagnostics.Transports {
+		state := "not connected"
+		if transport.Connected {
+			state = "connected"
+		}
+		transports = append(transports, transport.Medium+" "+state)
+	}
+	fmt.Fprintf(stdout, "Transports:      %s\r\n", strings.Join(transports, ", "))
+	fmt.Fprintf(stdout, "Pending:         %d requests, %d queued messages\r\n", diagnostics.PendingRequests, diagnostics.OutgoingQueueDepth)
+	failed := 0
+	for _, entry := range diagnostics.RecentSignatures {
+		if !entry.Succeeded {
+			failed++
+		}
+	}
+	fmt.Fprintf(stdout, "Signatures:      %d recent, %d failed\r\n", len(diagnostics.RecentSignatures), failed)
+	switch {
+	case diagnostics.PingError != nil:
+		fmt.Fprintf(stdout, "Ping:            %s\r\n", kr.Red(*diagnostics.PingError))
+	case diagnostics.PingLatencyMillis != nil:
+		fmt.Fprintf(stdout, "Ping:            %s\r\n", kr.Green(fmt.Sprintf("%dms", *diagnostics.PingLatencyMillis)))
+	}
+	return
+}
+
+func devicesCommand(c *cli.Context) (err error) {
+	return devicesOver(kr.DaemonSocketOrFatal(), os.Stdout, os.Stderr)
+}
+
+func devicesOver(unixFile string, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+	conn, err := kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
+	}
+	defer conn.Close()
+
+	devicesRequest, err := http.NewRequest("GET", "/pair/devices", nil)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	err = devicesRequest.Write(conn)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	devicesResponse, err := http.ReadResponse(bufio.NewReader(conn), devicesRequest)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	if devicesResponse.StatusCode != http.StatusOK {
+		PrintFatal(stderr, "Krypton daemon does not support listing devices, update it with \"kr upgrade\".")
+	}
+	var devices []kr.PairedDevice
+	err = json.NewDecoder(devicesResponse.Body).Decode(&devices)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	if len(devices) == 0 {
+		PrintErr(stderr, "%s", kr.Yellow("Krypton ▶ No paired devices, run \"kr pair\" to pair one."))
+		return
+	}
+	for _, device := range devices {
+		var notes []string
+		if !device.Paired {
+			notes = append(notes, "pairing")
+		}
+		if device.ReadOnly {
+			notes = append(notes, "read-only")
+		}
+		line := device.ID
+		if len(notes) > 0 {
+			line += " (" + strings.Join(notes, ", ") + ")"
+		}
+		stdout.Write([]byte(line + "\r\n"))
+	}
+	return
+}
+
+func signCommand(c *cli.Context) (err error) {
+	input := io.Reader(os.Stdin)
+	if path := c.Args().First(); path != "" {
+		file, openErr := os.Open(path)
+		if openErr != nil {
+			PrintFatal(os.Stderr, "%s", openErr.Error())
+		}
+		defer file.Close()
+		input = file
+	}
+	return signDataOver(kr.DaemonSocketOrFatal(), input, c.String("encoding"), c.Bool("check"), os.Stdout, os.Stderr)
+}
+
+func forgetCommand(c *cli.Context) (err error) {
+	host := c.Args().First()
+	if host == "" {
+		PrintFatal(os.Stderr, "Usage: kr forget <host>")
+	}
+	return forgetHostOver(kr.DaemonSocketOrFatal(), host, os.Stdout, os.Stderr)
+}
+
+//	Revoke the approval remembered for host, so that the phone prompts for
+//	it again
+func forgetHostOver(unixFile string, host string, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+	conn, err := kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
+	}
+	defer conn.Close()
+
+	forgetRequest, err := http.NewRequest("DELETE", "/remember?"+url.Values{"host": {host}}.Encode(), nil)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	err = forgetRequest.Write(conn)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	forgetResponse, err := http.ReadResponse(bufio.NewReader(conn), forgetRequest)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	if forgetResponse.StatusCode != http.StatusOK {
+		PrintFatal(stderr, "Krypton daemon does not support forgetting hosts, update it with \"kr upgrade\".")
+	}
+	fmt.Fprintf(stdout, "Your phone will prompt for %s again.\r\n", host)
+	return
+}
+
+var signatureEncoders = map[string]func([]byte) []byte{
+	"base64": func(signature []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+	},
+	"hex": func(signature []byte) []byte {
+		return []byte(hex.EncodeToString(signature) + "\n")
+	},
+	"raw": func(signature []byte) []byte {
+		return signature
+	},
+}
+
+//	Sign the SHA-256 digest of input with the paired key and write the
+//	signature to stdout in the given encoding. With check, only print what
+//	would be signed.
+func signDataOver(unixFile string, input io.Reader, encoding string, check bool, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+	encode, ok := signatureEncoders[encoding]
+	if !ok {
+		PrintFatal(stderr, "Krypton ▶ Unknown encoding %q, use base64, hex or raw.", encoding)
+	}
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	digest := sha256.Sum256(data)
+
+	conn, err := kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
+	}
+	me, err := krdclient.RequestMeOver(conn)
+	conn.Close()
+	if err != nil {
+		PrintFatal(stderr, "Krypton ▶ %s", err.Error())
+	}
+
+	if check {
+		PrintErr(stderr, "Krypton ▶ Would sign SHA-256 digest %s with key %s",
+			hex.EncodeToString(digest[:]), base64.StdEncoding.EncodeToString(me.PublicKeyFingerprint()))
+		return
+	}
+
+	conn, err = kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
+	}
+	defer conn.Close()
+	start := time.Now()
+	signature, err := krdclient.SignOver(conn, me.PublicKeyFingerprint(), digest[:])
+	if err != nil {
+		PrintFatal(stderr, "Krypton ▶ %s", err.Error())
+	}
+	PrintErr(stderr, "Krypton ▶ Signed in %s", time.Since(start).Round(time.Millisecond))
+	_, err = stdout.Write(encode(signature))
+	return
+}
+
+func auditCommand(c *cli.Context) (err error) {
+	return auditOver(kr.DaemonSocketOrFatal(), c.Bool("json"), os.Stdout, os.Stderr)
+}
+
+//	Print the signature requests krd has seen since it started, oldest first
+func auditOver(unixFile string, asJSON bool, stdout io.ReadWriter, stderr io.ReadWriter) (err error) {
+	conn, err := kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		PrintFatal(stderr, "Could not connect to Krypton daemon. Make sure it is running by typing \"kr restart\".")
+	}
+	defer conn.Close()
+
+	auditRequest, err := http.NewRequest("GET", "/audit", nil)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	err = auditRequest.Write(conn)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	auditResponse, err := http.ReadResponse(bufio.NewReader(conn), auditRequest)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	if auditResponse.StatusCode != http.StatusOK {
+		PrintFatal(stderr, "Krypton daemon does not support the audit log, update it with \"kr upgrade\".")
+	}
+	var entries []kr.SignatureAuditEntry
+	err = json.NewDecoder(auditResponse.Body).Decode(&entries)
+	if err != nil {
+		PrintFatal(stderr, "%s", err.Error())
+	}
+	if asJSON {
+		err = printJSON(stdout, entries)
+		return
+	}
+	if len(entries) == 0 {
+		PrintErr(stderr, "Krypton ▶ No signature requests since krd started.")
+		return
+	}
+	for _, entry := range entries {
+		result := kr.Green("approved")
+		switch {
+		case !entry.Succeeded && entry.Error != nil:
+			result = kr.Red("failed: " + *entry.Error)
+		case !entry.Succeeded:
+			result = kr.Red("failed")
+		case entry.AutoApproved:
+			result = kr.Green("auto-approved")
+		}
+		host := "unknown host"
+		if len(entry.HostNames) > 0 {
+			host = strings.Join(entry.HostNames, ", ")
+		}
+		fmt.Fprintf(stdout, "%s  %s  %s\r\n", entry.Time.Format(time.RFC3339), host, result)
+	}
+	return
+}
+
+//	Indented JSON for scripts, terminated by a newline
+func printJSON(stdout io.Writer, v interface{}) (err error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return
+	}
+	_, err = stdout.Write(append(data, '\n'))
 	return
 }
 
@@ -255,6 +743,9 @@ func meCommand(c *cli.Context) (err error) {
 	if err != nil {
 		PrintFatal(os.Stderr, err.Error())
 	}
+	if c.Bool("json") {
+		return printJSON(os.Stdout, me)
+	}
 	authorizedKey, err := me.AuthorizedKeyString()
 	if err != nil {
 		PrintFatal(os.Stderr, err.Error())
@@ -530,17 +1021,52 @@ func main() {
 					Name:  "force",
 					Usage: "Do not ask for confirmation to unpair a currently paired device",
 				},
+				cli.BoolFlag{
+					Name:  "add",
+					Usage: "Pair an additional phone, keeping existing pairings",
+				},
 				cli.StringFlag{
 					Name:  "name, n",
 					Usage: "WorkstationName for this computer",
 				},
+				cli.BoolFlag{
+					Name:  "read-only",
+					Usage: "Pair as an observer that can view your identity and keys but not request signatures",
+				},
 			},
 			Action: pairCommand,
 		},
+		cli.Command{
+			Name:   "rotate",
+			Usage:  "Rotate the keys of the current pairing without re-pairing",
+			Action: rotateCommand,
+		},
+		cli.Command{
+			Name:   "status",
+			Usage:  "Print pairing and Krypton daemon status, exiting non-zero if not paired",
+			Action: statusCommand,
+		},
+		cli.Command{
+			Name:   "doctor",
+			Usage:  "Print diagnostics for bug reports and ping your phone",
+			Action: doctorCommand,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the diagnostics as JSON",
+				},
+			},
+		},
 		cli.Command{
 			Name:   "me",
 			Usage:  "Print your SSH public key",
 			Action: meCommand,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print your profile as JSON",
+				},
+			},
 			Subcommands: []cli.Command{
 				cli.Command{
 					Name:   "pgp",
@@ -1038,11 +1564,57 @@ func main() {
 			Name:   "unpair",
 			Usage:  "Unpair this workstation from a phone running Krypton",
 			Action: unpairCommand,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "device, d",
+					Usage: "Unpair only the device with this ID, as listed by kr devices",
+				},
+			}, destructiveFlags...),
+		},
+		cli.Command{
+			Name:   "devices",
+			Usage:  "List the phones paired with this workstation",
+			Action: devicesCommand,
+		},
+		cli.Command{
+			Name:      "sign",
+			Usage:     "Sign the SHA-256 digest of a file or stdin with your paired key",
+			ArgsUsage: "[file]",
+			Action:    signCommand,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "encoding",
+					Value: "base64",
+					Usage: "Signature encoding: base64, hex or raw",
+				},
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "Print what would be signed without requesting a signature",
+				},
+			},
+		},
+		cli.Command{
+			Name:      "forget",
+			Usage:     "Revoke the remembered approval for a host so your phone prompts for it again",
+			ArgsUsage: "<host>",
+			Action:    forgetCommand,
+		},
+		cli.Command{
+			Name:   "audit",
+			Usage:  "List the signature requests made since the Krypton daemon started",
+			Action: auditCommand,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the audit log as JSON",
+				},
+			},
 		},
 		cli.Command{
 			Name:   "uninstall",
 			Usage:  "Uninstall Krypton from this workstation",
 			Action: uninstallCommand,
+			Flags:  destructiveFlags,
 		},
 		cli.Command{
 			Name:   "debugaws",
@@ -158,7 +158,7 @@ func uninstallCommand(c *cli.Context) (err error) {
 	go func() {
 		kr.Analytics{}.PostEventUsingPersistedTrackingID("kr", "uninstall", nil, nil)
 	}()
-	confirmOrFatal(os.Stderr, "Uninstall Krypton from this workstation?")
+	confirmDestructiveOrFatal(c, os.Stderr, "Uninstall Krypton from this workstation?")
 	_, _ = runCommandTmuxFriendly("brew", "uninstall", "kr")
 	_, _ = runCommandTmuxFriendly("npm", "uninstall", "-g", "krd")
 	cleanSSHConfig()
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kryptco/kr"
+)
+
+const SYSTEMD_UNIT_TEMPLATE = `[Unit]
+Description=Krypton daemon
+
+[Service]
+ExecStart=%s
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`
+
+var systemdUserUnitDir = filepath.Join(kr.HomeDir(), ".config", "systemd", "user")
+var systemdUserUnit = filepath.Join(systemdUserUnitDir, "krd.service")
+
+//	Whether a systemd user manager is running for this session
+func hasSystemdUser() bool {
+	if exec.Command("which", "systemctl").Run() != nil {
+		return false
+	}
+	return exec.Command("systemctl", "--user", "show-environment").Run() == nil
+}
+
+func systemctlUser(args ...string) (err error) {
+	output, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("systemctl --user %s failed: %s", strings.Join(args, " "), strings.TrimSpace(string(output)))
+	}
+	return
+}
+
+func installSystemdUnit() (err error) {
+	output, err := exec.Command("which", "krd").Output()
+	if err != nil {
+		err = fmt.Errorf("Could not find krd on PATH, make sure krd is installed")
+		return
+	}
+	unitContents := fmt.Sprintf(SYSTEMD_UNIT_TEMPLATE, strings.TrimSpace(string(output)))
+	if existing, readErr := ioutil.ReadFile(systemdUserUnit); readErr == nil && string(existing) == unitContents {
+		return
+	}
+	_ = os.MkdirAll(systemdUserUnitDir, 0700)
+	err = ioutil.WriteFile(systemdUserUnit, []byte(unitContents), 0600)
+	if err != nil {
+		err = fmt.Errorf("Error writing krd systemd unit: %s", err.Error())
+		return
+	}
+	err = systemctlUser("daemon-reload")
+	if err != nil {
+		return
+	}
+	err = systemctlUser("enable", "krd")
+	return
+}
+
+//	Run krd with nohup when there is no systemd user manager, e.g. over SSH
+//	or in a container. krd is then not restarted if it exits, so say so.
+func startKrdNohup() (err error) {
+	PrintErr(os.Stderr, "%s", kr.Yellow("Krypton ▶ systemd user services are unavailable, starting krd with nohup instead. krd will not be restarted if it exits."))
+	err = exec.Command("nohup", "krd").Start()
+	if err != nil {
+		err = fmt.Errorf("Error starting krd: %s", err.Error())
+	}
+	return
+}
+
+func startKrd() (err error) {
+	if !hasSystemdUser() {
+		return startKrdNohup()
+	}
+	err = installSystemdUnit()
+	if err != nil {
+		return
+	}
+	err = systemctlUser("start", "krd")
+	return
+}
+
+//	Restart krd under the systemd user manager when available, otherwise
+//	fall back to running it with nohup.
+func restartKrd() (err error) {
+	if !hasSystemdUser() {
+		kr.KillKrd()
+		return startKrdNohup()
+	}
+	err = installSystemdUnit()
+	if err != nil {
+		return
+	}
+	//	stop any krd started outside of systemd
+	kr.KillKrd()
+	err = systemctlUser("restart", "krd")
+	return
+}
+
+func uninstallKrdService() {
+	if _, err := os.Stat(systemdUserUnit); err != nil {
+		return
+	}
+	if hasSystemdUser() {
+		systemctlUser("disable", "--now", "krd")
+	}
+	os.Remove(systemdUserUnit)
+	if hasSystemdUser() {
+		systemctlUser("daemon-reload")
+	}
+}
+
+func openBrowser(url string) {
+	for _, browser := range []string{"xdg-open", "sensible-browser"} {
+		if exec.Command(browser, url).Run() == nil {
+			return
+		}
+	}
+	os.Stderr.WriteString("Unable to open browser, please visit " + url + "\r\n")
+}
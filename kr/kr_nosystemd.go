@@ -0,0 +1,30 @@
+// +build !darwin,!linux,!windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/kryptco/kr"
+)
+
+func startKrd() (err error) {
+	exec.Command("nohup", "krd").Start()
+	return
+}
+
+func restartKrd() (err error) {
+	kr.KillKrd()
+	return startKrd()
+}
+
+func uninstallKrdService() {
+}
+
+func openBrowser(url string) {
+	err := exec.Command("sensible-browser", url).Run()
+	if err != nil {
+		os.Stderr.WriteString("Unable to open browser, please visit " + url + "\r\n")
+	}
+}
@@ -2,10 +2,18 @@ package main
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/kryptco/kr"
 	krd "github.com/kryptco/kr/krd"
+	"github.com/urfave/cli"
 )
 
 func TestPair(t *testing.T) {
@@ -20,7 +28,7 @@ func TestPair(t *testing.T) {
 func testPairSuccess(t *testing.T, unixFile string, ec krd.EnclaveClientI) {
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
-	err := pairOver(unixFile, true, nil, stdout, stderr)
+	err := pairOver(unixFile, true, kr.PairingOptions{}, stdout, stderr)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,3 +55,267 @@ func TestUnpair(t *testing.T) {
 		t.Fatal("paired")
 	}
 }
+
+func TestUnpairDevice(t *testing.T) {
+	ec, _, unixFile := krd.NewLocalUnixServer(t)
+	defer os.Remove(unixFile)
+	ec.Start()
+	defer ec.Stop()
+
+	testPairSuccess(t, unixFile, ec)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	if err := devicesOver(unixFile, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	devices := ec.PairedDevices()
+	if len(devices) != 1 || !strings.Contains(stdout.String(), devices[0].ID) {
+		t.Fatal("expected the paired device to be listed, got", stdout.String())
+	}
+	if err := unpairDeviceOver(unixFile, devices[0].ID, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	if ec.IsPaired() {
+		t.Fatal("paired")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ec, _, unixFile := krd.NewLocalUnixServer(t)
+	defer os.Remove(unixFile)
+	ec.Start()
+	defer ec.Stop()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	if err := statusOver(unixFile, stdout, stderr); err != kr.ErrNotPaired {
+		t.Fatal("expected ErrNotPaired before pairing, got", err)
+	}
+
+	testPairSuccess(t, unixFile, ec)
+
+	stdout.Reset()
+	if err := statusOver(unixFile, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	me, _, _ := kr.TestMe(t)
+	if !strings.Contains(stdout.String(), me.Email) {
+		t.Fatal("expected status to include the paired email, got", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "0 requests, 0 queued messages") {
+		t.Fatal("expected status to include pending requests, got", stdout.String())
+	}
+}
+
+func TestAudit(t *testing.T) {
+	ec, _, unixFile := krd.NewLocalUnixServer(t)
+	defer os.Remove(unixFile)
+	ec.Start()
+	defer ec.Stop()
+	testPairSuccess(t, unixFile, ec)
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("audit"))
+	if _, _, err := ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+		HostAuth:             &kr.HostAuth{HostNames: []string{"example.com"}},
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	if err := auditOver(unixFile, true, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	var entries []kr.SignatureAuditEntry
+	if err := json.Unmarshal(stdout.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[0].Succeeded || entries[0].HostNames[0] != "example.com" {
+		t.Fatal("unexpected audit log", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := auditOver(unixFile, false, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "example.com") {
+		t.Fatal("expected audit log to include the host, got", stdout.String())
+	}
+}
+
+func TestForgetHost(t *testing.T) {
+	ec, _, unixFile := krd.NewLocalUnixServer(t)
+	defer os.Remove(unixFile)
+	ec.Start()
+	defer ec.Stop()
+
+	stdout := &bytes.Buffer{}
+	if err := forgetHostOver(unixFile, "example.com", stdout, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "example.com") {
+		t.Fatal("expected the forgotten host confirmed, got", stdout.String())
+	}
+	approvals, err := ec.(*krd.EnclaveClient).Persister.LoadHostApprovals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := approvals.Forgotten["example.com"]; !ok {
+		t.Fatal("expected the host forgotten, got", approvals)
+	}
+}
+
+func TestSignDataCheck(t *testing.T) {
+	ec, _, unixFile := krd.NewLocalUnixServer(t)
+	defer os.Remove(unixFile)
+	ec.Start()
+	defer ec.Stop()
+	testPairSuccess(t, unixFile, ec)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	if err := signDataOver(unixFile, strings.NewReader("sign me"), "hex", true, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.Len() != 0 {
+		t.Fatal("expected no signature from a check, got", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "Would sign") {
+		t.Fatal("expected check to describe the request, got", stderr.String())
+	}
+	if len(ec.AuditLog()) != 0 {
+		t.Fatal("expected check not to request a signature")
+	}
+}
+
+func TestSignData(t *testing.T) {
+	ec, _, unixFile := krd.NewLocalUnixServer(t)
+	defer os.Remove(unixFile)
+	ec.Start()
+	defer ec.Stop()
+	testPairSuccess(t, unixFile, ec)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	if err := signDataOver(unixFile, strings.NewReader("sign me"), "base64", false, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	me, _, _ := kr.TestMe(t)
+	pk, err := me.RSAPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("sign me"))
+	if err := rsa.VerifyPKCS1v15(pk, crypto.SHA256, digest[:], signature); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "Signed in") {
+		t.Fatal("expected latency on stderr, got", stderr.String())
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	ec, _, unixFile := krd.NewLocalUnixServer(t)
+	defer os.Remove(unixFile)
+	ec.Start()
+	defer ec.Stop()
+	testPairSuccess(t, unixFile, ec)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	if err := doctorOver(unixFile, true, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	var diagnostics kr.Diagnostics
+	if err := json.Unmarshal(stdout.Bytes(), &diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if !diagnostics.Paired || len(diagnostics.Pairings) != 1 || diagnostics.PingLatencyMillis == nil || diagnostics.PingError != nil {
+		t.Fatal("unexpected diagnostics", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := doctorOver(unixFile, false, stdout, stderr); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "Ping:") {
+		t.Fatal("expected doctor to report the ping, got", stdout.String())
+	}
+}
+
+//	Run a destructive command with args and return what it prompted
+func runDestructiveCommand(t *testing.T, args ...string) string {
+	stderr := &bytes.Buffer{}
+	app := cli.NewApp()
+	app.Commands = []cli.Command{
+		cli.Command{
+			Name:  "uninstall",
+			Flags: destructiveFlags,
+			Action: func(c *cli.Context) error {
+				confirmDestructiveOrFatal(c, stderr, "Uninstall?")
+				return nil
+			},
+		},
+	}
+	if err := app.Run(append([]string{"kr", "uninstall"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return stderr.String()
+}
+
+func TestConfirmDestructiveSkippedWithYes(t *testing.T) {
+	for _, arg := range []string{"--yes", "--force"} {
+		if prompt := runDestructiveCommand(t, arg); prompt != "" {
+			t.Fatal("expected no prompt with", arg, "got", prompt)
+		}
+	}
+}
+
+func TestConfirmDestructivePrompt(t *testing.T) {
+	stdin, input, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	originalStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = originalStdin }()
+	input.Write([]byte("y\n"))
+	input.Close()
+
+	prompt := runDestructiveCommand(t, "--prompt", "Tear down CI workstation?")
+	if !strings.Contains(prompt, "Tear down CI workstation?") || strings.Contains(prompt, "Uninstall?") {
+		t.Fatal("expected the overridden prompt, got", prompt)
+	}
+}
+
+func TestQRFitsTerminal(t *testing.T) {
+	qr, err := QREncode([]byte("pairing secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := qr.Size + 2
+	if rendered, ok := qr.Fit(0, 0); !ok || rendered != qr.Terminal {
+		t.Fatal("expected full size code for an unknown terminal")
+	}
+	if rendered, ok := qr.Fit(2*side, side+1); !ok || rendered != qr.Terminal {
+		t.Fatal("expected full size code when it fits")
+	}
+	if rendered, ok := qr.Fit(side, side); !ok || rendered != qr.Compact {
+		t.Fatal("expected compact code in a narrow terminal")
+	}
+	if strings.Count(qr.Compact, "\n") != (side+1)/2 {
+		t.Fatal("expected two module rows per line, got", strings.Count(qr.Compact, "\n"), "lines")
+	}
+	if _, ok := qr.Fit(side-1, side); ok {
+		t.Fatal("expected no code to fit a tiny terminal")
+	}
+}
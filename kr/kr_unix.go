@@ -1,4 +1,4 @@
-// +build !darwin
+// +build !darwin,!windows
 
 package main
 
@@ -17,8 +17,10 @@ func restartCommandOptions(c *cli.Context, isUserInitiated bool) (err error) {
 
 	_ = migrateSSHConfig()
 
-	kr.KillKrd()
-	startKrd()
+	err = restartKrd()
+	if err != nil {
+		return
+	}
 
 	if isUserInitiated {
 		PrintErr(os.Stderr, "Restarted Krypton daemon.")
@@ -26,18 +28,6 @@ func restartCommandOptions(c *cli.Context, isUserInitiated bool) (err error) {
 	return
 }
 
-func startKrd() (err error) {
-	exec.Command("nohup", "krd").Start()
-	return
-}
-
-func openBrowser(url string) {
-	err := exec.Command("sensible-browser", url).Run()
-	if err != nil {
-		os.Stderr.WriteString("Unable to open browser, please visit " + url + "\r\n")
-	}
-}
-
 func hasAptGet() bool {
 	return exec.Command("which", "apt-get").Run() == nil
 }
@@ -54,11 +44,12 @@ func uninstallCommand(c *cli.Context) (err error) {
 	go func() {
 		kr.Analytics{}.PostEventUsingPersistedTrackingID("kr", "uninstall", nil, nil)
 	}()
-	confirmOrFatal(os.Stderr, "Uninstall Krypton from this workstation? (same as sudo apt-get/yum remove kr)")
+	confirmDestructiveOrFatal(c, os.Stderr, "Uninstall Krypton from this workstation? (same as sudo apt-get/yum remove kr)")
 
 	cleanSSHConfig()
 
 	kr.KillKrd()
+	uninstallKrdService()
 
 	if hasAptGet() {
 		uninstallCmd := exec.Command("sudo", "apt-get", "remove", "kr", "-y")
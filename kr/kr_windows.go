@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/kryptco/kr"
+	"github.com/urfave/cli"
+)
+
+//	Name of the Windows service krd is registered as by the installer
+const KRD_SERVICE_NAME = "krd"
+
+//	Krypton binaries are installed per-user under %APPDATA%
+func installDir() string {
+	appData := os.Getenv("APPDATA")
+	if appData == "" {
+		appData = filepath.Join(kr.HomeDir(), "AppData", "Roaming")
+	}
+	return filepath.Join(appData, "Krypton")
+}
+
+func hasKrdService() bool {
+	return exec.Command("sc", "query", KRD_SERVICE_NAME).Run() == nil
+}
+
+func startKrd() (err error) {
+	if hasKrdService() {
+		output, scErr := exec.Command("sc", "start", KRD_SERVICE_NAME).CombinedOutput()
+		if scErr != nil {
+			err = fmt.Errorf(kr.Red("Krypton ▶ Error starting krd service: " + string(output)))
+			PrintErr(os.Stderr, err.Error())
+		}
+		return
+	}
+	err = exec.Command(filepath.Join(installDir(), "krd.exe")).Start()
+	return
+}
+
+func killKrd() (err error) {
+	if hasKrdService() {
+		_ = exec.Command("sc", "stop", KRD_SERVICE_NAME).Run()
+	}
+	_ = exec.Command("taskkill", "/F", "/IM", "krd.exe").Run()
+	return
+}
+
+func restartCommandOptions(c *cli.Context, isUserInitiated bool) (err error) {
+	if isUserInitiated {
+		kr.Analytics{}.PostEventUsingPersistedTrackingID("kr", "restart", nil, nil)
+	}
+
+	_ = migrateSSHConfig()
+
+	err = killKrd()
+	if err != nil {
+		return
+	}
+	err = startKrd()
+	if err != nil {
+		return
+	}
+
+	if isUserInitiated {
+		fmt.Println("Restarted Krypton daemon.")
+	}
+	return
+}
+
+func openBrowser(url string) {
+	err := exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Run()
+	if err != nil {
+		os.Stderr.WriteString("Unable to open browser, please visit " + url + "\r\n")
+	}
+}
+
+func uninstallCommand(c *cli.Context) (err error) {
+	go func() {
+		kr.Analytics{}.PostEventUsingPersistedTrackingID("kr", "uninstall", nil, nil)
+	}()
+	confirmDestructiveOrFatal(c, os.Stderr, "Uninstall Krypton from this workstation?")
+	cleanSSHConfig()
+	killKrd()
+	if hasKrdService() {
+		_ = exec.Command("sc", "delete", KRD_SERVICE_NAME).Run()
+	}
+	dir := installDir()
+	//	kr.exe is running and cannot remove itself, it is left for the
+	//	installer to clean up
+	for _, file := range []string{"krd.exe", "krssh.exe", "krgpg.exe", "kr-pkcs11.dll"} {
+		rmErr := os.Remove(filepath.Join(dir, file))
+		if rmErr != nil && !os.IsNotExist(rmErr) {
+			PrintErr(os.Stderr, "Could not remove "+filepath.Join(dir, file)+": "+rmErr.Error())
+		}
+	}
+	uninstallCodesigning()
+	PrintErr(os.Stderr, "Krypton uninstalled. If you experience any issues, please refer to https://krypt.co/docs/start/installation.html#uninstalling-kr")
+	return
+}
+
+func upgradeCommand(c *cli.Context) (err error) {
+	PrintErr(os.Stderr, "Please download the latest Krypton installer from https://krypt.co/install to upgrade.")
+	return
+}
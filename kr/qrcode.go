@@ -7,17 +7,19 @@ import (
 	"github.com/kryptco/qr"
 )
 
-// Encodings is the result of QR encoding.  It has three different
-// representations:  Terminal with ansi codes, PNG-encoded bytes,
-// and ASCII string where "#" is black and " " is white.
+//	The result of QR encoding: Terminal with ansi codes, Compact with ansi
+//	codes and two rows per line, PNG-encoded bytes, and ASCII where "#" is
+//	black and " " is white
 type Encodings struct {
 	Terminal string
+	Compact  string
 	PNG      []byte
 	ASCII    string
+	//	modules per side, excluding the border
+	Size int
 }
 
-// Encode makes a QR code out of data and encodes it into three
-// different representations.
+//	Make a QR code out of data and encode it into each representation
 func QREncode(data []byte) (*Encodings, error) {
 	code, err := qr.Encode(string(data), qr.L)
 	if err != nil {
@@ -27,7 +29,9 @@ func QREncode(data []byte) (*Encodings, error) {
 	var result Encodings
 	result.PNG = code.PNG()
 	result.Terminal = terminal(code)
+	result.Compact = compactTerminal(code)
 	result.ASCII = ascii(code)
+	result.Size = code.Size
 
 	return &result, nil
 }
@@ -58,6 +62,64 @@ func terminal(code *qr.Code) string {
 	return buf.String()
 }
 
+//	The largest terminal rendering that fits in a terminal of columns by
+//	rows characters, ok false if none does. A zero size, for an unknown
+//	terminal, fits the full size rendering.
+func (e *Encodings) Fit(columns, rows int) (rendered string, ok bool) {
+	side := e.Size + 2
+	switch {
+	case columns == 0 && rows == 0:
+		return e.Terminal, true
+	case columns >= 2*side && rows > side:
+		return e.Terminal, true
+	case columns >= side && rows > (side+1)/2:
+		return e.Compact, true
+	}
+	return "", false
+}
+
+const (
+	upperHalf = "\u2580"
+	fgBlack   = "\033[38;5;232m"
+	fgWhite   = "\033[38;5;255m"
+	bgBlack   = "\033[48;5;232m"
+	bgWhite   = "\033[48;5;255m"
+	reset     = "\033[0m"
+)
+
+//	Two rows of modules per line with upper half blocks, the top module in
+//	the foreground and the bottom one in the background, so the code takes
+//	half the width and height of terminal
+func compactTerminal(code *qr.Code) string {
+	var buf bytes.Buffer
+
+	//	the border is one module wide on every side
+	isBlack := func(col, row int) bool {
+		if col < 0 || row < 0 || col >= code.Size || row >= code.Size {
+			return false
+		}
+		return code.Black(col, row)
+	}
+	for row := -1; row <= code.Size; row += 2 {
+		for col := -1; col <= code.Size; col++ {
+			if isBlack(col, row) {
+				buf.WriteString(fgBlack)
+			} else {
+				buf.WriteString(fgWhite)
+			}
+			if isBlack(col, row+1) {
+				buf.WriteString(bgBlack)
+			} else {
+				buf.WriteString(bgWhite)
+			}
+			buf.WriteString(upperHalf)
+		}
+		buf.WriteString(reset + "\n")
+	}
+
+	return buf.String()
+}
+
 func ascii(code *qr.Code) string {
 	var buf bytes.Buffer
 
@@ -0,0 +1,26 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type winsize struct {
+	rows    uint16
+	columns uint16
+	xpixel  uint16
+	ypixel  uint16
+}
+
+//	Size of the terminal f is attached to, ok false if f is not a terminal
+func terminalSize(f *os.File) (columns int, rows int, ok bool) {
+	var ws winsize
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return
+	}
+	return int(ws.columns), int(ws.rows), true
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+)
+
+//	Terminal size is not detected on Windows, so the QR code is always shown
+//	at full size
+func terminalSize(f *os.File) (columns int, rows int, ok bool) {
+	return
+}
@@ -39,13 +39,13 @@ func (b BadHostInfoError) Error() string {
 /// Helper Functions
 
 func getFilePersister() (files kr.FilePersister, err error) {
-	krdir, err := kr.KrDir()
+	pairingDir, err := kr.PairingDir()
 	if err != nil {
 		return
 	}
 
 	files = kr.FilePersister{
-		PairingDir: krdir,
+		PairingDir: pairingDir,
 		SSHDir:     filepath.Join(kr.HomeDir(), ".ssh"),
 	}
 
@@ -82,13 +82,13 @@ func doManualRePairWithNewKrypton(c *cli.Context, newProfile kr.Profile) (err er
 		return
 	}
 
-	pairingFilePath, err := kr.KrDirFile(kr.PAIRING_FILENAME)
+	pairingFilePath, err := kr.PairingDirFile(kr.PAIRING_FILENAME)
 	if err != nil {
 		return
 	}
 
 	// the temporary new pairing filelocation
-	pairingTransferNewFilePath, err := kr.KrDirFile(kr.PAIRING_TRANSFER_NEW_FILENAME)
+	pairingTransferNewFilePath, err := kr.PairingDirFile(kr.PAIRING_TRANSFER_NEW_FILENAME)
 	if err != nil {
 		return
 	}
@@ -129,18 +129,18 @@ func doManualRePairWithOldKrypton(c *cli.Context, oldProfile kr.Profile) (err er
 		return
 	}
 
-	pairingFilePath, err := kr.KrDirFile(kr.PAIRING_FILENAME)
+	pairingFilePath, err := kr.PairingDirFile(kr.PAIRING_FILENAME)
 	if err != nil {
 		return
 	}
 
-	pairingTransferOldFilePath, err := kr.KrDirFile(kr.PAIRING_TRANSFER_OLD_FILENAME)
+	pairingTransferOldFilePath, err := kr.PairingDirFile(kr.PAIRING_TRANSFER_OLD_FILENAME)
 	if err != nil {
 		return
 	}
 
 	// the temporary new pairing filelocation
-	pairingTransferNewFilePath, err := kr.KrDirFile(kr.PAIRING_TRANSFER_NEW_FILENAME)
+	pairingTransferNewFilePath, err := kr.PairingDirFile(kr.PAIRING_TRANSFER_NEW_FILENAME)
 	if err != nil {
 		return
 	}
@@ -183,13 +183,13 @@ func doManualRePairWithOldKrypton(c *cli.Context, oldProfile kr.Profile) (err er
 
 /// pair with the new Krypton device
 func doPairNewKryptonDevice(c *cli.Context) (newProfile kr.Profile, err error) {
-	pairingFilePath, err := kr.KrDirFile(kr.PAIRING_FILENAME)
+	pairingFilePath, err := kr.PairingDirFile(kr.PAIRING_FILENAME)
 	if err != nil {
 		return
 	}
 
 	// the temporary pairing filelocation
-	pairingTransferOldFilePath, err := kr.KrDirFile(kr.PAIRING_TRANSFER_OLD_FILENAME)
+	pairingTransferOldFilePath, err := kr.PairingDirFile(kr.PAIRING_TRANSFER_OLD_FILENAME)
 	if err != nil {
 		return
 	}
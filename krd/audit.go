@@ -0,0 +1,71 @@
+package krd
+
+import (
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	Number of sign requests kept in the audit log unless configured otherwise
+const DEFAULT_AUDIT_LOG_SIZE = 128
+
+//	A completed signature request, successful or not
+type SignatureAuditEntry = kr.SignatureAuditEntry
+
+func newSignatureAuditEntry(request kr.Request, response kr.Response, err error) (entry SignatureAuditEntry) {
+	entry = SignatureAuditEntry{
+		RequestID:            request.RequestID,
+		Time:                 time.Now(),
+		PublicKeyFingerprint: request.SignRequest.PublicKeyFingerprint,
+		Command:              request.SignRequest.Command,
+		Metadata:             request.SignRequest.Metadata,
+	}
+	if request.SignRequest.HostAuth != nil {
+		entry.HostNames = request.SignRequest.HostAuth.HostNames
+	}
+	switch {
+	case err != nil:
+		errStr := err.Error()
+		entry.Error = &errStr
+	case response.SignResponse == nil:
+		errStr := "no sign response"
+		entry.Error = &errStr
+	case response.SignResponse.Error != nil:
+		entry.Error = response.SignResponse.Error
+	default:
+		entry.Succeeded = response.SignResponse.Signature != nil
+		entry.AutoApproved = response.SignResponse.AutoApproved
+	}
+	return
+}
+
+func (ec *EnclaveClient) recordSignature(entry SignatureAuditEntry) {
+	ec.Lock()
+	defer ec.Unlock()
+	ec.auditLog = append(ec.auditLog, entry)
+	if overflow := len(ec.auditLog) - ec.auditLogSize; overflow > 0 {
+		ec.auditLog = append([]SignatureAuditEntry{}, ec.auditLog[overflow:]...)
+	}
+}
+
+//	Recent signature requests, oldest first
+func (ec *EnclaveClient) AuditLog() (entries []SignatureAuditEntry) {
+	ec.Lock()
+	defer ec.Unlock()
+	entries = append(entries, ec.auditLog...)
+	return
+}
+
+//	Number of signature requests kept by AuditLog. Older entries are
+//	dropped when the log shrinks.
+func (ec *EnclaveClient) SetAuditLogSize(size int) {
+	ec.Lock()
+	defer ec.Unlock()
+	if size < 0 {
+		size = 0
+	}
+	ec.auditLogSize = size
+	if overflow := len(ec.auditLog) - size; overflow > 0 {
+		ec.auditLog = append([]SignatureAuditEntry{}, ec.auditLog[overflow:]...)
+	}
+}
@@ -0,0 +1,70 @@
+package krd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kryptco/kr"
+	"golang.org/x/crypto/ssh"
+)
+
+//	How long AvailableKeys reuses the phone's last answer
+const AVAILABLE_KEYS_CACHE_TTL = 10 * time.Second
+
+//	A key held by the phone, as presented in a key picker
+type KeyInfo struct {
+	//	SHA256 fingerprint as printed by ssh-keygen -l
+	Fingerprint string `json:"fingerprint"`
+	//	SSH key type, e.g. ssh-ed25519
+	Type    string `json:"type"`
+	Comment string `json:"comment"`
+}
+
+func KeyInfoFromProfile(profile kr.Profile) (info KeyInfo, err error) {
+	pk, err := profile.SSHPublicKey()
+	if err != nil {
+		return
+	}
+	info = KeyInfo{
+		Fingerprint: ssh.FingerprintSHA256(pk),
+		Type:        pk.Type(),
+		Comment:     profile.Email,
+	}
+	return
+}
+
+//	The keys the phone holds, from a list request made at most
+//	AVAILABLE_KEYS_CACHE_TTL ago
+func (ec *EnclaveClient) AvailableKeys() (keys []KeyInfo, err error) {
+	ec.Lock()
+	if ec.availableKeys != nil && ec.clock.Now().Sub(ec.availableKeysAt) < AVAILABLE_KEYS_CACHE_TTL {
+		keys = append(keys, ec.availableKeys...)
+		ec.Unlock()
+		return
+	}
+	ec.Unlock()
+
+	listResponse, err := ec.RequestList()
+	if err != nil {
+		return
+	}
+	if listResponse == nil {
+		err = &ProtoError{fmt.Errorf("no list response")}
+		return
+	}
+	keys = []KeyInfo{}
+	for _, profile := range listResponse.Keys {
+		info, keyErr := KeyInfoFromProfile(profile)
+		if keyErr != nil {
+			ec.log.Error("skipping invalid key in list response:", keyErr)
+			continue
+		}
+		keys = append(keys, info)
+	}
+
+	ec.Lock()
+	ec.availableKeys = append([]KeyInfo{}, keys...)
+	ec.availableKeysAt = ec.clock.Now()
+	ec.Unlock()
+	return
+}
@@ -1,6 +1,10 @@
 package krd
 
 import (
+	"os"
+	"strconv"
+	"time"
+
 	"github.com/satori/go.uuid"
 )
 
@@ -13,3 +17,73 @@ type BluetoothDriverI interface {
 	ReadChan() (readChan chan []byte, err error)
 	Stop()
 }
+
+type BluetoothStatus int
+
+const (
+	BluetoothDisabled BluetoothStatus = iota
+	BluetoothScanning
+	BluetoothConnected
+	BluetoothError
+)
+
+//	A phone counts as connected if it sent a message over bluetooth this
+//	recently
+const BLUETOOTH_CONNECTED_WINDOW = time.Minute
+
+func (status BluetoothStatus) String() string {
+	switch status {
+	case BluetoothDisabled:
+		return "disabled"
+	case BluetoothScanning:
+		return "scanning"
+	case BluetoothConnected:
+		return "connected"
+	case BluetoothError:
+		return "error"
+	}
+	return "unknown"
+}
+
+//	Must be called with ec locked
+func (ec *EnclaveClient) setBluetoothStatus(status BluetoothStatus, err error) {
+	ec.btStatus = status
+	if err != nil {
+		ec.btErr = err
+	}
+}
+
+//	Current state of the bluetooth transport and the last bluetooth error,
+//	if any.
+func (ec *EnclaveClient) BluetoothStatus() (status BluetoothStatus, lastErr error) {
+	ec.Lock()
+	defer ec.Unlock()
+	status, lastErr = ec.btStatus, ec.btErr
+	if status == BluetoothScanning {
+		if lastActivity, ok := ec.lastActivityByMedium[BLUETOOTH]; ok && ec.clock.Now().Sub(lastActivity) < BLUETOOTH_CONNECTED_WINDOW {
+			status = BluetoothConnected
+		}
+	}
+	return
+}
+
+//	Set to a true value, e.g. KR_DISABLE_BLUETOOTH=1, to never start the
+//	bluetooth driver and reach the phone over SQS/SNS only, e.g. where
+//	security software blocks BLE
+const DISABLE_BLUETOOTH_ENV = "KR_DISABLE_BLUETOOTH"
+
+func bluetoothDisabledByEnv() bool {
+	disabled, _ := strconv.ParseBool(os.Getenv(DISABLE_BLUETOOTH_ENV))
+	return disabled
+}
+
+//	NewBluetoothDriver as a BluetoothDriverI, nil rather than a typed nil on
+//	error
+func newPlatformBluetoothDriver() (bt BluetoothDriverI, err error) {
+	driver, err := NewBluetoothDriver()
+	if err != nil {
+		return
+	}
+	bt = driver
+	return
+}
@@ -0,0 +1,26 @@
+// +build !nobluetooth
+
+package krd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	The linux driver's read channel is closed immediately, so the supervisor
+//	sees it fail and restarts it
+func TestBluetoothDriverRestarted(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	restarted := func() bool {
+		ec.(*EnclaveClient).Lock()
+		defer ec.(*EnclaveClient).Unlock()
+		return ec.(*EnclaveClient).btRestarts > 0
+	}
+	kr.TrueBefore(t, restarted, time.Now().Add(MIN_BLUETOOTH_RESTART_BACKOFF+time.Second))
+}
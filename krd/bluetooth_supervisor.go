@@ -0,0 +1,117 @@
+package krd
+
+import (
+	"time"
+)
+
+//	Bounds of the wait before recreating a failed bluetooth driver
+const MIN_BLUETOOTH_RESTART_BACKOFF = time.Second
+const MAX_BLUETOOTH_RESTART_BACKOFF = time.Minute
+
+//	Read from bt until its read channel closes or its writes keep failing,
+//	then recreate the driver with backoff, e.g. after the adapter resets on
+//	sleep/wake. A nil bt means the
+//	initial driver failed to start. Returns once done is closed.
+func (ec *EnclaveClient) superviseBluetooth(bt BluetoothDriverI, done chan struct{}) {
+	backoff := MIN_BLUETOOTH_RESTART_BACKOFF
+	for {
+		if bt != nil {
+			started := ec.clock.Now()
+			ec.readBluetooth(bt, done)
+			ec.Lock()
+			select {
+			case <-done:
+				ec.Unlock()
+				return
+			default:
+			}
+			if ec.bt == bt {
+				ec.bt = nil
+			}
+			ec.setBluetoothStatus(BluetoothDisabled, nil)
+			ec.Unlock()
+			bt.Stop()
+			if ec.clock.Now().Sub(started) > MAX_BLUETOOTH_RESTART_BACKOFF {
+				backoff = MIN_BLUETOOTH_RESTART_BACKOFF
+			}
+		}
+
+		select {
+		case <-ec.clock.After(backoff):
+		case <-done:
+			return
+		}
+		backoff *= 2
+		if backoff > MAX_BLUETOOTH_RESTART_BACKOFF {
+			backoff = MAX_BLUETOOTH_RESTART_BACKOFF
+		}
+
+		newBt, err := ec.newBluetoothDriver()
+		if err != nil {
+			ec.log.Error("error restarting bluetooth driver:", err)
+			ec.Lock()
+			ec.setBluetoothStatus(BluetoothError, err)
+			ec.Unlock()
+			bt = nil
+			continue
+		}
+		ec.Lock()
+		select {
+		case <-done:
+			ec.Unlock()
+			newBt.Stop()
+			return
+		default:
+		}
+		ec.log.Info("bluetooth driver restarted")
+		ec.bt = newBt
+		ec.btRestarts++
+		ec.setBluetoothStatus(BluetoothScanning, nil)
+		//	only the new driver, USB already has every pairing's service
+		for _, ps := range ec.pairingSecrets {
+			if serviceUUID, uuidErr := ec.deriveServiceUUID(ps); uuidErr == nil {
+				if btErr := newBt.AddService(serviceUUID); btErr != nil {
+					ec.log.Error(btErr)
+				}
+			}
+		}
+		ec.Unlock()
+		bt = newBt
+	}
+}
+
+//	Handle ciphertexts from bt until its read channel closes, the watchdog
+//	gives up on bt, or done is closed
+func (ec *EnclaveClient) readBluetooth(bt BluetoothDriverI, done chan struct{}) {
+	readChan, err := bt.ReadChan()
+	if err != nil {
+		ec.log.Error("error retrieving bluetooth read channel:", err)
+		ec.Lock()
+		ec.setBluetoothStatus(BluetoothError, err)
+		ec.Unlock()
+		return
+	}
+	for {
+		select {
+		case ciphertext, ok := <-readChan:
+			if !ok {
+				return
+			}
+			ciphertext, err = decodeFrame(ciphertext)
+			if err != nil {
+				ec.log.Error("dropping bluetooth frame:", err)
+				continue
+			}
+			err = ec.handleCiphertext(ciphertext, BLUETOOTH)
+			if err != nil && err != ErrForeignCiphertext {
+				ec.log.Error("error reading bluetooth channel:", err)
+			}
+		case unhealthy := <-ec.btWatchdog:
+			if unhealthy == bt {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package krd
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+//	Consecutive failed bluetooth writes after which the driver is torn down
+//	and recreated, for adapters in a state a reconnect does not fix
+const BLUETOOTH_WATCHDOG_FAILURES = 5
+
+var ErrBluetoothUnhealthy = errors.New("Bluetooth restarted after repeated write failures")
+
+//	Count a write to bt, restarting bt once BLUETOOTH_WATCHDOG_FAILURES
+//	writes in a row have failed. Requests keep going over SQS/SNS meanwhile.
+func (ec *EnclaveClient) watchBluetoothWrite(bt BluetoothDriverI, err error) {
+	if err == nil {
+		atomic.StoreInt32(&ec.btWriteFailures, 0)
+		return
+	}
+	if atomic.AddInt32(&ec.btWriteFailures, 1) < BLUETOOTH_WATCHDOG_FAILURES {
+		return
+	}
+	atomic.StoreInt32(&ec.btWriteFailures, 0)
+	ec.log.Error("bluetooth writes failing repeatedly, restarting driver:", err)
+	ec.Lock()
+	ec.setBluetoothStatus(BluetoothError, fmt.Errorf("%v: %v", ErrBluetoothUnhealthy, err))
+	ec.Unlock()
+	select {
+	case ec.btWatchdog <- bt:
+	default:
+	}
+}
@@ -0,0 +1,53 @@
+package krd
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+var ErrBluetoothWriteTimeout = errors.New("Bluetooth write timed out")
+var ErrTooManyBluetoothWrites = errors.New("Too many Bluetooth writes pending")
+
+const DEFAULT_BLUETOOTH_WRITE_TIMEOUT = 5 * time.Second
+
+//	Writes still running, e.g. on a wedged adapter, beyond which new writes
+//	are skipped
+const MAX_PENDING_BLUETOOTH_WRITES = 16
+
+//	Write a ciphertext to the phone over bluetooth, giving up after the write
+//	timeout. A write that hangs keeps its slot until it returns, bounding the
+//	goroutines a wedged adapter can hold.
+func (client *EnclaveClient) writeBluetooth(pairingSecret *kr.PairingSecret, ciphertext []byte) (err error) {
+	client.Lock()
+	bt, timeout := client.bt, client.btWriteTimeout
+	client.Unlock()
+	if bt == nil {
+		return
+	}
+	uuid, err := pairingSecret.DeriveUUID()
+	if err != nil {
+		return
+	}
+	defer func() {
+		client.watchBluetoothWrite(bt, err)
+	}()
+	select {
+	case client.btWrites <- struct{}{}:
+	default:
+		err = ErrTooManyBluetoothWrites
+		return
+	}
+	result := make(chan error, 1)
+	go func() {
+		defer func() { <-client.btWrites }()
+		result <- bt.Write(uuid, ciphertext)
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(timeout):
+		err = ErrBluetoothWriteTimeout
+	}
+	return
+}
@@ -0,0 +1,119 @@
+package krd
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+var ErrNoCachedMe = errors.New("No profile cached yet, run kr me to fetch it from the phone")
+
+//	How long RequestMeOrCached waits for the phone before settling for the
+//	cached profile
+const ME_CACHED_FALLBACK_TIMEOUT = time.Second
+
+//	A RequestMe in flight, shared by concurrent RequestMeOrCached calls
+type meRefresh struct {
+	done chan struct{}
+	me   *kr.Profile
+	err  error
+}
+
+//	SSH wire format public key of the cached profile
+func (ec *EnclaveClient) CachedPublicKeyWire() (wire []byte, err error) {
+	me := ec.GetCachedMe()
+	if me == nil {
+		err = ErrNoCachedMe
+		return
+	}
+	wire = append(wire, me.SSHWirePublicKey...)
+	return
+}
+
+//	The cached profile formatted as an authorized_keys line, including the
+//	email as a comment
+func (ec *EnclaveClient) CachedAuthorizedKeyLine() (line string, err error) {
+	me := ec.GetCachedMe()
+	if me == nil {
+		err = ErrNoCachedMe
+		return
+	}
+	line, err = me.AuthorizedKeyString()
+	return
+}
+
+//	Return a fresh profile if the phone answers quickly, otherwise the cached
+//	profile with stale set while the refresh continues in the background.
+//	Blocks for the phone only when no profile is cached.
+func (ec *EnclaveClient) RequestMeOrCached() (me *kr.Profile, stale bool, err error) {
+	if !ec.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+	refresh := ec.refreshMe()
+	cached := ec.GetCachedMe()
+	var fallback <-chan time.Time
+	if cached != nil {
+		fallback = time.After(ME_CACHED_FALLBACK_TIMEOUT)
+	}
+	select {
+	case <-refresh.done:
+		if refresh.err == nil && refresh.me != nil {
+			me = refresh.me
+			return
+		}
+		if cached == nil {
+			err = refresh.err
+			if err == nil {
+				err = ErrNoCachedMe
+			}
+			return
+		}
+	case <-fallback:
+	}
+	me = cached
+	stale = true
+	return
+}
+
+//	Fetch the profile from the phone, bypassing the cache, and cache and
+//	persist it so GetCachedMe returns it immediately after a restart. Joins a
+//	fetch already in flight.
+func (ec *EnclaveClient) RefreshMe() (me *kr.Profile, err error) {
+	if !ec.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+	refresh := ec.refreshMe()
+	<-refresh.done
+	me, err = refresh.me, refresh.err
+	if err == nil && me == nil {
+		err = &ProtoError{errors.New("no me response")}
+	}
+	return
+}
+
+//	Start a RequestMe unless one started by refreshMe is still in flight
+func (ec *EnclaveClient) refreshMe() (refresh *meRefresh) {
+	ec.Lock()
+	defer ec.Unlock()
+	if ec.meRefresh != nil {
+		return ec.meRefresh
+	}
+	refresh = &meRefresh{done: make(chan struct{})}
+	ec.meRefresh = refresh
+	go func() {
+		meResponse, err := ec.RequestMe(kr.MeRequest{}, false)
+		if meResponse != nil {
+			me := meResponse.Me
+			refresh.me = &me
+		}
+		refresh.err = err
+		ec.Lock()
+		ec.meRefresh = nil
+		ec.Unlock()
+		close(refresh.done)
+	}()
+	return
+}
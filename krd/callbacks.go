@@ -0,0 +1,135 @@
+package krd
+
+import (
+	"time"
+)
+
+//	Extra time a callback is kept past its request's own deadline
+const CALLBACK_EXPIRY_MARGIN = 10 * time.Second
+
+type pendingCallback struct {
+	cb             chan *callbackT
+	idempotencyKey string
+	expiresAt      time.Time
+	//	how long past its latest progress the callback is kept
+	lifetime time.Duration
+	//	when a partial response, e.g. a page of a list, last arrived
+	progressAt time.Time
+}
+
+//	Callbacks of pending requests keyed by RequestID. Unlike an LRU, pending
+//	callbacks are never pushed out by newer requests; they are only dropped
+//	once expired. Must be used with the EnclaveClient locked.
+type requestCallbacks struct {
+	callbacks map[string]pendingCallback
+	//	expiry is judged by the clock request deadlines are computed with
+	clock Clock
+	//	closed once the last pending callback is removed, nil while none is
+	//	waited for
+	drained chan struct{}
+}
+
+func newRequestCallbacks(sizeHint int, clock Clock) *requestCallbacks {
+	return &requestCallbacks{
+		callbacks: make(map[string]pendingCallback, sizeHint),
+		clock:     clock,
+	}
+}
+
+func (rc *requestCallbacks) remove(requestID string) {
+	delete(rc.callbacks, requestID)
+	if len(rc.callbacks) == 0 && rc.drained != nil {
+		close(rc.drained)
+		rc.drained = nil
+	}
+}
+
+//	Closed once no callback is pending
+func (rc *requestCallbacks) Drained() <-chan struct{} {
+	if len(rc.callbacks) == 0 {
+		drained := make(chan struct{})
+		close(drained)
+		return drained
+	}
+	if rc.drained == nil {
+		rc.drained = make(chan struct{})
+	}
+	return rc.drained
+}
+
+func (rc *requestCallbacks) removeExpired() {
+	now := rc.clock.Now()
+	for requestID, pending := range rc.callbacks {
+		if now.After(pending.expiresAt) {
+			rc.remove(requestID)
+		}
+	}
+}
+
+func (rc *requestCallbacks) Add(requestID string, idempotencyKey string, cb chan *callbackT, expiresAt time.Time) {
+	rc.removeExpired()
+	rc.callbacks[requestID] = pendingCallback{
+		cb:             cb,
+		idempotencyKey: idempotencyKey,
+		expiresAt:      expiresAt,
+		lifetime:       expiresAt.Sub(rc.clock.Now()),
+	}
+}
+
+//	Record progress on a pending request, keeping its callback for another
+//	lifetime from now
+func (rc *requestCallbacks) Extend(requestID string) {
+	pending, ok := rc.callbacks[requestID]
+	if !ok {
+		return
+	}
+	now := rc.clock.Now()
+	pending.progressAt = now
+	if expiresAt := now.Add(pending.lifetime); expiresAt.After(pending.expiresAt) {
+		pending.expiresAt = expiresAt
+	}
+	rc.callbacks[requestID] = pending
+}
+
+//	When a partial response to the request last arrived, zero if none has
+func (rc *requestCallbacks) ProgressAt(requestID string) (progressAt time.Time) {
+	return rc.callbacks[requestID].progressAt
+}
+
+func (rc *requestCallbacks) Get(requestID string) (cb chan *callbackT, ok bool) {
+	pending, ok := rc.callbacks[requestID]
+	if ok && rc.clock.Now().After(pending.expiresAt) {
+		rc.remove(requestID)
+		ok = false
+	}
+	cb = pending.cb
+	return
+}
+
+//	A pending request with the given non-empty idempotency key
+func (rc *requestCallbacks) GetByIdempotencyKey(idempotencyKey string) (requestID string, cb chan *callbackT, ok bool) {
+	now := rc.clock.Now()
+	for id, pending := range rc.callbacks {
+		if pending.idempotencyKey == idempotencyKey && !now.After(pending.expiresAt) {
+			return id, pending.cb, true
+		}
+	}
+	return
+}
+
+func (rc *requestCallbacks) Remove(requestID string) {
+	rc.remove(requestID)
+}
+
+func (rc *requestCallbacks) Len() int {
+	return len(rc.callbacks)
+}
+
+//	Forget every pending callback, returning them
+func (rc *requestCallbacks) RemoveAll() (cbs []chan *callbackT) {
+	for requestID, pending := range rc.callbacks {
+		cbs = append(cbs, pending.cb)
+		rc.remove(requestID)
+	}
+	return
+}
@@ -0,0 +1,61 @@
+package krd
+
+import (
+	"context"
+	"errors"
+)
+
+//	Wrapped in a CanceledError when a pending request is canceled by
+//	CancelRequest or CancelAll
+var ErrRequestCanceled = errors.New("Request canceled")
+
+var ErrRequestNotPending = errors.New("No pending request with that ID")
+
+type requestIDKey struct{}
+
+//	Requests made with the returned context use requestID instead of a
+//	randomly generated one, so that they can be passed to CancelRequest.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func requestIDFromContext(ctx context.Context) (requestID string, ok bool) {
+	requestID, ok = ctx.Value(requestIDKey{}).(string)
+	return
+}
+
+//	Abandon a pending request: its waiter returns a CanceledError wrapping
+//	ErrRequestCanceled and any later response is ignored. The phone is not
+//	notified, as the protocol has no cancel message; its prompt is dismissed
+//	when it expires.
+func (ec *EnclaveClient) CancelRequest(requestID string) (err error) {
+	ec.Lock()
+	pendingCb, ok := ec.requestCallbacksByRequestID.Get(requestID)
+	if ok {
+		ec.requestCallbacksByRequestID.Remove(requestID)
+	}
+	ec.Unlock()
+	if !ok {
+		err = ErrRequestNotPending
+		return
+	}
+	ec.log.Notice("canceling request", requestID)
+	select {
+	case pendingCb <- &callbackT{err: &CanceledError{ErrRequestCanceled}}:
+	default:
+	}
+	return
+}
+
+//	Abandon every pending request, as CancelRequest does, e.g. when the user
+//	cancels everything. Returns the number of requests canceled.
+func (ec *EnclaveClient) CancelAll() (canceled int) {
+	ec.Lock()
+	defer ec.Unlock()
+	canceled = ec.requestCallbacksByRequestID.Len()
+	if canceled > 0 {
+		ec.log.Notice("canceling", canceled, "pending requests")
+	}
+	ec.cancelPendingRequests(&callbackT{err: &CanceledError{ErrRequestCanceled}})
+	return
+}
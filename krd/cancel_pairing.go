@@ -0,0 +1,30 @@
+package krd
+
+import (
+	"errors"
+
+	"github.com/kryptco/kr"
+)
+
+var ErrNoPairingInProgress = errors.New("No pairing waiting to be scanned")
+
+//	Abandon pairings started by Pair that the phone has not scanned yet,
+//	removing their bluetooth and USB services and persisted state so the
+//	workstation is not left half paired. Completed pairings, which Pair
+//	keeps, are unaffected. Returns ErrNoPairingInProgress if there is no
+//	pairing to cancel.
+func (ec *EnclaveClient) CancelPairing() (err error) {
+	ec.Lock()
+	defer ec.Unlock()
+	canceled := false
+	for _, ps := range append([]*kr.PairingSecret{}, ec.pairingSecrets...) {
+		if !ps.IsPaired() {
+			ec.unpair(ps, false)
+			canceled = true
+		}
+	}
+	if !canceled {
+		err = ErrNoPairingInProgress
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package krd
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/kryptco/kr"
+	"golang.org/x/crypto/ssh"
+)
+
+//	Sign cert with the paired key as its certificate authority, e.g. to issue
+//	short-lived user certificates. The CA is the cached profile's key, so
+//	RequestMe must have completed. The signature is set on cert and returned.
+func (client *EnclaveClient) RequestCertSignature(cert *ssh.Certificate) (signature *ssh.Signature, err error) {
+	me := client.GetCachedMe()
+	if me == nil {
+		err = ErrNoCachedMe
+		return
+	}
+	ca, err := me.SSHPublicKey()
+	if err != nil {
+		return
+	}
+	err = cert.SignCert(rand.Reader, &enclaveCertSigner{
+		client:      client,
+		ca:          ca,
+		fingerprint: me.PublicKeyFingerprint(),
+	})
+	if err != nil {
+		return
+	}
+	signature = cert.Signature
+	return
+}
+
+//	An ssh.Signer whose key lives on the phone
+type enclaveCertSigner struct {
+	client      *EnclaveClient
+	ca          ssh.PublicKey
+	fingerprint []byte
+}
+
+func (signer *enclaveCertSigner) PublicKey() ssh.PublicKey {
+	return signer.ca
+}
+
+func (signer *enclaveCertSigner) Sign(_ io.Reader, data []byte) (signature *ssh.Signature, err error) {
+	certSignRequest := kr.CertSignRequest{
+		Certificate:          data,
+		PublicKeyFingerprint: signer.fingerprint,
+	}
+	if signer.ca.Type() == ssh.KeyAlgoRSA {
+		//	servers increasingly reject ssh-rsa (SHA1) certificate signatures
+		algorithm := ssh.KeyAlgoRSASHA512
+		certSignRequest.Algorithm = &algorithm
+	}
+
+	request, err := kr.NewRequest()
+	if err != nil {
+		signer.client.log.Error(err)
+		return
+	}
+	request.CertSignRequest = &certSignRequest
+	timeout := request.RequestParameters(signer.client.getTimeouts()).Timeout
+	response, err := signer.client.requestGenericWithTimeouts(context.Background(), request, timeout, nil)
+	if err != nil {
+		return
+	}
+	signResponse := response.CertSignResponse
+	switch {
+	case signResponse == nil:
+		err = &ProtoError{fmt.Errorf("no certificate sign response")}
+		return
+	case signResponse.Error != nil:
+		err = fmt.Errorf("certificate signing failed: %s", *signResponse.Error)
+		return
+	case signResponse.Signature == nil:
+		err = &ProtoError{fmt.Errorf("no signature in certificate sign response")}
+		return
+	}
+
+	format := signer.ca.Type()
+	if certSignRequest.Algorithm != nil {
+		format = *certSignRequest.Algorithm
+	}
+	if signResponse.Algorithm != nil {
+		format = *signResponse.Algorithm
+	}
+	signature = &ssh.Signature{
+		Format: format,
+		Blob:   *signResponse.Signature,
+	}
+	//	a signature over anything but the certificate would produce an
+	//	invalid certificate
+	if verifyErr := signer.ca.Verify(data, signature); verifyErr != nil {
+		signature = nil
+		err = &ProtoError{fmt.Errorf("invalid certificate signature: %v", verifyErr)}
+		return
+	}
+	return
+}
@@ -0,0 +1,25 @@
+package krd
+
+import (
+	"errors"
+)
+
+//	Default cap on received ciphertexts, well above any response the phone
+//	sends
+const DEFAULT_MAX_CIPHERTEXT_SIZE = 1 << 20
+
+//	Returned for received ciphertexts over the maximum size, which are
+//	dropped without attempting to unwrap or decrypt them
+var ErrMessageTooLarge = errors.New("Ciphertext exceeds maximum size")
+
+//	Reject ciphertexts too large to be a response before any work is done on
+//	them
+func (client *EnclaveClient) checkCiphertextSize(ciphertext []byte, medium string) (err error) {
+	if len(ciphertext) <= client.maxCiphertextSize {
+		return
+	}
+	client.metrics.countOversizedCiphertext()
+	client.log.Warning("dropping", len(ciphertext), "byte ciphertext received over", medium)
+	err = ErrMessageTooLarge
+	return
+}
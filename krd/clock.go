@@ -0,0 +1,23 @@
+package krd
+
+import (
+	"time"
+)
+
+//	Source of the current time and timers for request timeouts, retries and
+//	receive backoff, replaceable so tests can drive timeouts without sleeping
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+//	The system clock
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,32 @@
+package krd
+
+import (
+	"errors"
+)
+
+var ErrClosed = errors.New("Enclave client closed")
+
+//	Tear the client down for good, e.g. before recreating it on a config
+//	reload: pending requests fail with ErrClosed, bluetooth and USB are
+//	stopped, event subscriptions are closed, and later requests fail with
+//	ErrClosed. Safe to call more than once.
+func (ec *EnclaveClient) Close() (err error) {
+	ec.Lock()
+	if ec.closed {
+		ec.Unlock()
+		return
+	}
+	ec.closed = true
+	ec.cancelPendingRequests(&callbackT{err: ErrClosed})
+	ec.Unlock()
+
+	err = ec.Stop()
+	ec.events.close()
+	return
+}
+
+func (ec *EnclaveClient) isClosed() bool {
+	ec.Lock()
+	defer ec.Unlock()
+	return ec.closed
+}
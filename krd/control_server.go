@@ -2,14 +2,17 @@ package krd
 
 import (
 	"encoding/json"
+	"errors"
 	"net"
 	"net/http"
 	"path/filepath"
+	"time"
 
 	"github.com/kryptco/kr"
 	sigchain "github.com/kryptco/kr/sigchaingobridge"
 
 	"github.com/op/go-logging"
+	"golang.org/x/crypto/ssh"
 )
 
 type ControlServer struct {
@@ -18,14 +21,14 @@ type ControlServer struct {
 }
 
 func NewControlServer(log *logging.Logger, notifier *kr.Notifier) (cs *ControlServer, err error) {
-	krdir, err := kr.KrDir()
+	pairingDir, err := kr.PairingDir()
 	if err != nil {
 		return
 	}
 	cs = &ControlServer{UnpairedEnclaveClient(
 		kr.AWSTransport{},
 		kr.FilePersister{
-			PairingDir: krdir,
+			PairingDir: pairingDir,
 			SSHDir:     filepath.Join(kr.HomeDir(), ".ssh"),
 		},
 		nil,
@@ -37,13 +40,23 @@ func NewControlServer(log *logging.Logger, notifier *kr.Notifier) (cs *ControlSe
 	return
 }
 
+//	Serve the daemon's local API on listener, the daemon's Unix socket. Each
+//	endpoint takes and returns JSON over HTTP: /status for DaemonStatus, and
+//	/enclave for a kr.Request answered with a kr.Response, e.g. a MeRequest,
+//	ListRequest or SignRequest. krdclient is the client the CLI uses.
 func (cs *ControlServer) HandleControlHTTP(listener net.Listener) (err error) {
 	httpMux := http.NewServeMux()
 	httpMux.HandleFunc("/version", cs.handleVersion)
 	httpMux.HandleFunc("/pair", cs.handlePair)
+	httpMux.HandleFunc("/pair/rotate", cs.handleRotatePair)
+	httpMux.HandleFunc("/pair/devices", cs.handlePairedDevices)
 	httpMux.HandleFunc("/enclave", cs.handleEnclave)
 	httpMux.HandleFunc("/ping", cs.handlePing)
+	httpMux.HandleFunc("/status", cs.handleStatus)
+	httpMux.HandleFunc("/audit", cs.handleAudit)
+	httpMux.HandleFunc("/diagnostics", cs.handleDiagnostics)
 	httpMux.HandleFunc("/dashboard", cs.handleDashboard)
+	httpMux.HandleFunc("/remember", cs.handleForgetHost)
 	err = http.Serve(listener, httpMux)
 	return
 }
@@ -80,12 +93,43 @@ func (cs *ControlServer) handlePair(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//	unpair every device, or only the device given by the id parameter
 func (cs *ControlServer) handleDeletePair(w http.ResponseWriter, r *http.Request) {
+	if id := r.URL.Query().Get("id"); id != "" {
+		if err := cs.enclaveClient.UnpairDevice(id); err != nil {
+			if err == ErrDeviceNotFound {
+				w.WriteHeader(http.StatusNotFound)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			w.Write([]byte(err.Error()))
+			cs.log.Error(err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 	cs.enclaveClient.Unpair()
 	w.WriteHeader(http.StatusOK)
 	return
 }
 
+func (cs *ControlServer) handlePairedDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	devices := cs.enclaveClient.PairedDevices()
+	if devices == nil {
+		devices = []kr.PairedDevice{}
+	}
+	err := json.NewEncoder(w).Encode(devices)
+	if err != nil {
+		cs.log.Error(err)
+		return
+	}
+}
+
 //	check if pairing completed
 func (cs *ControlServer) handleGetPair(w http.ResponseWriter, r *http.Request) {
 	var meRequest kr.MeRequest
@@ -110,7 +154,8 @@ func (cs *ControlServer) handleGetPair(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-//	initiate new pairing (clearing any existing)
+//	initiate new pairing, clearing any existing unless the options ask to
+//	add a phone
 func (cs *ControlServer) handlePutPair(w http.ResponseWriter, r *http.Request) {
 	var paringOptions kr.PairingOptions
 	err := json.NewDecoder(r.Body).Decode(&paringOptions)
@@ -133,6 +178,36 @@ func (cs *ControlServer) handlePutPair(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+//	rotate the pairing keys. Responds 202 with the new pairing secret if the
+//	phone must scan it instead.
+func (cs *ControlServer) handleRotatePair(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	pairingSecret, rotated, err := cs.enclaveClient.RotatePairing()
+	if err != nil {
+		if errors.Is(err, ErrNotPaired) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		}
+		cs.log.Error(err)
+		return
+	}
+	if rotated {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(pairingSecret)
+	if err != nil {
+		cs.log.Error(err)
+		return
+	}
+}
+
 //	route request to enclave
 func (cs *ControlServer) handleEnclave(w http.ResponseWriter, r *http.Request) {
 	var enclaveRequest kr.Request
@@ -150,6 +225,7 @@ func (cs *ControlServer) handleEnclave(w http.ResponseWriter, r *http.Request) {
 	if enclaveRequest.SignRequest != nil ||
 		enclaveRequest.GitSignRequest != nil ||
 		enclaveRequest.HostsRequest != nil ||
+		enclaveRequest.ListRequest != nil ||
 		enclaveRequest.ReadTeamRequest != nil ||
 		enclaveRequest.TeamOperationRequest != nil ||
 		enclaveRequest.LogDecryptionRequest != nil {
@@ -175,8 +251,8 @@ func (cs *ControlServer) handleEnclaveMe(w http.ResponseWriter, enclaveRequest k
 		meResponse, err := cs.enclaveClient.RequestMe(meRequest, false)
 		if err != nil {
 			cs.log.Error("me request error:", err)
-			switch err {
-			case ErrNotPaired:
+			switch {
+			case errors.Is(err, ErrNotPaired):
 				w.WriteHeader(http.StatusNotFound)
 			default:
 				w.WriteHeader(http.StatusInternalServerError)
@@ -211,8 +287,8 @@ func (cs *ControlServer) handleEnclaveGeneric(w http.ResponseWriter, enclaveRequ
 
 	if err != nil {
 		cs.log.Error("request error:", err)
-		switch err {
-		case ErrNotPaired:
+		switch {
+		case errors.Is(err, ErrNotPaired):
 			w.WriteHeader(http.StatusNotFound)
 		default:
 			w.WriteHeader(http.StatusInternalServerError)
@@ -232,6 +308,105 @@ func (cs *ControlServer) handlePing(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (cs *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	stats := cs.enclaveClient.Stats()
+	status := kr.DaemonStatus{
+		Paired:             stats.Paired,
+		PendingRequests:    stats.PendingRequests,
+		OutgoingQueueDepth: stats.OutgoingQueueDepth,
+		KeyUnwraps:         stats.KeyUnwraps,
+	}
+	if !stats.LastKeyUnwrap.IsZero() {
+		status.LastKeyUnwrap = &stats.LastKeyUnwrap
+	}
+	if me := cs.enclaveClient.GetCachedMe(); me != nil {
+		email := me.Email
+		status.Email = &email
+		if pk, err := me.SSHPublicKey(); err == nil {
+			fingerprint := ssh.FingerprintSHA256(pk)
+			status.PublicKeyFingerprint = &fingerprint
+		}
+	}
+	btStatus, btErr := cs.enclaveClient.BluetoothStatus()
+	status.Bluetooth = btStatus.String()
+	if btErr != nil {
+		errStr := btErr.Error()
+		status.BluetoothError = &errStr
+	}
+	auditLog := cs.enclaveClient.AuditLog()
+	for i := len(auditLog) - 1; i >= 0; i-- {
+		if auditLog[i].Succeeded {
+			status.LastSignature = &auditLog[i].Time
+			break
+		}
+	}
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil {
+		cs.log.Error(err)
+		return
+	}
+}
+
+func (cs *ControlServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	auditLog := cs.enclaveClient.AuditLog()
+	if auditLog == nil {
+		auditLog = []SignatureAuditEntry{}
+	}
+	err := json.NewEncoder(w).Encode(auditLog)
+	if err != nil {
+		cs.log.Error(err)
+		return
+	}
+}
+
+//	With ?ping=true, also pings the phone and reports the latency or error
+func (cs *ControlServer) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	diagnostics := cs.enclaveClient.Diagnostics()
+	if r.URL.Query().Get("ping") == "true" {
+		latency, err := cs.enclaveClient.Ping()
+		if err != nil {
+			errStr := err.Error()
+			diagnostics.PingError = &errStr
+		} else {
+			latencyMillis := int64(latency / time.Millisecond)
+			diagnostics.PingLatencyMillis = &latencyMillis
+		}
+	}
+	err := json.NewEncoder(w).Encode(diagnostics)
+	if err != nil {
+		cs.log.Error(err)
+		return
+	}
+}
+
+//	forget the approval remembered for the host parameter, so the phone
+//	prompts for it again
+func (cs *ControlServer) handleForgetHost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	cs.enclaveClient.ForgetHost(host)
+	w.WriteHeader(http.StatusOK)
+}
+
 func (cs *ControlServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
 	sigchain.ServeDashboard()
 	w.WriteHeader(http.StatusOK)
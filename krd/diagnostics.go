@@ -0,0 +1,67 @@
+package krd
+
+import (
+	"github.com/kryptco/kr"
+)
+
+//	Number of audit log entries included in Diagnostics
+const DIAGNOSTICS_AUDIT_ENTRIES = 10
+
+//	Snapshot of the client for bug reports. Secrets are left out: pairings
+//	are identified by their service UUID, push endpoints only by presence,
+//	and signature requests without their command or metadata.
+func (ec *EnclaveClient) Diagnostics() (diagnostics kr.Diagnostics) {
+	stats := ec.Stats()
+	diagnostics.Version = kr.CURRENT_VERSION.String()
+	diagnostics.Paired = stats.Paired
+	diagnostics.PendingRequests = stats.PendingRequests
+	diagnostics.OutgoingQueueDepth = stats.OutgoingQueueDepth
+
+	for _, ps := range ec.getPairingSecrets() {
+		id, err := ps.DeriveUUID()
+		if err != nil {
+			ec.log.Error("error deriving pairing UUID:", err)
+			continue
+		}
+		pairing := kr.PairingDiagnostics{
+			ID:             id.String(),
+			Paired:         ps.IsPaired(),
+			ReadOnly:       ps.ReadOnly,
+			HasSNSEndpoint: ps.GetSNSEndpointARN() != nil,
+		}
+		if version, ok := ec.getEnclaveVersion(ps); ok {
+			versionString := version.String()
+			pairing.EnclaveVersion = &versionString
+		}
+		diagnostics.Pairings = append(diagnostics.Pairings, pairing)
+	}
+
+	btStatus, btErr := ec.BluetoothStatus()
+	diagnostics.Bluetooth = btStatus.String()
+	if btErr != nil {
+		errStr := btErr.Error()
+		diagnostics.BluetoothError = &errStr
+	}
+
+	ec.Lock()
+	transports := append([]PhoneTransport{}, ec.transports...)
+	ec.Unlock()
+	for _, transport := range transports {
+		diagnostics.Transports = append(diagnostics.Transports, kr.TransportDiagnostics{
+			Medium:    transport.Medium(),
+			Connected: transport.Connected(),
+		})
+	}
+
+	auditLog := ec.AuditLog()
+	if len(auditLog) > DIAGNOSTICS_AUDIT_ENTRIES {
+		auditLog = auditLog[len(auditLog)-DIAGNOSTICS_AUDIT_ENTRIES:]
+	}
+	diagnostics.RecentSignatures = []SignatureAuditEntry{}
+	for _, entry := range auditLog {
+		entry.Command = nil
+		entry.Metadata = nil
+		diagnostics.RecentSignatures = append(diagnostics.RecentSignatures, entry)
+	}
+	return
+}
@@ -5,6 +5,7 @@ package krd
  */
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,12 +15,26 @@ import (
 	"github.com/blang/semver"
 	"github.com/golang/groupcache/lru"
 	"github.com/kryptco/kr"
-	"github.com/op/go-logging"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/ssh"
 )
 
 var ErrTimeout = errors.New("Request timed out")
+
+//	Returned by every request path when no pairing has completed
 var ErrNotPaired = errors.New("Phone not paired")
 
+//	No pairing could decrypt a ciphertext, e.g. one addressed to another
+//	workstation sharing the queue. Counted and ignored rather than treated as
+//	a failure.
+var ErrForeignCiphertext = errors.New("Ciphertext not for any pairing")
+
+//	Returned to requests still pending when the client is stopped
+var ErrStopped = errors.New("Enclave client stopped")
+
+//	Returned to requests still pending when Pair is called
+var ErrRepaired = errors.New("Enclave client re-paired")
+
 //	Message queued during send
 type SendQueued struct {
 	error
@@ -56,19 +71,78 @@ func (err *ProtoError) Error() string {
 	return fmt.Sprintf("ProtoError: " + err.error.Error())
 }
 
+//	Request abandoned because its context was canceled or expired
+type CanceledError struct {
+	error
+}
+
+func (err *CanceledError) Error() string {
+	return fmt.Sprintf("Canceled: %s", err.error)
+}
+
+func (err *CanceledError) Unwrap() error {
+	return err.error
+}
+
 type EnclaveClientI interface {
 	kr.Transport
 	Pair(kr.PairingOptions) (pairing *kr.PairingSecret, err error)
+	PairAndWait(ctx context.Context, pairingOptions kr.PairingOptions, onPairingSecret func(*kr.PairingSecret)) (*kr.Profile, error)
 	IsPaired() bool
 	Unpair()
+	CancelPairing() error
+	PairedDevices() []kr.PairedDevice
+	PairingFingerprint() (string, error)
+	PairedSince() (time.Time, bool)
+	UnpairDevice(id string) error
 	Start() (err error)
 	Stop() (err error)
+	Close() (err error)
 	RequestMe(meRequest kr.MeRequest, isPairing bool) (*kr.MeResponse, error)
+	RequestMeCtx(ctx context.Context, meRequest kr.MeRequest, isPairing bool) (*kr.MeResponse, error)
 	GetCachedMe() *kr.Profile
+	WaitForMe(ctx context.Context) (*kr.Profile, error)
+	RequestMeOrCached() (me *kr.Profile, stale bool, err error)
+	RefreshMe() (*kr.Profile, error)
+	CachedPublicKeyWire() ([]byte, error)
+	CachedAuthorizedKeyLine() (string, error)
 	RequestSignature(kr.SignRequest, func()) (*kr.SignResponse, semver.Version, error)
+	RequestSignatureWithTimeout(kr.SignRequest, time.Duration, func()) (*kr.SignResponse, semver.Version, error)
+	RequestSignatureCtx(context.Context, kr.SignRequest, func()) (*kr.SignResponse, semver.Version, error)
+	RequestSignatureBatch([]kr.SignRequest) ([]*kr.SignResponse, error)
+	RequestCertSignature(*ssh.Certificate) (*ssh.Signature, error)
+	ValidateSignRequest(kr.SignRequest) error
+	RequestList() (*kr.ListResponse, error)
+	RequestListCtx(context.Context) (*kr.ListResponse, error)
+	RequestListStream(context.Context) <-chan ListChunk
+	AvailableKeys() ([]KeyInfo, error)
 	RequestGitSignature(kr.GitSignRequest, func()) (*kr.GitSignResponse, semver.Version, error)
 	RequestGeneric(kr.Request, func()) (kr.Response, error)
+	RawRequest(kr.Request, time.Duration) (*kr.Response, error)
 	RequestNoOp() error
+	QueueDepth() int
+	Stats() Stats
+	SetRequestTimeouts(me, sign, list time.Duration)
+	SetRetryPolicy(RetryPolicy)
+	SetLogger(kr.Logger)
+	Subscribe() <-chan PairingEvent
+	Unsubscribe(<-chan PairingEvent)
+	Ping() (time.Duration, error)
+	Warmup(ctx context.Context) error
+	CancelRequest(requestID string) error
+	CancelAll() int
+	Metrics() *Metrics
+	ExportPairing(passphrase string) ([]byte, error)
+	ImportPairing(data []byte, passphrase string) error
+	RotatePairing() (pairing *kr.PairingSecret, rotated bool, err error)
+	BluetoothStatus() (BluetoothStatus, error)
+	SetRememberHostTTL(time.Duration)
+	ForgetHost(host string)
+	ForgetAllHosts()
+	SetHostPolicy(HostPolicy) error
+	AuditLog() []SignatureAuditEntry
+	Diagnostics() kr.Diagnostics
+	RetryLast() (kr.Response, error)
 }
 
 type EnclaveClient struct {
@@ -76,35 +150,98 @@ type EnclaveClient struct {
 	kr.Transport
 	kr.Timeouts
 	kr.Persister
-	pairingSecret               *kr.PairingSecret
-	requestCallbacksByRequestID *lru.Cache
+	pairingSecrets              []*kr.PairingSecret
+	requestCallbacksByRequestID *requestCallbacks
 	ackedRequestIDs             *lru.Cache
-	outgoingQueue               [][]byte
+	outgoingQueue               outgoingQueue
 	snsEndpointARN              *string
 	cachedMe                    *kr.Profile
 	bt                          BluetoothDriverI
-	log                         *logging.Logger
+	log                         *swappableLogger
 	notifier                    *kr.Notifier
 	lastActivityByMedium        map[string]time.Time
+	retryPolicy                 RetryPolicy
+	events                      pairingEvents
+	signFlights                 *lru.Cache
+	pingTimeout                 time.Duration
+	btStatus                    BluetoothStatus
+	btErr                       error
+	btRestarts                  int
+	auditLog                    []SignatureAuditEntry
+	auditLogSize                int
+	metrics                     *Metrics
+	btDone                      chan struct{}
+	usbDone                     chan struct{}
+	stopGracePeriod             time.Duration
+	requestCacheSize            int
+	pairingRotations            map[string]*pairingRotation
+	meRefresh                   *meRefresh
+	btWrites                    chan struct{}
+	btWriteTimeout              time.Duration
+	btWriteFailures             int32
+	btWatchdog                  chan BluetoothDriverI
+	usb                         BluetoothDriverI
+	transports                  []PhoneTransport
+	clock                       Clock
+	newBluetoothDriver          func() (BluetoothDriverI, error)
+	newUSBDriver                func() (BluetoothDriverI, error)
+	closed                      bool
+	rememberHostTTL             time.Duration
+	localOnly                   bool
+	hostApprovals               kr.HostApprovals
+	availableKeys               []KeyInfo
+	availableKeysAt             time.Time
+	relay                       *kr.RelayConfig
+	deriveServiceUUID           func(*kr.PairingSecret) (uuid.UUID, error)
+	hostPolicy                  HostPolicy
+	enclaveVersions             map[string]semver.Version
+	rateLimits                  map[string]*tokenBucket
+	queueReceivers              map[string]*queueReceiver
+	maxCiphertextSize           int
+	lastFailed                  *failedRequest
 }
 
+const DEFAULT_REQUEST_CACHE_SIZE = 128
+
 const BLUETOOTH = "bluetooth"
 const SQS = "sqs"
 
+//	Queued messages older than this are dropped rather than sent once the
+//	pairing completes
+const MAX_QUEUED_MESSAGE_AGE = 5 * time.Minute
+
+//	Bounds of the wait between empty queue reads while a request is pending
+const MIN_RECEIVE_BACKOFF = 100 * time.Millisecond
+const MAX_RECEIVE_BACKOFF = 2 * time.Second
+
+//	Bounds of the wait before reading a queue again after a transient error,
+//	e.g. a network blip
+const MIN_RECEIVE_ERROR_BACKOFF = 250 * time.Millisecond
+const MAX_RECEIVE_ERROR_BACKOFF = 4 * time.Second
+
+//	How long a request sent only over USB or bluetooth waits for the phone to
+//	answer on the same link before also polling the queue
+const LOCAL_RESPONSE_GRACE = time.Second
+
+//	Pair a phone, replacing existing pairings unless pairingOptions.Add is
+//	set, in which case completed pairings are kept and requests are sent to
+//	all of them. Requests in flight are invalidated by re-pairing: they fail
+//	with ErrRepaired rather than waiting out their timeouts.
 func (ec *EnclaveClient) Pair(pairingOptions kr.PairingOptions) (pairingSecret *kr.PairingSecret, err error) {
 	ec.Lock()
 	defer ec.Unlock()
-
-	err = ec.generatePairing(pairingOptions)
-	if err != nil {
+	if ec.closed {
+		err = ErrClosed
 		return
 	}
-	err = ec.activatePairing()
+	ec.cancelPendingRequests(&callbackT{err: ErrRepaired})
+
+	pairingSecret, err = ec.generatePairing(pairingOptions)
 	if err != nil {
 		return
 	}
-
-	pairingSecret = ec.pairingSecret
+	ec.activatePairing(pairingSecret)
+	ec.events.publish(PairingEvent{Type: PairingEventPaired})
 
 	return
 }
@@ -112,60 +249,128 @@ func (ec *EnclaveClient) Pair(pairingOptions kr.PairingOptions) (pairingSecret *
 func (ec *EnclaveClient) Unpair() {
 	ec.Lock()
 	defer ec.Unlock()
-	if ec.pairingSecret != nil {
-		ec.unpair(ec.pairingSecret, true)
+	for _, ps := range append([]*kr.PairingSecret{}, ec.pairingSecrets...) {
+		ec.unpair(ps, true)
 	}
 	return
 }
 
 func (ec *EnclaveClient) IsPaired() bool {
-	ps := ec.getPairingSecret()
-	if ps == nil {
-		return false
+	for _, ps := range ec.getPairingSecrets() {
+		if ps.IsPaired() {
+			return true
+		}
+	}
+	return false
+}
+
+//	Fingerprint of the phone this workstation is paired with, available as
+//	soon as the pairing completes rather than once the profile arrives
+func (ec *EnclaveClient) PairingFingerprint() (fingerprint string, err error) {
+	pairingSecret := ec.getPairingSecret()
+	if pairingSecret == nil || !pairingSecret.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+	return pairingSecret.Fingerprint()
+}
+
+//	When the current pairing was created. ok is false if not paired or the
+//	pairing predates the recorded time.
+func (ec *EnclaveClient) PairedSince() (since time.Time, ok bool) {
+	pairingSecret := ec.getPairingSecret()
+	if pairingSecret == nil || !pairingSecret.IsPaired() {
+		return
 	}
-	return ps.IsPaired()
+	return pairingSecret.PairedSince()
 }
 
-func (ec *EnclaveClient) generatePairing(pairingOptions kr.PairingOptions) (err error) {
-	if ec.pairingSecret != nil {
-		ec.unpair(ec.pairingSecret, true)
+func (ec *EnclaveClient) generatePairing(pairingOptions kr.PairingOptions) (pairingSecret *kr.PairingSecret, err error) {
+	//	pairings that never completed are abandoned, completed ones too
+	//	unless adding a phone
+	for _, ps := range append([]*kr.PairingSecret{}, ec.pairingSecrets...) {
+		if !ps.IsPaired() || !pairingOptions.Add {
+			ec.unpair(ps, ps.IsPaired())
+		}
+	}
+	if len(ec.pairingSecrets) == 0 {
+		ec.cachedMe = nil
+		ec.Persister.DeleteMe()
+		ec.outgoingQueue.clear()
+		ec.saveOutgoingQueue()
 	}
-	ec.Persister.DeleteMe()
-	ec.Persister.DeletePairing()
 
-	pairingSecret, err := kr.GeneratePairingSecret(pairingOptions.WorkstationName)
+	pairingSecret, err = kr.GeneratePairingSecret(pairingOptions.WorkstationName)
 	if err != nil {
 		ec.log.Error(err)
 		return
 	}
+	pairingSecret.ReadOnly = pairingOptions.ReadOnly
+	pairingSecret.Relay = pairingOptions.Relay
+	if pairingSecret.Relay == nil {
+		pairingSecret.Relay = ec.relay
+	}
 
-	go func() {
-		setupErr := ec.Transport.Setup(pairingSecret)
-		if setupErr != nil {
-			ec.log.Error(setupErr)
-		}
-	}()
+	if !ec.localOnly {
+		go func() {
+			setupErr := ec.Transport.Setup(pairingSecret)
+			if setupErr != nil {
+				ec.log.Error(setupErr)
+			}
+		}()
+	}
 
-	//	erase any existing pairing
-	ec.pairingSecret = pairingSecret
-	ec.outgoingQueue = [][]byte{}
+	ec.pairingSecrets = append(ec.pairingSecrets, pairingSecret)
+	ec.savePairings()
+	return
+}
 
-	savePairingErr := ec.Persister.SavePairing(pairingSecret)
+//	Must be called with ec locked
+func (ec *EnclaveClient) savePairings() {
+	savePairingErr := ec.Persister.SavePairings(ec.pairingSecrets)
 	if savePairingErr != nil {
 		ec.log.Error("error saving pairing:", savePairingErr.Error())
 	}
-	return
+}
+
+//	Must be called with ec locked
+func (ec *EnclaveClient) saveOutgoingQueue() {
+	saveQueueErr := ec.Persister.SaveQueuedMessages(ec.outgoingQueue.messages)
+	if saveQueueErr != nil {
+		ec.log.Error("error saving outgoing queue:", saveQueueErr.Error())
+	}
+}
+
+//	Must be called with ec locked
+func (ec *EnclaveClient) findPairing(pairingSecret *kr.PairingSecret) (index int) {
+	for i, ps := range ec.pairingSecrets {
+		if ps.Equals(pairingSecret) {
+			return i
+		}
+	}
+	return -1
 }
 
 func (ec *EnclaveClient) unpair(pairingSecret *kr.PairingSecret, sendUnpairRequest bool) (err error) {
-	if ec.pairingSecret == nil || !ec.pairingSecret.Equals(pairingSecret) {
+	index := ec.findPairing(pairingSecret)
+	if index < 0 {
 		return
 	}
 	ec.deactivatePairing(pairingSecret)
-	ec.cachedMe = nil
-	ec.pairingSecret = nil
-	ec.Persister.DeleteMe()
-	ec.Persister.DeletePairing()
+	delete(ec.pairingRotations, string(pairingSecret.WorkstationPublicKey))
+	delete(ec.enclaveVersions, string(pairingSecret.WorkstationPublicKey))
+	ec.availableKeys = nil
+	ec.pairingSecrets = append(ec.pairingSecrets[:index:index], ec.pairingSecrets[index+1:]...)
+	if len(ec.pairingSecrets) == 0 {
+		ec.cachedMe = nil
+		ec.Persister.DeleteMe()
+		ec.Persister.DeletePairings()
+		ec.outgoingQueue.clear()
+		ec.saveOutgoingQueue()
+	} else {
+		ec.savePairings()
+	}
+	ec.events.publish(PairingEvent{Type: PairingEventUnpaired})
 	if sendUnpairRequest {
 		func() {
 			unpairRequest, err := kr.NewRequest()
@@ -179,7 +384,7 @@ func (ec *EnclaveClient) unpair(pairingSecret *kr.PairingSecret, sendUnpairReque
 				ec.log.Error("error creating request:", err)
 				return
 			}
-			go ec.sendMessage(pairingSecret, unpairJson, false, false, false)
+			go ec.sendMessage(pairingSecret, unpairJson, sendOptions{requestID: unpairRequest.RequestID})
 		}()
 	}
 	return
@@ -195,83 +400,219 @@ func (ec *EnclaveClient) deactivatePairing(pairingSecret *kr.PairingSecret) (err
 			}
 		}
 	}
+	if ec.usb != nil {
+		if usbUUID, uuidErr := pairingSecret.DeriveUUID(); uuidErr == nil {
+			ec.usb.RemoveService(usbUUID)
+		}
+	}
 	return
 }
 
-func (ec *EnclaveClient) activatePairing() (err error) {
+//	Add the pairing's service to bluetooth and USB. If its service UUID
+//	cannot be derived the pairing still works over SQS/SNS, and the
+//	bluetooth status reports the reason. Must be called with ec locked
+func (ec *EnclaveClient) activatePairing(pairingSecret *kr.PairingSecret) {
+	if ec.bt == nil && ec.usb == nil {
+		return
+	}
+	serviceUUID, err := ec.deriveServiceUUID(pairingSecret)
+	if err != nil {
+		ec.log.Error("no bluetooth or USB service for pairing, continuing over SQS:", err)
+		ec.setBluetoothStatus(BluetoothError, fmt.Errorf("deriving pairing service UUID: %v", err))
+		return
+	}
 	if ec.bt != nil {
-		if ec.pairingSecret != nil {
-			btUUID, uuidErr := ec.pairingSecret.DeriveUUID()
-			if uuidErr != nil {
-				err = uuidErr
-				ec.log.Error(err)
-				return
-			}
-			btErr := ec.bt.AddService(btUUID)
-			if btErr != nil {
-				ec.log.Error(btErr)
-			}
+		btErr := ec.bt.AddService(serviceUUID)
+		if btErr != nil {
+			ec.log.Error(btErr)
 		}
 	}
-	return
+	if ec.usb != nil {
+		ec.usb.AddService(serviceUUID)
+	}
 }
+
+//	Waits up to the stop grace period for pending requests to complete, then
+//	fails the remaining ones with ErrStopped and stops bluetooth and USB.
 func (ec *EnclaveClient) Stop() (err error) {
+	if gracePeriod := ec.getStopGracePeriod(); gracePeriod > 0 {
+		ec.Lock()
+		drained := ec.requestCallbacksByRequestID.Drained()
+		ec.Unlock()
+		select {
+		case <-drained:
+		case <-ec.clock.After(gracePeriod):
+		}
+	}
+
 	ec.Lock()
 	defer ec.Unlock()
+	ec.cancelPendingRequests(&callbackT{err: ErrStopped})
+	if ec.btDone != nil {
+		close(ec.btDone)
+		ec.btDone = nil
+	}
 	if ec.bt != nil {
 		ec.bt.Stop()
 	}
+	if ec.usbDone != nil {
+		close(ec.usbDone)
+		ec.usbDone = nil
+	}
+	if ec.usb != nil {
+		ec.usb.Stop()
+		ec.usb = nil
+	}
+	ec.setBluetoothStatus(BluetoothDisabled, nil)
 	return
 }
 
+//	How long Stop lets pending requests, such as a signature awaiting
+//	approval, complete before failing them. Zero by default.
+func (ec *EnclaveClient) SetStopGracePeriod(gracePeriod time.Duration) {
+	ec.Lock()
+	defer ec.Unlock()
+	ec.stopGracePeriod = gracePeriod
+}
+
+func (ec *EnclaveClient) getStopGracePeriod() time.Duration {
+	ec.Lock()
+	defer ec.Unlock()
+	return ec.stopGracePeriod
+}
+
+func (ec *EnclaveClient) pendingRequestCount() int {
+	ec.Lock()
+	defer ec.Unlock()
+	return ec.requestCallbacksByRequestID.Len()
+}
+
+//	Deliver callback to every pending request and forget them. A nil
+//	callback makes waiters fail as if evicted. Must be called with ec locked
+func (ec *EnclaveClient) cancelPendingRequests(callback *callbackT) {
+	for _, cb := range ec.requestCallbacksByRequestID.RemoveAll() {
+		select {
+		case cb <- callback:
+		default:
+		}
+	}
+}
+
 func (ec *EnclaveClient) Start() (err error) {
 	ec.Lock()
 	defer ec.Unlock()
-	loadedPairing, loadErr := ec.Persister.LoadPairing()
-	if loadErr == nil && loadedPairing != nil {
-		ec.pairingSecret = loadedPairing
+	if ec.closed {
+		err = ErrClosed
+		return
+	}
+	loadedPairings, loadErr := ec.Persister.LoadPairings()
+	if loadErr == nil && len(loadedPairings) > 0 {
+		ec.pairingSecrets = loadedPairings
 	} else {
 		ec.log.Notice("pairing not loaded:", loadErr)
 	}
 
+	if loadedQueue, loadQueueErr := ec.Persister.LoadQueuedMessages(); loadQueueErr == nil {
+		ec.outgoingQueue.load(freshQueuedMessages(loadedQueue, ec.clock.Now()))
+	}
+
+	if loadedApprovals, loadApprovalsErr := ec.Persister.LoadHostApprovals(); loadApprovalsErr == nil {
+		loadedApprovals.Expire(ec.clock.Now())
+		ec.hostApprovals = loadedApprovals
+	}
+
 	if loadedMe, loadMeErr := ec.Persister.LoadMe(); loadMeErr == nil {
 		ec.cachedMe = &loadedMe
 		ec.Persister.SaveMySSHPubKey(*ec.cachedMe)
 	} else {
-		ec.log.Notice("me not loaded:", loadErr)
+		ec.log.Notice("me not loaded:", loadMeErr)
 	}
 
-	bt, err := NewBluetoothDriver()
-	if err != nil {
+	btDone := make(chan struct{})
+	ec.btDone = btDone
+	if bluetoothDisabledByEnv() {
+		ec.log.Notice("bluetooth disabled by " + DISABLE_BLUETOOTH_ENV)
+		ec.setBluetoothStatus(BluetoothDisabled, nil)
+	} else if bt, btErr := ec.newBluetoothDriver(); btErr != nil {
+		err = btErr
 		ec.log.Error("error starting bluetooth driver:", err)
+		ec.setBluetoothStatus(BluetoothError, err)
+		go ec.superviseBluetooth(nil, btDone)
 	} else {
 		ec.bt = bt
-		go func() {
-			readChan, err := ec.bt.ReadChan()
-			if err != nil {
-				ec.log.Error("error retrieving bluetooth read channel:", err)
-				return
-			}
-			for ciphertext := range readChan {
-				err = ec.handleCiphertext(ciphertext, BLUETOOTH)
-				if err != nil {
-					ec.log.Error("error reading bluetooth channel:", err)
-				}
-			}
-		}()
+		ec.setBluetoothStatus(BluetoothScanning, nil)
+		go ec.superviseBluetooth(bt, btDone)
 	}
+	usbDone := make(chan struct{})
+	ec.usbDone = usbDone
+	go ec.detectUSB(usbDone)
 
-	ec.activatePairing()
+	for _, ps := range ec.pairingSecrets {
+		ec.activatePairing(ps)
+	}
 	return
 }
 
+func (ec *EnclaveClient) getPairingSecrets() (pairingSecrets []*kr.PairingSecret) {
+	ec.Lock()
+	defer ec.Unlock()
+	pairingSecrets = append(pairingSecrets, ec.pairingSecrets...)
+	return
+}
+
+//	The first completed pairing, or the first pending one if none completed
 func (ec *EnclaveClient) getPairingSecret() (pairingSecret *kr.PairingSecret) {
+	pairingSecrets := ec.getPairingSecrets()
+	for _, ps := range pairingSecrets {
+		if ps.IsPaired() {
+			return ps
+		}
+	}
+	if len(pairingSecrets) > 0 {
+		pairingSecret = pairingSecrets[0]
+	}
+	return
+}
+
+//	Pairings a request should be sent to: all completed pairings, or the
+//	pending pairings for a request completing a pairing. Falls back to every
+//	pairing when there are none of the preferred kind.
+func (ec *EnclaveClient) getRequestPairingSecrets(isPairing bool) (pairingSecrets []*kr.PairingSecret) {
+	all := ec.getPairingSecrets()
+	for _, ps := range all {
+		if ps.IsPaired() != isPairing {
+			pairingSecrets = append(pairingSecrets, ps)
+		}
+	}
+	if len(pairingSecrets) == 0 {
+		pairingSecrets = all
+	}
+	return
+}
+
+func (ec *EnclaveClient) getTimeouts() (timeouts kr.Timeouts) {
 	ec.Lock()
 	defer ec.Unlock()
-	pairingSecret = ec.pairingSecret
+	timeouts = ec.Timeouts
 	return
 }
 
+//	Override the failure timeouts for me, sign, and list requests. A zero
+//	duration leaves the corresponding timeout unchanged.
+func (ec *EnclaveClient) SetRequestTimeouts(me, sign, list time.Duration) {
+	ec.Lock()
+	defer ec.Unlock()
+	if me > 0 {
+		ec.Timeouts.Me.Fail = me
+	}
+	if sign > 0 {
+		ec.Timeouts.Sign.Fail = sign
+	}
+	if list > 0 {
+		ec.Timeouts.List.Fail = list
+	}
+}
+
 func (ec *EnclaveClient) GetCachedMe() (me *kr.Profile) {
 	ec.Lock()
 	defer ec.Unlock()
@@ -289,24 +630,54 @@ func (ec *EnclaveClient) postEvent(category string, action string, label *string
 	}
 }
 
-func UnpairedEnclaveClient(transport kr.Transport, persister kr.Persister, timeoutsOverride *kr.Timeouts, log *logging.Logger, notifier *kr.Notifier) EnclaveClientI {
+func UnpairedEnclaveClient(transport kr.Transport, persister kr.Persister, timeoutsOverride *kr.Timeouts, log kr.Logger, notifier *kr.Notifier, opts ...EnclaveClientOption) EnclaveClientI {
 	var timeouts = kr.DefaultTimeouts()
 	if timeoutsOverride != nil {
 		timeouts = *timeoutsOverride
 	}
-	return &EnclaveClient{
-		Transport:                   transport,
-		Persister:                   persister,
-		Timeouts:                    timeouts,
-		requestCallbacksByRequestID: lru.New(128),
-		ackedRequestIDs:             lru.New(128),
-		log:                         log,
-		notifier:                    notifier,
-		lastActivityByMedium:        map[string]time.Time{},
+	ec := &EnclaveClient{
+		Transport:            transport,
+		Persister:            persister,
+		Timeouts:             timeouts,
+		log:                  newSwappableLogger(log),
+		notifier:             notifier,
+		lastActivityByMedium: map[string]time.Time{},
+		retryPolicy:          DefaultRetryPolicy(),
+		auditLogSize:         DEFAULT_AUDIT_LOG_SIZE,
+		metrics:              NewMetrics(),
+		requestCacheSize:     DEFAULT_REQUEST_CACHE_SIZE,
+		pairingRotations:     map[string]*pairingRotation{},
+		btWrites:             make(chan struct{}, MAX_PENDING_BLUETOOTH_WRITES),
+		btWriteTimeout:       DEFAULT_BLUETOOTH_WRITE_TIMEOUT,
+		btWatchdog:           make(chan BluetoothDriverI, 1),
+		enclaveVersions:      map[string]semver.Version{},
+		clock:                realClock{},
+		newBluetoothDriver:   newPlatformBluetoothDriver,
+		newUSBDriver:         NewUSBDriver,
+		hostApprovals:        kr.NewHostApprovals(),
+		localOnly:            snsDisabledByEnv(),
+		relay:                kr.RelayConfigFromEnv(),
+		deriveServiceUUID:    (*kr.PairingSecret).DeriveUUID,
+		rateLimits:           map[string]*tokenBucket{},
+		queueReceivers:       map[string]*queueReceiver{},
+		maxCiphertextSize:    DEFAULT_MAX_CIPHERTEXT_SIZE,
+	}
+	ec.transports = []PhoneTransport{&usbTransport{ec}, &bluetoothTransport{ec}, &queueTransport{ec}}
+	for _, opt := range opts {
+		opt(ec)
 	}
+	ec.requestCallbacksByRequestID = newRequestCallbacks(ec.requestCacheSize, ec.clock)
+	ec.metrics.signatureLatencies.clock = ec.clock
+	ec.ackedRequestIDs = lru.New(ec.requestCacheSize)
+	ec.signFlights = lru.New(ec.requestCacheSize)
+	return ec
 }
 
 func (client *EnclaveClient) RequestMe(meSubrequest kr.MeRequest, isPairing bool) (meResponse *kr.MeResponse, err error) {
+	return client.RequestMeCtx(context.Background(), meSubrequest, isPairing)
+}
+
+func (client *EnclaveClient) RequestMeCtx(ctx context.Context, meSubrequest kr.MeRequest, isPairing bool) (meResponse *kr.MeResponse, err error) {
 	if !isPairing && !client.IsPaired() {
 		err = ErrNotPaired
 		return
@@ -317,6 +688,9 @@ func (client *EnclaveClient) RequestMe(meSubrequest kr.MeRequest, isPairing bool
 		return
 	}
 	meRequest.MeRequest = &meSubrequest
+	if requestID, ok := requestIDFromContext(ctx); ok {
+		meRequest.RequestID = requestID
+	}
 	if meRequest.MeRequest.PGPUserId == nil {
 		client.log.Notice("no PGP user ID in me request, krd invoking git")
 		gitUserId, err := kr.GlobalGitUserId()
@@ -327,39 +701,85 @@ func (client *EnclaveClient) RequestMe(meSubrequest kr.MeRequest, isPairing bool
 			err = nil
 		}
 	}
-	timeout := client.Timeouts.Me.Fail
+	timeouts := client.getTimeouts()
+	timeout := timeouts.Me.Fail
 	if isPairing {
-		timeout = client.Timeouts.Pair.Fail
+		timeout = timeouts.Pair.Fail
 	}
-	callback, err := client.tryRequest(meRequest, timeout, client.Timeouts.Me.Alert, "Incoming kr me request. Open Krypton to continue.", nil)
+	callback, err := client.tryRequest(ctx, meRequest, requestOptions{
+		isPairing:    isPairing,
+		timeout:      timeout,
+		alertTimeout: timeouts.Me.Alert,
+		alertText:    "Incoming kr me request. Open Krypton to continue.",
+	})
 	if err != nil {
 		client.log.Error(err)
 		return
 	}
 	if callback != nil {
 		response := callback.response
-		meResponse = response.MeResponse
-		if meResponse != nil {
-			client.Lock()
-			client.cachedMe = &meResponse.Me
-			if persistErr := client.Persister.SaveMe(meResponse.Me); persistErr != nil {
-				client.log.Error("persist me error:", persistErr.Error())
-			}
-			client.Persister.SaveMySSHPubKey(meResponse.Me)
-			client.Unlock()
+		if response.MeResponse != nil {
+			meResponse = &kr.MeResponse{Me: client.updateCachedMe(response.MeResponse.Me)}
 		}
 	}
 	return
 }
 
+//	Merge a profile from the phone into the cached one, persist it and
+//	announce it with PairingEventMeUpdated
+func (client *EnclaveClient) updateCachedMe(me kr.Profile) (merged kr.Profile) {
+	client.Lock()
+	merged = me
+	if client.cachedMe != nil {
+		merged = client.cachedMe.Merge(merged)
+	}
+	//	the cache, the caller and the event each get their own copy, so no
+	//	caller can change the cached profile
+	cached := merged
+	client.cachedMe = &cached
+	if persistErr := client.Persister.SaveMe(merged); persistErr != nil {
+		client.log.Error("persist me error:", persistErr.Error())
+	}
+	client.Persister.SaveMySSHPubKey(merged)
+	client.Unlock()
+	published := merged
+	client.events.publish(PairingEvent{Type: PairingEventMeUpdated, Profile: &published})
+	return
+}
+
 func (client *EnclaveClient) RequestSignature(signRequest kr.SignRequest, onACK func()) (signResponse *kr.SignResponse, enclaveVersion semver.Version, err error) {
+	return client.RequestSignatureCtx(context.Background(), signRequest, onACK)
+}
+
+func (client *EnclaveClient) RequestSignatureCtx(ctx context.Context, signRequest kr.SignRequest, onACK func()) (signResponse *kr.SignResponse, enclaveVersion semver.Version, err error) {
 	request, err := kr.NewRequest()
 	if err != nil {
 		client.log.Error(err)
 		return
 	}
 	request.SignRequest = &signRequest
-	response, err := client.RequestGeneric(request, onACK)
+	timeout := request.RequestParameters(client.getTimeouts()).Timeout
+	response, err := client.requestGenericWithTimeouts(ctx, request, timeout, onACK)
+	if err != nil {
+		return
+	}
+	signResponse = response.SignResponse
+	enclaveVersion = response.Version
+	return
+}
+
+//	Like RequestSignature, but fail after timeout instead of the configured
+//	sign timeout.
+func (client *EnclaveClient) RequestSignatureWithTimeout(signRequest kr.SignRequest, timeout time.Duration, onACK func()) (signResponse *kr.SignResponse, enclaveVersion semver.Version, err error) {
+	request, err := kr.NewRequest()
+	if err != nil {
+		client.log.Error(err)
+		return
+	}
+	request.SignRequest = &signRequest
+	timeouts := request.RequestParameters(client.getTimeouts()).Timeout
+	timeouts.Fail = timeout
+	response, err := client.requestGenericWithTimeouts(context.Background(), request, timeouts, onACK)
 	if err != nil {
 		return
 	}
@@ -385,19 +805,93 @@ func (client *EnclaveClient) RequestGitSignature(signRequest kr.GitSignRequest,
 }
 
 func (client *EnclaveClient) RequestGeneric(request kr.Request, onACK func()) (response kr.Response, err error) {
-	start := time.Now()
+	timeout := request.RequestParameters(client.getTimeouts()).Timeout
+	return client.requestGenericWithTimeouts(context.Background(), request, timeout, onACK)
+}
+
+func (client *EnclaveClient) requestGenericWithTimeouts(ctx context.Context, request kr.Request, timeout kr.TimeoutPhases, onACK func()) (response kr.Response, err error) {
+	start := client.clock.Now()
+	if !client.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+	defer func(original kr.Request) {
+		client.recordOutcome(original, timeout, err)
+	}(request)
+	if requestID, ok := requestIDFromContext(ctx); ok {
+		request.RequestID = requestID
+	}
 	err = request.Prepare()
 	if err != nil {
 		return
 	}
-	alertText := request.RequestParameters(client.Timeouts).AlertText
+	if request.SignRequest != nil {
+		if err = client.selectSigningKey(request.SignRequest); err == nil {
+			err = client.checkHostPolicy(*request.SignRequest)
+		}
+		if err != nil {
+			client.recordSignature(newSignatureAuditEntry(request, response, err))
+			return
+		}
+		request.SignRequest.RememberHost = client.rememberHostPolicy(*request.SignRequest)
+		response, err = client.dedupSignRequest(ctx, *request.SignRequest, onACK, func(onACK func()) (kr.Response, error) {
+			return client.sendRequestGeneric(ctx, start, request, timeout, onACK)
+		})
+		if err == nil {
+			err = client.checkSignResponse(*request.SignRequest, response.SignResponse, response.Version)
+		}
+		auditEntry := newSignatureAuditEntry(request, response, err)
+		client.recordSignature(auditEntry)
+		client.metrics.observeSignature(client.clock.Now().Sub(start), auditEntry.Succeeded)
+		return
+	}
+	if request.SignBatchRequest != nil {
+		if err = client.prepareSignBatch(&request); err != nil {
+			return
+		}
+		response, err = client.sendRequestGeneric(ctx, start, request, timeout, onACK)
+		if err == nil {
+			err = client.checkSignBatchResponse(start, request, response)
+		}
+		return
+	}
+	return client.sendRequestGeneric(ctx, start, request, timeout, onACK)
+}
+
+//	Check a sign response as returned to the caller: map the phone not having
+//	the key to ErrKeyNotFound, verify the signature and remember the approved
+//	host
+func (client *EnclaveClient) checkSignResponse(signRequest kr.SignRequest, signResponse *kr.SignResponse, enclaveVersion semver.Version) (err error) {
+	if isKeyNotFound(signResponse) {
+		err = ErrKeyNotFound
+		return
+	}
+	if err = checkSignatureAlgorithm(signRequest, signResponse); err != nil {
+		return
+	}
+	if err = client.VerifySignResponse(signRequest, signResponse, enclaveVersion); err != nil {
+		return
+	}
+	client.recordHostApproval(signRequest, signResponse)
+	return
+}
+
+func (client *EnclaveClient) sendRequestGeneric(ctx context.Context, start time.Time, request kr.Request, timeout kr.TimeoutPhases, onACK func()) (response kr.Response, err error) {
+	if err = client.waitForRateLimit(ctx, request); err != nil {
+		return
+	}
+	alertText := request.RequestParameters(client.getTimeouts()).AlertText
 	ps := client.getPairingSecret()
 	if ps != nil {
 		alertText = "Request from " + ps.DisplayName()
 	}
-	timeout := request.RequestParameters(client.Timeouts).Timeout
 
-	callback, err := client.tryRequest(request, timeout.Fail, timeout.Alert, alertText, onACK)
+	callback, err := client.tryRequest(ctx, request, requestOptions{
+		timeout:      timeout.Fail,
+		alertTimeout: timeout.Alert,
+		alertText:    alertText,
+		onACK:        onACK,
+	})
 	if err != nil {
 		if request.AnalyticsTag() != nil {
 			if err == ErrTimeout {
@@ -412,8 +906,8 @@ func (client *EnclaveClient) RequestGeneric(request kr.Request, onACK func()) (r
 	}
 	if callback != nil {
 		response = callback.response
-		millis := uint64(time.Since(start) / time.Millisecond)
-		client.log.Notice("response took", millis, "ms")
+		millis := uint64(client.clock.Now().Sub(start) / time.Millisecond)
+		client.log.Notice("response took", millis, "ms via", response.Transport)
 		if request.AnalyticsTag() != nil {
 			client.postEvent(*request.AnalyticsTag(), "success", &callback.medium, &millis)
 		}
@@ -424,6 +918,26 @@ func (client *EnclaveClient) RequestGeneric(request kr.Request, onACK func()) (r
 	return
 }
 
+func (client *EnclaveClient) RequestList() (listResponse *kr.ListResponse, err error) {
+	return client.RequestListCtx(context.Background())
+}
+
+func (client *EnclaveClient) RequestListCtx(ctx context.Context) (listResponse *kr.ListResponse, err error) {
+	request, err := kr.NewRequest()
+	if err != nil {
+		client.log.Error(err)
+		return
+	}
+	request.ListRequest = &kr.ListRequest{}
+	timeout := request.RequestParameters(client.getTimeouts()).Timeout
+	response, err := client.requestGenericWithTimeouts(ctx, request, timeout, nil)
+	if err != nil {
+		return
+	}
+	listResponse = response.ListResponse
+	return
+}
+
 func (client *EnclaveClient) RequestNoOp() (err error) {
 	request, err := kr.NewRequest()
 	if err != nil {
@@ -435,9 +949,15 @@ func (client *EnclaveClient) RequestNoOp() (err error) {
 		client.log.Error(err)
 		return
 	}
-	ps := client.getPairingSecret()
-	if ps != nil {
-		client.sendMessage(ps, requestJson, false, false, client.shouldSendAlertFirst())
+	if !client.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+	for _, ps := range client.getRequestPairingSecrets(false) {
+		client.sendMessage(ps, requestJson, sendOptions{
+			alertFirst: client.shouldSendAlertFirst(),
+			requestID:  request.RequestID,
+		})
 	}
 	return
 }
@@ -445,189 +965,437 @@ func (client *EnclaveClient) RequestNoOp() (err error) {
 type callbackT struct {
 	response kr.Response
 	medium   string
+	//	set when the request was abandoned rather than answered
+	err error
+}
+
+type requestOptions struct {
+	//	send to pairings that have not completed yet
+	isPairing bool
+	//	send only to these pairings instead
+	pairingSecrets []*kr.PairingSecret
+	timeout        time.Duration
+	alertTimeout   time.Duration
+	alertText      string
+	onACK          func()
+	//	never push an alert to the phone
+	silent bool
+	//	send only over transports already connected to the phone
+	connectedOnly bool
+}
+
+//	Try a request, retrying transient failures according to the retry policy
+func (client *EnclaveClient) tryRequest(ctx context.Context, request kr.Request, options requestOptions) (callback *callbackT, err error) {
+	if client.isClosed() {
+		err = ErrClosed
+		return
+	}
+	policy := client.getRetryPolicy()
+	if options.onACK != nil {
+		var ackOnce sync.Once
+		ackFunc := options.onACK
+		options.onACK = func() {
+			ackOnce.Do(ackFunc)
+		}
+	}
+	if silentFromContext(ctx) || client.localOnly {
+		options.silent = true
+		options.connectedOnly = true
+	}
+	client.metrics.countRequest(request)
+	if request.IdempotencyKey == "" {
+		request.IdempotencyKey = idempotencyKey(request)
+	}
+	for attempt := 1; ; attempt++ {
+		attemptOptions := options
+		attemptOptions.timeout = policy.stretch(options.timeout)
+		callback, err = client.tryRequestOnce(ctx, request, attemptOptions)
+		if err == nil && callback != nil {
+			client.metrics.countResponse(callback.medium)
+		}
+		if !isRetryable(err) || attempt >= policy.attempts() {
+			return
+		}
+		client.requestLog(request.RequestID).Notice("retrying request after error:", err)
+		delay := policy.delay(attempt)
+		if errors.Is(err, ErrThrottled) && delay < MIN_THROTTLED_BACKOFF {
+			delay = MIN_THROTTLED_BACKOFF
+		}
+		select {
+		case <-client.clock.After(delay):
+		case <-ctx.Done():
+			err = &CanceledError{ctx.Err()}
+			return
+		}
+	}
 }
 
-func (client *EnclaveClient) tryRequest(request kr.Request, timeout time.Duration, alertTimeout time.Duration, alertText string, onACK func()) (callback *callbackT, err error) {
-	if timeout == alertTimeout {
-		client.log.Warning("timeout == alertTimeout, alert may not fire")
+func (client *EnclaveClient) tryRequestOnce(ctx context.Context, request kr.Request, options requestOptions) (callback *callbackT, err error) {
+	log := client.requestLog(request.RequestID)
+	timeout, onACK := options.timeout, options.onACK
+	onListPage := listPageHandlerFromContext(ctx)
+	if timeout == options.alertTimeout && !options.silent {
+		log.Warning("timeout == alertTimeout, alert may not fire")
 	}
 	cb := make(chan *callbackT, 5)
-	pairingSecret := client.getPairingSecret()
-	if pairingSecret == nil {
+	pairingSecrets := options.pairingSecrets
+	if pairingSecrets == nil {
+		pairingSecrets = client.getRequestPairingSecrets(options.isPairing)
+	}
+	pairingSecrets, err = withoutReadOnlyPairings(request, pairingSecrets)
+	if err != nil {
+		return
+	}
+	if len(pairingSecrets) == 0 {
 		err = ErrNotPaired
 		return
 	}
-	alertImmediate := client.shouldSendAlertFirst()
-	go kr.RecoverToLog(func() {
-		err := client.sendRequestAndReceiveResponses(pairingSecret, request, cb, timeout, alertImmediate)
-		if err != nil {
-			client.log.Error("error sendRequestAndReceiveResponses: ", err.Error())
-		}
-	}, client.log)
-	timeoutChan := time.After(timeout)
-	sendAlertChan := time.After(alertTimeout)
-	if alertImmediate {
+	alertImmediate := !options.silent && client.shouldSendAlertFirst()
+	//	stops the receive loops as soon as this attempt returns, e.g. on
+	//	timeout, rather than when they next notice the request is gone
+	receiveCtx, stopReceiving := context.WithCancel(ctx)
+	defer stopReceiving()
+	errChan := make(chan error, len(pairingSecrets))
+	for _, pairingSecret := range pairingSecrets {
+		pairingSecret := pairingSecret
+		go kr.RecoverToLog(func() {
+			err := client.sendRequestAndReceiveResponses(receiveCtx, pairingSecret, request, cb, timeout, alertImmediate, options.connectedOnly)
+			if err != nil {
+				log.Error("error sendRequestAndReceiveResponses: ", err.Error())
+			}
+			errChan <- err
+		}, client.log)
+	}
+	pendingSends := len(pairingSecrets)
+	offline := 0
+	timeoutChan := client.clock.After(timeout)
+	sendAlertChan := client.clock.After(options.alertTimeout)
+	if alertImmediate || options.silent {
 		sendAlertChan = nil
 	}
 	func() {
-		var ack bool
+		var ack, approvalPending bool
 		for {
 			select {
 			case callback = <-cb:
+				if callback != nil && callback.err != nil {
+					err = callback.err
+					callback = nil
+					return
+				}
 				if callback != nil && callback.response.AckResponse != nil {
 					if onACK != nil {
 						onACK()
 					}
 					onACK = nil
 					ack = true
-					client.log.Notice("request", callback.response.RequestID, "ACKed")
-					timeoutChan = time.After(client.Timeouts.ACKDelay)
+					log.Notice("request ACKed")
+					timeoutChan = client.clock.After(client.getTimeouts().ACKDelay)
+					break
+				}
+				if callback != nil && callback.response.ApprovalPendingResponse != nil {
+					//	the phone has the request, waiting on the user rather
+					//	than the network
+					ack = true
+					if !approvalPending {
+						approvalPending = true
+						log.Notice("request awaiting approval")
+						client.events.publish(PairingEvent{Type: PairingEventApprovalPending, RequestID: request.RequestID})
+					}
+					break
+				}
+				if callback != nil && callback.response.IsPartial() {
+					//	the phone is still sending the rest of a paged list
+					ack = true
+					if onListPage != nil {
+						onListPage(*callback.response.ListResponse)
+					}
+					timeoutChan = client.clock.After(timeout)
 					break
 				}
 				return
+			case err = <-errChan:
+				if err == ErrOffline {
+					//	another phone may still be reachable
+					offline++
+					if offline < len(pairingSecrets) {
+						err = nil
+					}
+				}
+				if err != nil {
+					return
+				}
+				pendingSends--
+				if pendingSends == 0 {
+					errChan = nil
+				}
 			case <-timeoutChan:
 				err = ErrTimeout
 				return
+			case <-ctx.Done():
+				err = &CanceledError{ctx.Err()}
+				client.Lock()
+				if pendingCb, ok := client.requestCallbacksByRequestID.Get(request.RequestID); ok && pendingCb == cb {
+					client.requestCallbacksByRequestID.Remove(request.RequestID)
+				}
+				client.Unlock()
+				return
 			case <-sendAlertChan:
 				if ack {
 					break
 				}
-				client.Lock()
-				ps := client.pairingSecret
-				client.Unlock()
 				requestJson, err := json.Marshal(request)
 				if err != nil {
 					err = &ProtoError{err}
 					continue
 				}
-				if ps != nil {
-					client.log.Notice("pushing alert for request " + request.RequestID)
+				for _, ps := range pairingSecrets {
+					log.Notice("pushing alert for request")
 					client.Transport.PushAlert(ps, "Krypton Request", requestJson)
 				}
 			}
 		}
 	}()
-	if callback == nil && !client.IsPaired() {
+	switch {
+	case err == ErrQueueFull, err == ErrRepaired:
+		//	the request was never accepted or its pairing was replaced,
+		//	whatever the pairing state
+	case callback == nil && !client.IsPaired():
 		err = ErrNotPaired
+	case callback == nil && err == nil:
+		//	evicted by sendRequestAndReceiveResponses
+		err = ErrTimeout
+	}
+	if err == ErrTimeout {
+		client.metrics.countEvicted(EVICTED_TIMEOUT)
+	} else if _, canceled := err.(*CanceledError); canceled {
+		client.metrics.countEvicted(EVICTED_CANCELED)
 	}
 	return
 }
 
-//	Send one request and receive pending responses, not necessarily associated
-//	with this request
-func (client *EnclaveClient) sendRequestAndReceiveResponses(pairingSecret *kr.PairingSecret, request kr.Request, cb chan *callbackT, timeout time.Duration, alertFirst bool) (err error) {
+//	Send one request and wait for its response from the pairing's shared
+//	receive loop
+func (client *EnclaveClient) sendRequestAndReceiveResponses(ctx context.Context, pairingSecret *kr.PairingSecret, request kr.Request, cb chan *callbackT, timeout time.Duration, alertFirst bool, connectedOnly bool) (err error) {
+	if version, ok := client.getEnclaveVersion(pairingSecret); ok {
+		request, err = request.ForEnclaveVersion(version)
+		if err != nil {
+			return
+		}
+	}
 	requestJson, err := json.Marshal(request)
 	if err != nil {
 		err = &ProtoError{err}
 		return
 	}
 
-	timeoutAt := time.Now().Add(timeout)
+	log := client.requestLog(request.RequestID)
+	timeoutAt := client.clock.Now().Add(timeout)
+
+	connected := len(client.connectedTransports()) > 0
 
 	client.Lock()
-	client.requestCallbacksByRequestID.Add(request.RequestID, cb)
+	client.requestCallbacksByRequestID.Add(request.RequestID, request.IdempotencyKey, cb, timeoutAt.Add(client.Timeouts.ACKDelay+CALLBACK_EXPIRY_MARGIN))
 	client.Unlock()
 
-	err = client.sendMessage(pairingSecret, requestJson, true, true, alertFirst)
+	err = client.sendMessage(pairingSecret, requestJson, sendOptions{
+		queue:         !connectedOnly,
+		alertAllowed:  !connectedOnly,
+		alertFirst:    alertFirst,
+		connectedOnly: connectedOnly,
+		requestID:     request.RequestID,
+	})
+	if isOffline(connected, err) {
+		log.Error("phone unreachable:", err)
+		err = ErrOffline
+	}
 
 	if err != nil {
 		switch err.(type) {
-		case *SendQueued, *SendError:
-			client.log.Notice(err)
+		case *SendQueued, *SendError, *ThrottledError:
+			log.Notice(err)
 			err = nil
 		default:
+			client.Lock()
+			if pendingCb, ok := client.requestCallbacksByRequestID.Get(request.RequestID); ok && pendingCb == cb {
+				client.requestCallbacksByRequestID.Remove(request.RequestID)
+			}
+			client.Unlock()
 			return
 		}
 	}
 
-	receive := func() (numReceived int, err error) {
-		ciphertexts, err := client.Transport.Read(client.notifier, pairingSecret)
-		if err != nil {
-			err = &RecvError{err}
-			return
-		}
-
-		for _, ctxt := range ciphertexts {
-			ctxtErr := client.handleCiphertext(ctxt, SQS)
-			switch ctxtErr {
-			case kr.ErrWaitingForKey:
-			default:
-				err = ctxtErr
-			}
+	//	a timer rather than comparing against timeoutAt, so that the wall
+	//	clock jumping, e.g. on wake from sleep, cannot stretch or cut short
+	//	the wait
+	deadline := client.clock.After(timeout)
+	if connectedOnly && !client.localOnly && timeout > LOCAL_RESPONSE_GRACE {
+		//	the phone answers over the link it got the request on, so a
+		//	prompt answer needs no queue poll at all
+		select {
+		case <-client.clock.After(LOCAL_RESPONSE_GRACE):
+		case <-ctx.Done():
 		}
-		return
 	}
-
-	for {
-		n, err := receive()
+	//	without SNS/SQS, responses arrive over USB or bluetooth only
+	receiver := &queueReceiver{}
+	if !client.localOnly && ctx.Err() == nil {
+		var release func()
+		receiver, release = client.waitOnQueue(pairingSecret)
+		defer release()
+	}
+	ackExtended, timedOut := false, false
+	for !timedOut {
+		received := client.nextReceive(receiver)
 		client.Lock()
 		_, requestPending := client.requestCallbacksByRequestID.Get(request.RequestID)
 		_, requestAcked := client.ackedRequestIDs.Get(request.RequestID)
+		receiveErr := receiver.err
 		client.Unlock()
-		timeout := timeoutAt
-		if requestAcked {
-			timeout = timeout.Add(client.Timeouts.ACKDelay)
+		if receiveErr != nil {
+			err = receiveErr
+			break
 		}
-		if (n == 0 && time.Now().After(timeout)) || !requestPending {
+		if !requestPending || ctx.Err() != nil {
 			break
 		}
-		if err != nil {
-			client.log.Error("queue err:", err)
-			<-time.After(time.Second)
+		select {
+		case <-received:
+		case <-ctx.Done():
+			//	e.g. the response arrived over bluetooth or USB, stop
+			//	polling rather than waiting out the receive in progress
+			continue
+		case <-deadline:
+			//	pages of a list keep coming, wait a full timeout past the
+			//	latest
+			client.Lock()
+			progressAt := client.requestCallbacksByRequestID.ProgressAt(request.RequestID)
+			client.Unlock()
+			if !progressAt.IsZero() {
+				if sinceProgress := client.clock.Now().Sub(progressAt); sinceProgress < timeout {
+					deadline = client.clock.After(timeout - sinceProgress)
+					continue
+				}
+			}
+			if requestAcked && !ackExtended {
+				ackExtended = true
+				deadline = client.clock.After(client.getTimeouts().ACKDelay)
+				continue
+			}
+			//	a response may have arrived just before the deadline
+			timedOut = true
+			if client.localOnly {
+				break
+			}
+			received = client.nextReceive(receiver)
+			receiver.poke()
+			select {
+			case <-received:
+			case <-ctx.Done():
+			}
+		case <-ctx.Done():
 		}
 	}
 	client.Lock()
-	if cb, ok := client.requestCallbacksByRequestID.Get(request.RequestID); ok {
+	if pendingCb, ok := client.requestCallbacksByRequestID.Get(request.RequestID); ok && pendingCb == cb {
 		//	request still not processed, give up on it
-		cb.(chan *callbackT) <- nil
+		var callback *callbackT
+		if err != nil {
+			callback = &callbackT{err: err}
+		}
+		cb <- callback
 		client.requestCallbacksByRequestID.Remove(request.RequestID)
-		client.log.Error("evicting request", request.RequestID)
+		log.Error("evicting request")
 	}
 	client.Unlock()
 
 	return
 }
 
+//	Match a ciphertext to whichever pairing can unwrap or decrypt it. Returns
+//	ErrForeignCiphertext if none can decrypt it.
 func (client *EnclaveClient) handleCiphertext(ciphertext []byte, medium string) (err error) {
-	pairingSecret := client.getPairingSecret()
-	if pairingSecret == nil {
-		err = errors.New("EnclaveClient pairing never initiated")
+	if err = client.checkCiphertextSize(ciphertext, medium); err != nil {
 		return
 	}
+	pairingSecrets := client.getPairingSecrets()
+	if len(pairingSecrets) == 0 {
+		err = ErrNotPaired
+		return
+	}
+	foreign := false
+	for _, pairingSecret := range pairingSecrets {
+		if len(ciphertext) > 0 && ciphertext[0] == kr.HEADER_WRAPPED_PUBLIC_KEY && pairingSecret.IsPaired() {
+			continue
+		}
+		handled, pairingErr := client.handlePairingCiphertext(pairingSecret, ciphertext, medium)
+		if handled {
+			err = pairingErr
+			return
+		}
+		if pairingErr == ErrForeignCiphertext {
+			foreign = true
+		} else {
+			err = pairingErr
+		}
+	}
+	//	any other failure, e.g. a pairing still waiting for its key, is
+	//	reported instead
+	if err == nil && foreign {
+		client.metrics.countForeignCiphertext()
+		err = ErrForeignCiphertext
+	}
+	return
+}
+
+func (client *EnclaveClient) handlePairingCiphertext(pairingSecret *kr.PairingSecret, ciphertext []byte, medium string) (handled bool, err error) {
 	unwrappedCiphertext, didUnwrapKey, err := pairingSecret.UnwrapKeyIfPresent(ciphertext)
 	if err != nil {
-		if err == kr.ErrWrappedKeyUnsupported && client.notifier != nil {
-			client.notifier.Notify(append([]byte(kr.Red("You are running an old version of the Krypton app. Please upgrade Krypton on your mobile phone before pairing by visiting get.krypt.co.")), '\r', '\n'))
+		if err == kr.ErrWrappedKeyUnsupported {
+			handled = true
+			if client.notifier != nil {
+				client.notifier.Notify(append([]byte(kr.Red("You are running an old version of the Krypton app. Please upgrade Krypton on your mobile phone before pairing by visiting get.krypt.co.")), '\r', '\n'))
+			}
 		}
 		err = &ProtoError{err}
 		return
 	}
 	if didUnwrapKey {
+		handled = true
 		client.Lock()
-		queue := client.outgoingQueue
-		client.outgoingQueue = [][]byte{}
+		queue := client.outgoingQueue.drain(client.clock.Now())
+		client.saveOutgoingQueue()
+		client.savePairings()
+		client.completeRotation(pairingSecret)
+		client.metrics.observeKeyUnwrap(client.clock.Now())
 		client.Unlock()
-
-		savePairingErr := client.Persister.SavePairing(pairingSecret)
-		if savePairingErr != nil {
-			client.log.Error("error saving pairing:", savePairingErr.Error())
-		}
+		client.events.publish(PairingEvent{Type: PairingEventKeyUnwrapped})
 
 		for _, queuedMessage := range queue {
-			err = client.sendMessage(pairingSecret, queuedMessage, true, true, client.shouldSendAlertFirst())
+			err = client.sendMessage(pairingSecret, queuedMessage.Message, sendOptions{
+				queue:        true,
+				alertAllowed: true,
+				alertFirst:   client.shouldSendAlertFirst(),
+				requestID:    queuedMessage.RequestID,
+			})
 			if err != nil {
-				client.log.Error("error sending queued message:", err.Error())
+				client.requestLog(queuedMessage.RequestID).Error("error sending queued message:", err.Error())
 			}
 		}
 	}
 	if unwrappedCiphertext == nil {
+		handled = true
 		return
 	}
 	message, err := pairingSecret.DecryptMessage(*unwrappedCiphertext)
 	if err != nil {
-		client.log.Error("decrypt error:", err)
+		if err != kr.ErrWaitingForKey {
+			//	encrypted with another pairing's keys
+			err = ErrForeignCiphertext
+		}
 		return
 	}
+	handled = true
 	if message == nil {
 		return
 	}
@@ -659,13 +1427,63 @@ func (client *EnclaveClient) shouldSendAlertFirst() bool {
 	return false
 }
 
-func (client *EnclaveClient) sendMessage(pairingSecret *kr.PairingSecret, message []byte, queue bool, alertAllowed bool, alertFirst bool) (err error) {
+type sendOptions struct {
+	//	queue the message if the pairing is still waiting for the phone's key
+	queue        bool
+	alertAllowed bool
+	alertFirst   bool
+	//	send only over transports already connected to the phone
+	connectedOnly bool
+	//	the request being sent, for logging
+	requestID string
+}
+
+func (client *EnclaveClient) sendMessage(pairingSecret *kr.PairingSecret, message []byte, options sendOptions) (err error) {
+	if pairingSecret == nil {
+		err = ErrNotPaired
+		return
+	}
+	if client.isClosed() {
+		err = ErrClosed
+		return
+	}
+	log := client.requestLog(options.requestID)
+	transports := client.transports
+	if options.connectedOnly || client.localOnly {
+		transports = client.connectedTransports()
+		if len(transports) == 0 && client.localOnly {
+			err = ErrNoLocalTransport
+			return
+		}
+		if len(transports) == 0 {
+			err = ErrNoConnectedTransport
+			return
+		}
+	}
 	ciphertext, err := pairingSecret.EncryptMessage(message)
 	if err != nil {
 		if err == kr.ErrWaitingForKey {
 			client.Lock()
-			if len(client.outgoingQueue) < 128 && queue {
-				client.outgoingQueue = append(client.outgoingQueue, message)
+			if options.queue {
+				droppedBefore := client.outgoingQueue.dropped
+				queued := client.outgoingQueue.push(kr.QueuedMessage{
+					Message:   message,
+					QueuedAt:  client.clock.Now(),
+					Priority:  kr.MessagePriority(message),
+					RequestID: options.requestID,
+				})
+				for i := droppedBefore; i < client.outgoingQueue.dropped; i++ {
+					client.metrics.countEvicted(EVICTED_CAPACITY)
+				}
+				if queued {
+					client.saveOutgoingQueue()
+				} else if client.outgoingQueue.overflow == RejectWithError {
+					client.Unlock()
+					err = ErrQueueFull
+					return
+				} else {
+					log.Warning("outgoing queue full, dropping message")
+				}
 			}
 			client.Unlock()
 			err = &SendQueued{err}
@@ -675,32 +1493,15 @@ func (client *EnclaveClient) sendMessage(pairingSecret *kr.PairingSecret, messag
 		return
 	}
 
-	go func() {
-		if client.bt == nil {
-			return
-		}
-		uuid, err := pairingSecret.DeriveUUID()
-		if err != nil {
-			client.log.Error("error deriving UUID", err)
-			return
-		}
-		err = client.bt.Write(uuid, ciphertext)
-		if err != nil {
-			client.log.Error("error writing to Bluetooth", err)
-		}
-	}()
-
-	if alertFirst && alertAllowed {
-		err = client.Transport.PushAlert(pairingSecret, "Krypton Request", message)
-		if err != nil {
-			err = &SendError{err}
+	//	every transport is tried even if an earlier one fails
+	for _, transport := range transports {
+		delivered, sendErr := transport.Send(pairingSecret, message, ciphertext, options.alertFirst && options.alertAllowed, log)
+		if delivered {
+			err = nil
 			return
 		}
-	} else {
-		err = client.Transport.SendMessage(pairingSecret, message)
-		if err != nil {
-			err = &SendError{err}
-			return
+		if sendErr != nil && err == nil {
+			err = sendErr
 		}
 	}
 	return
@@ -712,49 +1513,61 @@ func (client *EnclaveClient) handleMessage(fromPairing *kr.PairingSecret, messag
 	if err != nil {
 		return
 	}
+	response.Transport = medium
 	client.Lock()
 	defer client.Unlock()
-	client.lastActivityByMedium[medium] = time.Now()
+	client.lastActivityByMedium[medium] = client.clock.Now()
 
 	if response.UnpairResponse != nil {
 		client.log.Notice("Received unpair command from phone.")
 		client.unpair(fromPairing, false)
-		//	cancel all pending callbacks
-		client.requestCallbacksByRequestID.OnEvicted = func(key lru.Key, callback interface{}) {
-			callback.(chan *callbackT) <- nil
-		}
-		for client.requestCallbacksByRequestID.Len() > 0 {
-			client.requestCallbacksByRequestID.RemoveOldest()
+		if len(client.pairingSecrets) > 0 {
+			return
 		}
-		client.requestCallbacksByRequestID.OnEvicted = nil
+		client.cancelPendingRequests(nil)
 		return
 	}
 
-	if client.pairingSecret != nil && client.pairingSecret.Equals(fromPairing) {
-		if response.SNSEndpointARN != nil {
-			client.pairingSecret.SetSNSEndpointARN(response.SNSEndpointARN)
-			client.Persister.SavePairing(client.pairingSecret)
+	if index := client.findPairing(fromPairing); index >= 0 {
+		pairingSecret := client.pairingSecrets[index]
+		client.setEnclaveVersion(pairingSecret, response.Version)
+		if pairingSecret.UpdateSNSEndpointARN(response.SNSEndpointARN) {
+			client.savePairings()
+			client.events.publish(PairingEvent{Type: PairingEventPushEndpointUpdated, SNSEndpointARN: response.SNSEndpointARN})
 		}
 
-		oldTID := client.pairingSecret.GetTrackingID()
+		oldTID := pairingSecret.GetTrackingID()
 		if response.TrackingID != nil && (oldTID == nil || *response.TrackingID != *oldTID) {
-			client.pairingSecret.SetTrackingID(response.TrackingID)
-			client.Persister.SavePairing(client.pairingSecret)
+			pairingSecret.SetTrackingID(response.TrackingID)
+			client.savePairings()
 		}
 	}
 
-	if requestCb, ok := client.requestCallbacksByRequestID.Get(response.RequestID); ok {
-		client.log.Info("found callback for request", response.RequestID)
-		requestCb.(chan *callbackT) <- &callbackT{
+	log := client.requestLog(response.RequestID)
+	requestCb, ok := client.requestCallbacksByRequestID.Get(response.RequestID)
+	if !ok && response.IdempotencyKey != "" {
+		//	answer to an earlier send of a request since resent under a new ID
+		var requestID string
+		if requestID, requestCb, ok = client.requestCallbacksByRequestID.GetByIdempotencyKey(response.IdempotencyKey); ok {
+			log.Info("matched response to request", requestID, "by idempotency key")
+			log = client.requestLog(requestID)
+			response.RequestID = requestID
+		}
+	}
+	if ok {
+		log.Info("found callback for request")
+		requestCb <- &callbackT{
 			response: response,
 			medium:   medium,
 		}
 	} else {
-		client.log.Info("callback not found for request", response.RequestID)
+		log.Info("callback not found for request")
 	}
 	if response.AckResponse != nil {
 		client.ackedRequestIDs.Add(response.RequestID, nil)
-	} else {
+	} else if response.IsPartial() {
+		client.requestCallbacksByRequestID.Extend(response.RequestID)
+	} else if response.ApprovalPendingResponse == nil {
 		client.requestCallbacksByRequestID.Remove(response.RequestID)
 	}
 	return
@@ -2,14 +2,32 @@ package krd
 
 import (
 	"bytes"
+	"context"
 	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/blang/semver"
 	"github.com/kryptco/kr"
+	"github.com/op/go-logging"
+	"github.com/satori/go.uuid"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestPair(t *testing.T) {
@@ -172,3 +190,3531 @@ func TestNoOp(t *testing.T) {
 		return transport.GetSentNoOps() > 0
 	}, time.Now().Add(time.Second))
 }
+
+func TestSignatureWithTimeout(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClientShortTimeouts(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	start := time.Now()
+	_, _, err := ec.RequestSignatureWithTimeout(kr.SignRequest{
+		PublicKeyFingerprint: fp[:],
+		Data:                 []byte("data"),
+	}, 200*time.Millisecond, nil)
+	if err != ErrTimeout {
+		t.Fatal("expected timeout, got", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("timeout override not honored")
+	}
+}
+
+func TestSetRequestTimeouts(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClientShortTimeouts(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	ec.SetRequestTimeouts(0, 200*time.Millisecond, 0)
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	start := time.Now()
+	_, _, err := ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: fp[:],
+		Data:                 []byte("data"),
+	}, nil)
+	if err != ErrTimeout {
+		t.Fatal("expected timeout, got", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("sign timeout not honored")
+	}
+}
+
+func TestSignatureRetry(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClientShortTimeouts(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	transport.Lock()
+	transport.DropRequests = 1
+	transport.Unlock()
+	ec.SetRetryPolicy(RetryPolicy{
+		MaxAttempts: 2,
+		BaseDelay:   10 * time.Millisecond,
+		Multiplier:  2,
+	})
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	signResponse, _, err := ec.RequestSignatureWithTimeout(kr.SignRequest{
+		PublicKeyFingerprint: fp[:],
+		Data:                 make([]byte, 32),
+	}, 400*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signResponse == nil || signResponse.Signature == nil {
+		t.Fatal("expected signature after retry")
+	}
+}
+
+func TestPairMultiplePhones(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	persister := &kr.MemoryPersister{}
+	ec := UnpairedEnclaveClient(transport, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil)
+	firstPairing := PairClient(t, ec)
+	defer ec.Stop()
+	//	the mock transport shares responses between pairings, so let the
+	//	first pairing's me response be read before pairing again
+	kr.TrueBefore(t, func() bool { return ec.GetCachedMe() != nil }, time.Now().Add(time.Second))
+
+	secondPairing, err := ec.Pair(kr.PairingOptions{Add: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ec.RequestMe(kr.MeRequest{}, true)
+	kr.TrueBefore(t, secondPairing.IsPaired, time.Now().Add(time.Second))
+
+	if !firstPairing.IsPaired() {
+		t.Fatal("first pairing lost")
+	}
+	pairings, err := persister.LoadPairings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairings) != 2 {
+		t.Fatal("expected two persisted pairings, got", len(pairings))
+	}
+
+	testSignatureSuccess(t, ec)
+}
+
+func TestPairReplacesExistingPairing(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	persister := &kr.MemoryPersister{}
+	ec := UnpairedEnclaveClient(transport, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil)
+	firstPairing := PairClient(t, ec)
+	defer ec.Stop()
+
+	secondPairing, err := ec.Pair(kr.PairingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairingSecrets := ec.(*EnclaveClient).getPairingSecrets()
+	if len(pairingSecrets) != 1 || !pairingSecrets[0].Equals(secondPairing) {
+		t.Fatal("expected the new pairing to replace", firstPairing.WorkstationPublicKey)
+	}
+	pairings, err := persister.LoadPairings()
+	if err != nil || len(pairings) != 1 || !pairings[0].Equals(secondPairing) {
+		t.Fatal("expected only the new pairing persisted", err)
+	}
+}
+
+func TestSignatureCtxCanceled(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	_, _, err := ec.RequestSignatureCtx(ctx, kr.SignRequest{
+		PublicKeyFingerprint: fp[:],
+		Data:                 []byte("data"),
+	}, nil)
+	if _, ok := err.(*CanceledError); !ok || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected canceled error, got", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	listResponse, err := ec.RequestList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMe, _, _ := kr.TestMe(t)
+	if listResponse == nil || len(listResponse.Keys) != 1 || !listResponse.Keys[0].Equal(testMe) {
+		t.Fatal("unexpected key list")
+	}
+}
+
+func TestPairingEvents(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	events := ec.Subscribe()
+	defer ec.Unsubscribe(events)
+
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+	ec.Unpair()
+
+	expected := []PairingEventType{PairingEventPaired, PairingEventKeyUnwrapped, PairingEventMeUpdated, PairingEventUnpaired}
+	for _, eventType := range expected {
+		select {
+		case event := <-events:
+			if event.Type != eventType {
+				t.Fatal("expected", eventType, "got", event.Type)
+			}
+			if event.Type == PairingEventMeUpdated && event.Profile == nil {
+				t.Fatal("missing profile")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("missing event", eventType)
+		}
+	}
+}
+
+func TestPushEndpointEvents(t *testing.T) {
+	arn := "arn:aws:sns:us-east-1:0:endpoint/APNS/kr/0"
+	transport := &kr.ResponseTransport{T: t, SNSEndpointARN: &arn}
+	ec := NewTestEnclaveClient(transport)
+	events := ec.Subscribe()
+	defer ec.Unsubscribe(events)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	nextPushEndpoint := func() (endpoint string) {
+		for {
+			select {
+			case event := <-events:
+				if event.Type == PairingEventPushEndpointUpdated {
+					return *event.SNSEndpointARN
+				}
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}
+	if endpoint := nextPushEndpoint(); endpoint != arn {
+		t.Fatal("expected push endpoint event, got", endpoint)
+	}
+
+	if _, err := ec.RequestList(); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint := nextPushEndpoint(); endpoint != "" {
+		t.Fatal("expected no event for an unchanged endpoint, got", endpoint)
+	}
+
+	rotated := "arn:aws:sns:us-east-1:0:endpoint/APNS/kr/1"
+	transport.Lock()
+	transport.SNSEndpointARN = &rotated
+	transport.Unlock()
+	if _, err := ec.RequestList(); err != nil {
+		t.Fatal(err)
+	}
+	if endpoint := nextPushEndpoint(); endpoint != rotated {
+		t.Fatal("expected event for the rotated endpoint, got", endpoint)
+	}
+}
+
+func TestMeResponseMergedIntoCachedMe(t *testing.T) {
+	ec := NewTestEnclaveClient(&kr.ResponseTransport{T: t}).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+
+	pgp := []byte("pgp")
+	ec.Lock()
+	withPGP := *ec.cachedMe
+	withPGP.PGPPublicKey = &pgp
+	ec.cachedMe = &withPGP
+	ec.Unlock()
+
+	//	the test phone never sends a PGP key
+	meResponse, err := ec.RequestMe(kr.MeRequest{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meResponse.Me.PGPPublicKey == nil || ec.GetCachedMe().PGPPublicKey == nil {
+		t.Fatal("expected PGP key to survive a response without one")
+	}
+
+	meResponse.Me.Email = "changed@example.com"
+	if ec.GetCachedMe().Email == meResponse.Me.Email {
+		t.Fatal("expected the cached profile unaffected by changes to the response")
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+
+	ec.Lock()
+	ec.lastActivityByMedium = map[string]time.Time{}
+	ec.Unlock()
+	requests := ec.Metrics().requestsByType["me"]
+	if err := ec.Warmup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ec.Metrics().requestsByType["me"] != requests+1 {
+		t.Fatal("expected a cold warmup to reach the phone")
+	}
+	if err := ec.Warmup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ec.Metrics().requestsByType["me"] != requests+1 {
+		t.Fatal("expected warmup to be a no-op once warm")
+	}
+
+	ec.Lock()
+	ec.lastActivityByMedium = map[string]time.Time{}
+	ec.Unlock()
+	transport.Lock()
+	transport.DoNotRespond = true
+	transport.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ec.Warmup(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected warmup to respect its context, got", err)
+	}
+}
+
+func TestOutgoingQueuePersisted(t *testing.T) {
+	persister := &kr.MemoryPersister{}
+	ec := UnpairedEnclaveClient(&kr.NoopTransport{}, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil)
+	ec.Start()
+	defer ec.Stop()
+	if _, err := ec.Pair(kr.PairingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ec.RequestMeCtx(ctx, kr.MeRequest{}, true)
+	kr.TrueBefore(t, func() bool {
+		queue, _ := persister.LoadQueuedMessages()
+		return len(queue) == 1
+	}, time.Now().Add(time.Second))
+
+	queue, _ := persister.LoadQueuedMessages()
+	queue = append(queue, kr.QueuedMessage{
+		Message:  []byte("{}"),
+		QueuedAt: time.Now().Add(-2 * MAX_QUEUED_MESSAGE_AGE),
+	})
+	persister.SaveQueuedMessages(queue)
+
+	restarted := UnpairedEnclaveClient(&kr.NoopTransport{}, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil).(*EnclaveClient)
+	restarted.Start()
+	defer restarted.Stop()
+	restarted.Lock()
+	defer restarted.Unlock()
+	if restarted.outgoingQueue.len() != 1 {
+		t.Fatal("expected only the fresh queued message to be loaded, got", restarted.outgoingQueue.len())
+	}
+}
+
+func TestRefreshMePersisted(t *testing.T) {
+	persister := &kr.MemoryPersister{}
+	transport := &kr.ResponseTransport{T: t}
+	ec := UnpairedEnclaveClient(transport, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, err := ec.RefreshMe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _, _ := kr.TestMe(t)
+	if !me.Equal(expected) {
+		t.Fatal("unexpected profile", me)
+	}
+
+	restarted := UnpairedEnclaveClient(&kr.NoopTransport{}, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil)
+	restarted.Start()
+	defer restarted.Stop()
+	if cached := restarted.GetCachedMe(); cached == nil || !cached.Equal(expected) {
+		t.Fatal("expected the refreshed profile to be loaded on start")
+	}
+}
+
+func TestRequestsNotPaired(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	ec.Start()
+	defer ec.Stop()
+
+	checkNotPaired := func() {
+		if _, _, err := ec.RequestSignature(kr.SignRequest{}, nil); !errors.Is(err, ErrNotPaired) {
+			t.Fatal("expected ErrNotPaired from RequestSignature, got", err)
+		}
+		if _, err := ec.RequestList(); !errors.Is(err, ErrNotPaired) {
+			t.Fatal("expected ErrNotPaired from RequestList, got", err)
+		}
+		if _, err := ec.RequestMe(kr.MeRequest{}, false); !errors.Is(err, ErrNotPaired) {
+			t.Fatal("expected ErrNotPaired from RequestMe, got", err)
+		}
+		if err := ec.RequestNoOp(); !errors.Is(err, ErrNotPaired) {
+			t.Fatal("expected ErrNotPaired from RequestNoOp, got", err)
+		}
+	}
+	checkNotPaired()
+
+	//	a pairing that has not completed does not count
+	if _, err := ec.Pair(kr.PairingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkNotPaired()
+}
+
+func TestIdenticalSignaturesDeduplicated(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	signRequest := kr.SignRequest{
+		PublicKeyFingerprint: fp[:],
+		Data:                 make([]byte, 32),
+	}
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			signResponse, _, err := ec.RequestSignature(signRequest, nil)
+			if err == nil && (signResponse == nil || signResponse.Signature == nil) {
+				err = errors.New("missing signature")
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent := transport.GetSentSignRequests(); sent != 1 {
+		t.Fatal("expected one sign request sent, got", sent)
+	}
+}
+
+func TestDeduplicatedSignatureNotFailedByCanceledSender(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	//	the phone does not answer the first request
+	transport.Lock()
+	transport.DoNotRespond = true
+	transport.Unlock()
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	signRequest := kr.SignRequest{
+		PublicKeyFingerprint: fp[:],
+		Data:                 make([]byte, 32),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	senderErr := make(chan error, 1)
+	go func() {
+		_, _, err := ec.RequestSignatureCtx(ctx, signRequest, nil)
+		senderErr <- err
+	}()
+	kr.TrueBefore(t, func() bool {
+		ec.Lock()
+		defer ec.Unlock()
+		return ec.signFlights.Len() > 0
+	}, time.Now().Add(time.Second))
+	waiterErr := make(chan error, 1)
+	go func() {
+		signResponse, _, err := ec.RequestSignature(signRequest, nil)
+		if err == nil && (signResponse == nil || signResponse.Signature == nil) {
+			err = errors.New("missing signature")
+		}
+		waiterErr <- err
+	}()
+	<-time.After(100 * time.Millisecond)
+
+	//	e.g. the first ssh is killed
+	transport.Lock()
+	transport.DoNotRespond = false
+	transport.Unlock()
+	cancel()
+	if err := <-senderErr; !errors.Is(err, context.Canceled) {
+		t.Fatal("expected the sender canceled, got", err)
+	}
+	select {
+	case err := <-waiterErr:
+		if err != nil {
+			t.Fatal("expected the waiter to send in its place, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter never answered")
+	}
+}
+
+func TestDeduplicatedSignaturesShareACK(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, Ack: true, SendAfterHalfAckDelay: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	signRequest := kr.SignRequest{
+		PublicKeyFingerprint: fp[:],
+		Data:                 make([]byte, 32),
+	}
+	var acks int32
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, _, err := ec.RequestSignature(signRequest, func() {
+				atomic.AddInt32(&acks, 1)
+			})
+			errs <- err
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent := ec.(*EnclaveClient).Metrics().requestsByType["sign"]; sent != 1 {
+		t.Fatal("expected one sign request sent, got", sent)
+	}
+	if acks := atomic.LoadInt32(&acks); acks != 3 {
+		t.Fatal("expected every request told of the ACK, got", acks)
+	}
+}
+
+func TestPing(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	if _, err := ec.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPingPublishesChangedKey(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+	events := ec.Subscribe()
+	defer ec.Unsubscribe(events)
+
+	//	the phone now holds a different key
+	transport.Lock()
+	transport.Ed25519 = true
+	transport.Unlock()
+	if _, err := ec.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	me, _, _ := kr.TestMeEd25519(t)
+	if cached := ec.GetCachedMe(); cached == nil || !bytes.Equal(cached.SSHWirePublicKey, me.SSHWirePublicKey) {
+		t.Fatal("expected the changed key cached")
+	}
+	for {
+		select {
+		case event := <-events:
+			if event.Type == PairingEventMeUpdated && event.Profile != nil && bytes.Equal(event.Profile.SSHWirePublicKey, me.SSHWirePublicKey) {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the changed key published")
+		}
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, RespondToAlertOnly: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	ec.(*EnclaveClient).SetPingTimeout(200 * time.Millisecond)
+
+	//	pings never push alerts, so a phone only reachable by alert times out
+	if _, err := ec.Ping(); err != ErrTimeout {
+		t.Fatal("expected timeout, got", err)
+	}
+}
+
+func TestTimedOutRequestsDoNotLeakGoroutines(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClientShortTimeouts(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	if _, err := ec.RequestMe(kr.MeRequest{}, false); err != nil {
+		t.Fatal(err)
+	}
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	<-time.After(100 * time.Millisecond)
+	baseline := runtime.NumGoroutine()
+
+	//	ACKed requests time out on the ACK delay, well before the receive
+	//	loop's own deadline
+	transport.Lock()
+	transport.Ack = true
+	transport.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, err := ec.RequestSignature(kr.SignRequest{
+				PublicKeyFingerprint: fp[:],
+				Data:                 []byte(fmt.Sprint("data", i)),
+			}, nil)
+			if err != ErrTimeout {
+				t.Error("expected timeout, got", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	kr.TrueBefore(t, func() bool {
+		return runtime.NumGoroutine() <= baseline
+	}, time.Now().Add(200*time.Millisecond))
+}
+
+//	A clock that only moves when advanced
+type fakeClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.Lock()
+	defer clock.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) After(d time.Duration) <-chan time.Time {
+	clock.Lock()
+	defer clock.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- clock.now
+		return c
+	}
+	clock.timers = append(clock.timers, fakeTimer{at: clock.now.Add(d), c: c})
+	return c
+}
+
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.Lock()
+	defer clock.Unlock()
+	clock.now = clock.now.Add(d)
+	var pending []fakeTimer
+	for _, timer := range clock.timers {
+		if clock.now.Before(timer.at) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- clock.now
+		}
+	}
+	clock.timers = pending
+}
+
+func TestClockDrivesTimeout(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := UnpairedEnclaveClient(transport, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil, WithClock(clock))
+	PairClient(t, ec)
+	defer ec.Stop()
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, _, err := ec.RequestSignature(kr.SignRequest{
+			PublicKeyFingerprint: fp[:],
+			Data:                 []byte("data"),
+		}, nil)
+		errChan <- err
+	}()
+	//	the default sign timeout is far longer than this test may take
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case err := <-errChan:
+			if err != ErrTimeout {
+				t.Fatal("expected timeout, got", err)
+			}
+			return
+		case <-deadline:
+			t.Fatal("request did not time out on the fake clock")
+		case <-time.After(time.Millisecond):
+			clock.Advance(10 * time.Second)
+		}
+	}
+}
+
+func TestSilentRequestWithoutConnectedTransport(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	start := time.Now()
+	if _, err := ec.RequestMeCtx(WithoutWakingPhone(context.Background()), kr.MeRequest{}, false); err != ErrNoConnectedTransport {
+		t.Fatal("expected ErrNoConnectedTransport, got", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("silent request did not fail fast")
+	}
+}
+
+func TestSilentRequestOverBluetooth(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	bt := &recordingBluetoothDriver{writes: make(chan []byte, 1)}
+	ec.Lock()
+	ec.bt = bt
+	ec.setBluetoothStatus(BluetoothScanning, nil)
+	ec.lastActivityByMedium[BLUETOOTH] = time.Now()
+	ec.Unlock()
+
+	ctx, cancel := context.WithTimeout(WithoutWakingPhone(context.Background()), 200*time.Millisecond)
+	defer cancel()
+	//	the transport only answers messages sent over SQS
+	if _, err := ec.RequestMeCtx(ctx, kr.MeRequest{}, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected request to go unanswered, got", err)
+	}
+	select {
+	case <-bt.writes:
+	default:
+		t.Fatal("expected request written to bluetooth")
+	}
+}
+
+func TestBluetoothResponseSkipsQueue(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	bt := &recordingBluetoothDriver{writes: make(chan []byte, 1)}
+	ec.Lock()
+	ec.bt = bt
+	ec.setBluetoothStatus(BluetoothScanning, nil)
+	ec.lastActivityByMedium[BLUETOOTH] = time.Now()
+	ec.Unlock()
+
+	me, _, _ := kr.TestMe(t)
+	ps := ec.getPairingSecret()
+	go func() {
+		for range bt.writes {
+			response, err := json.Marshal(kr.Response{
+				RequestID:  "bluetooth-request",
+				MeResponse: &kr.MeResponse{Me: me},
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ciphertext, err := ps.EncryptMessage(response)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := ec.handleCiphertext(ciphertext, BLUETOOTH); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	//	let the receive loop left over from pairing stop
+	for idle := false; !idle; time.Sleep(10 * time.Millisecond) {
+		ec.Lock()
+		idle = len(ec.queueReceivers) == 0
+		ec.Unlock()
+	}
+	readsBefore := transport.GetReads()
+	ctx := WithRequestID(WithoutWakingPhone(context.Background()), "bluetooth-request")
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := ec.RequestMeCtx(ctx, kr.MeRequest{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= LOCAL_RESPONSE_GRACE {
+		t.Fatal("expected the bluetooth response to resolve the request promptly, took", elapsed)
+	}
+	time.Sleep(2 * MIN_RECEIVE_BACKOFF)
+	if reads := transport.GetReads(); reads != readsBefore {
+		t.Fatal("expected no queue reads, got", reads-readsBefore)
+	}
+}
+
+func TestBluetoothStatus(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+
+	//	responses arrive over the transport, not bluetooth
+	if status, _ := ec.BluetoothStatus(); status == BluetoothConnected {
+		t.Fatal("unexpected bluetooth status", status)
+	}
+	ec.Stop()
+	if status, _ := ec.BluetoothStatus(); status != BluetoothDisabled {
+		t.Fatal("expected bluetooth disabled after stop, got", status)
+	}
+}
+
+func TestReceiveBackoff(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClientShortTimeouts(transport)
+	if _, _, err := testSignature(t, ec); err != ErrTimeout {
+		t.Fatal("expected timeout, got", err)
+	}
+	//	a tight loop would read thousands of times before timing out
+	if reads := transport.GetReads(); reads > 40 {
+		t.Fatal("expected empty reads to back off, got", reads, "reads")
+	}
+}
+
+func TestConcurrentRequestsShareReceiveLoop(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClientShortTimeouts(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ec.RequestList(); err != ErrTimeout {
+				t.Error("expected timeout, got", err)
+			}
+		}()
+	}
+	wg.Wait()
+	//	separate loops would each read about as often as a single request
+	if reads := transport.GetReads(); reads > 40 {
+		t.Fatal("expected concurrent requests to share queue reads, got", reads, "reads")
+	}
+}
+
+func TestOfflineFailsFast(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	transport.Lock()
+	transport.FailSends = true
+	transport.Unlock()
+	start := time.Now()
+	if _, err := ec.RequestList(); err != ErrOffline {
+		t.Fatal("expected ErrOffline, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("expected an unreachable phone to fail fast, took", elapsed)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	ec.(*EnclaveClient).SetAuditLogSize(2)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	host := "example.com"
+	for i := 0; i < 3; i++ {
+		data, err := kr.RandNBytes(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = ec.RequestSignature(kr.SignRequest{
+			PublicKeyFingerprint: fp[:],
+			Data:                 data,
+			HostAuth:             &kr.HostAuth{HostNames: []string{host}},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := ec.AuditLog()
+	if len(entries) != 2 {
+		t.Fatal("expected audit log capped at 2, got", len(entries))
+	}
+	for _, entry := range entries {
+		if !entry.Succeeded || len(entry.HostNames) != 1 || entry.HostNames[0] != host {
+			t.Fatal("unexpected audit entry", entry)
+		}
+	}
+}
+
+func TestSignRequestMetadata(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("with metadata"))
+	metadata := map[string]string{"remote": "git@github.com:kryptco/kr.git", "pid": "4242"}
+	if _, _, err := ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+		Metadata:             metadata,
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	entries := ec.AuditLog()
+	if len(entries) != 1 || entries[0].Metadata["remote"] != metadata["remote"] || entries[0].Metadata["pid"] != metadata["pid"] {
+		t.Fatal("expected metadata echoed in the audit entry, got", entries)
+	}
+}
+
+func TestPairingFingerprint(t *testing.T) {
+	ec := NewTestEnclaveClient(&kr.ResponseTransport{T: t})
+	if _, err := ec.PairingFingerprint(); err != ErrNotPaired {
+		t.Fatal("expected ErrNotPaired before pairing, got", err)
+	}
+	ps := PairClient(t, ec)
+	defer ec.Stop()
+
+	fingerprint, err := ec.PairingFingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := ps.Fingerprint(); fingerprint == "" || fingerprint != expected {
+		t.Fatal("expected the pairing's fingerprint, got", fingerprint)
+	}
+
+	ec.Unpair()
+	PairClient(t, ec)
+	if refingerprint, _ := ec.PairingFingerprint(); refingerprint == fingerprint {
+		t.Fatal("expected a new fingerprint after pairing again")
+	}
+}
+
+func TestPairedSince(t *testing.T) {
+	ec := NewTestEnclaveClient(&kr.ResponseTransport{T: t})
+	if _, ok := ec.PairedSince(); ok {
+		t.Fatal("expected no pairing time before pairing")
+	}
+	before := time.Now()
+	PairClient(t, ec)
+	defer ec.Stop()
+	since, ok := ec.PairedSince()
+	if !ok || since.Before(before) || since.After(time.Now()) {
+		t.Fatal("expected the pairing time, got", since, ok)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	for i := 0; i < DIAGNOSTICS_AUDIT_ENTRIES+2; i++ {
+		digest := sha256.Sum256([]byte{byte(i)})
+		if _, _, err := ec.RequestSignature(kr.SignRequest{
+			PublicKeyFingerprint: me.PublicKeyFingerprint(),
+			Data:                 digest[:],
+			Metadata:             map[string]string{"token": "secret"},
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diagnostics := ec.Diagnostics()
+	if !diagnostics.Paired || diagnostics.Version != kr.CURRENT_VERSION.String() {
+		t.Fatal("unexpected diagnostics", diagnostics)
+	}
+	if len(diagnostics.Pairings) != 1 || diagnostics.Pairings[0].EnclaveVersion == nil || *diagnostics.Pairings[0].EnclaveVersion != kr.MOCK_ENCLAVE_VERSION.String() {
+		t.Fatal("expected the phone's version in the pairing diagnostics, got", diagnostics.Pairings)
+	}
+	if len(diagnostics.Transports) != 3 {
+		t.Fatal("expected every transport in the diagnostics, got", diagnostics.Transports)
+	}
+	if len(diagnostics.RecentSignatures) != DIAGNOSTICS_AUDIT_ENTRIES {
+		t.Fatal("expected the most recent signatures, got", len(diagnostics.RecentSignatures))
+	}
+	for _, entry := range diagnostics.RecentSignatures {
+		if entry.Metadata != nil {
+			t.Fatal("expected metadata redacted from diagnostics")
+		}
+	}
+	if ec.AuditLog()[0].Metadata == nil {
+		t.Fatal("expected redaction to leave the audit log intact")
+	}
+}
+
+func TestCancelRequest(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	requestID, err := kr.Rand128Base62()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithRequestID(context.Background(), requestID)
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	done := make(chan struct{})
+	go func() {
+		//	wait for the request to be sent
+		for ec.CancelRequest(requestID) == ErrRequestNotPending {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-done:
+				return
+			}
+		}
+	}()
+	start := time.Now()
+	_, _, err = ec.RequestSignatureCtx(ctx, kr.SignRequest{PublicKeyFingerprint: fp[:], Data: []byte("data")}, nil)
+	close(done)
+	if !errors.Is(err, ErrRequestCanceled) {
+		t.Fatal("expected canceled request, got", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("cancel did not return promptly")
+	}
+	if err = ec.CancelRequest(requestID); err != ErrRequestNotPending {
+		t.Fatal("expected no pending request, got", err)
+	}
+}
+
+func TestCancelAll(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _, err := ec.RequestSignature(kr.SignRequest{PublicKeyFingerprint: me.PublicKeyFingerprint(), Data: []byte("data")}, nil)
+			errs <- err
+		}()
+	}
+	kr.TrueBefore(t, func() bool {
+		return ec.Stats().PendingRequests == 2
+	}, time.Now().Add(5*time.Second))
+
+	if canceled := ec.CancelAll(); canceled != 2 {
+		t.Fatal("expected 2 requests canceled, got", canceled)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrRequestCanceled) {
+				t.Fatal("expected canceled request, got", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("cancel did not return promptly")
+		}
+	}
+	if pending := ec.Stats().PendingRequests; pending != 0 {
+		t.Fatal("expected no pending requests, got", pending)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	testSignatureSuccess(t, ec)
+
+	if ec.Metrics().AverageSignatureLatency() <= 0 {
+		t.Fatal("expected signature latency to be recorded")
+	}
+	var text bytes.Buffer
+	ec.Metrics().WriteText(&text)
+	for _, line := range []string{
+		`kr_requests_total{type="sign"} 1`,
+		`kr_responses_total{medium="sqs"}`,
+		`kr_signatures_total{result="success"} 1`,
+		`kr_signature_latency_seconds_count 1`,
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Fatal("metrics missing", line, "in", text.String())
+		}
+	}
+}
+
+func TestExportImportPairing(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	ps := PairClient(t, ec)
+	defer ec.Stop()
+
+	data, err := ec.ExportPairing("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	persister := &kr.MemoryPersister{}
+	importer := UnpairedEnclaveClient(transport, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil)
+	if err = importer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer importer.Stop()
+	if err = importer.ImportPairing(data, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if !importer.IsPaired() {
+		t.Fatal("expected imported pairing to be paired")
+	}
+	pairings, err := persister.LoadPairings()
+	if err != nil || len(pairings) != 1 || !pairings[0].Equals(ps) {
+		t.Fatal("expected imported pairing to be persisted", err)
+	}
+}
+
+func TestImportPairingFailsPendingRequests(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	data, err := ec.ExportPairing("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importer := NewTestEnclaveClient(&kr.ResponseTransport{T: t, DoNotRespond: true})
+	PairClient(t, importer)
+	//	the pairing me request is still pending
+	pendingBefore := importer.(*EnclaveClient).pendingRequestCount()
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := importer.RequestSignature(kr.SignRequest{Data: []byte("data")}, nil)
+		errs <- err
+	}()
+	kr.TrueBefore(t, func() bool {
+		return importer.(*EnclaveClient).pendingRequestCount() > pendingBefore
+	}, time.Now().Add(time.Second))
+
+	if err = importer.ImportPairing(data, ""); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if err != ErrRepaired {
+			t.Fatal("expected ErrRepaired, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the pending request to fail on import")
+	}
+
+	importer.(*EnclaveClient).Close()
+	if err = importer.ImportPairing(data, ""); err != ErrClosed {
+		t.Fatal("expected ErrClosed after Close, got", err)
+	}
+}
+
+func TestStopFailsPendingRequests(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	//	the pairing me request is still pending
+	pendingBefore := ec.(*EnclaveClient).pendingRequestCount()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := ec.RequestSignature(kr.SignRequest{Data: []byte("data")}, nil)
+		errs <- err
+	}()
+	kr.TrueBefore(t, func() bool { return ec.(*EnclaveClient).pendingRequestCount() > pendingBefore }, time.Now().Add(time.Second))
+
+	ec.(*EnclaveClient).SetStopGracePeriod(200 * time.Millisecond)
+	start := time.Now()
+	ec.Stop()
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatal("stop did not wait for the grace period")
+	}
+	select {
+	case err := <-errs:
+		if err != ErrStopped {
+			t.Fatal("expected ErrStopped, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request not failed by stop")
+	}
+}
+
+func TestStopReturnsOnceRequestsDrain(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	transport := &kr.ResponseTransport{T: t}
+	ec := UnpairedEnclaveClient(transport, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil, WithClock(clock)).(*EnclaveClient)
+	PairClient(t, ec)
+	kr.TrueBefore(t, func() bool { return ec.pendingRequestCount() == 0 }, time.Now().Add(time.Second))
+	transport.Lock()
+	transport.DoNotRespond = true
+	transport.Unlock()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := ec.RequestSignature(kr.SignRequest{Data: []byte("data")}, nil)
+		errs <- err
+	}()
+	kr.TrueBefore(t, func() bool { return ec.pendingRequestCount() > 0 }, time.Now().Add(time.Second))
+
+	//	the clock never reaches the grace deadline, only the request
+	//	completing ends the wait
+	ec.SetStopGracePeriod(time.Hour)
+	stopped := make(chan struct{})
+	go func() {
+		ec.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stop did not wait for the pending request")
+	case <-time.After(100 * time.Millisecond):
+	}
+	ec.CancelAll()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop did not return once no request was pending")
+	}
+	if err := <-errs; !errors.Is(err, ErrRequestCanceled) {
+		t.Fatal("expected the request canceled, got", err)
+	}
+}
+
+func TestCachedAuthorizedKeyLine(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	if _, err := ec.CachedAuthorizedKeyLine(); err != ErrNoCachedMe {
+		t.Fatal("expected ErrNoCachedMe, got", err)
+	}
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool { return ec.GetCachedMe() != nil }, time.Now().Add(time.Second))
+
+	me, _, _ := kr.TestMe(t)
+	wire, err := ec.CachedPublicKeyWire()
+	if err != nil || !bytes.Equal(wire, me.SSHWirePublicKey) {
+		t.Fatal("unexpected wire public key", err)
+	}
+	expected, err := me.AuthorizedKeyString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, err := ec.CachedAuthorizedKeyLine(); err != nil || line != expected {
+		t.Fatal("unexpected authorized key line", line, err)
+	}
+}
+
+func TestOutgoingQueuePriority(t *testing.T) {
+	var queue outgoingQueue
+	for i, priority := range []int{kr.PRIORITY_BACKGROUND, kr.PRIORITY_NORMAL, kr.PRIORITY_INTERACTIVE, kr.PRIORITY_BACKGROUND, kr.PRIORITY_INTERACTIVE} {
+		queue.push(kr.QueuedMessage{
+			Message:  []byte{byte(i)},
+			QueuedAt: time.Now(),
+			Priority: priority,
+		})
+	}
+	var order []byte
+	for _, message := range queue.drain(time.Now()) {
+		order = append(order, message.Message[0])
+	}
+	if !bytes.Equal(order, []byte{2, 4, 1, 0, 3}) {
+		t.Fatal("unexpected send order", order)
+	}
+
+	for i := 0; i < MAX_QUEUED_MESSAGES; i++ {
+		queue.push(kr.QueuedMessage{QueuedAt: time.Now()})
+	}
+	if queue.push(kr.QueuedMessage{QueuedAt: time.Now(), Priority: kr.PRIORITY_INTERACTIVE}) {
+		t.Fatal("expected full queue to reject messages")
+	}
+}
+
+func TestOutgoingQueueDropOldest(t *testing.T) {
+	queue := outgoingQueue{capacity: 2, overflow: DropOldest}
+	for i := 0; i < 3; i++ {
+		if !queue.push(kr.QueuedMessage{Message: []byte{byte(i)}, QueuedAt: time.Now().Add(time.Duration(i) * time.Millisecond)}) {
+			t.Fatal("expected DropOldest to accept every message")
+		}
+	}
+	var kept []byte
+	for _, message := range queue.drain(time.Now()) {
+		kept = append(kept, message.Message[0])
+	}
+	if !bytes.Equal(kept, []byte{1, 2}) {
+		t.Fatal("expected the oldest message to be dropped, kept", kept)
+	}
+}
+
+func TestOutgoingQueueRejectWithError(t *testing.T) {
+	//	the phone never scans the pairing, so requests wait for its key
+	ec := NewTestEnclaveClientShortTimeouts(&kr.NoopTransport{}, WithOutgoingQueueLimit(1, RejectWithError))
+	if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ec.Stop()
+	if _, err := ec.Pair(kr.PairingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	ec.RequestMeCtx(ctx, kr.MeRequest{}, true)
+	if depth := ec.QueueDepth(); depth != 1 {
+		t.Fatal("expected 1 queued message, got", depth)
+	}
+	if _, err := ec.RequestMe(kr.MeRequest{}, true); err != ErrQueueFull {
+		t.Fatal("expected ErrQueueFull, got", err)
+	}
+}
+
+func TestEvictionMetrics(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	ec.SetRequestTimeouts(200*time.Millisecond, 0, 0)
+	metrics := ec.Metrics()
+	timedOut := metrics.Evicted(EVICTED_TIMEOUT)
+
+	if _, err := ec.RequestMe(kr.MeRequest{}, false); err != ErrTimeout {
+		t.Fatal("expected ErrTimeout, got", err)
+	}
+	if metrics.Evicted(EVICTED_TIMEOUT) != timedOut+1 || ec.Stats().TimedOutRequests != timedOut+1 {
+		t.Fatal("expected a timeout eviction, got", metrics.Evicted(EVICTED_TIMEOUT))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ec.RequestMeCtx(ctx, kr.MeRequest{}, false)
+	if metrics.Evicted(EVICTED_CANCELED) != 1 || metrics.Evicted(EVICTED_TIMEOUT) != timedOut+1 {
+		t.Fatal("expected a cancellation counted apart from timeouts")
+	}
+
+	//	the phone never scans the pairing, so requests wait for its key
+	unscanned := NewTestEnclaveClientShortTimeouts(&kr.NoopTransport{}, WithOutgoingQueueLimit(1, DropNewest))
+	if err := unscanned.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer unscanned.Stop()
+	if _, err := unscanned.Pair(kr.PairingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		unscanned.RequestMeCtx(ctx, kr.MeRequest{}, true)
+		cancel()
+	}
+	if dropped := unscanned.Metrics().Evicted(EVICTED_CAPACITY); dropped != 1 || unscanned.Stats().DroppedRequests != 1 {
+		t.Fatal("expected one capacity eviction, got", dropped)
+	}
+	buf := &bytes.Buffer{}
+	unscanned.Metrics().WriteText(buf)
+	if !strings.Contains(buf.String(), `kr_evicted_requests_total{reason="capacity"} 1`) {
+		t.Fatal("expected evictions in the metrics text, got", buf.String())
+	}
+}
+
+func TestRetryLast(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool { return ec.GetCachedMe() != nil }, time.Now().Add(time.Second))
+
+	if _, err := ec.RetryLast(); err != ErrNoFailedRequest {
+		t.Fatal("expected ErrNoFailedRequest before any failure, got", err)
+	}
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("retry"))
+	transport.Lock()
+	transport.DropRequests = 1
+	transport.Unlock()
+	if _, _, err := ec.RequestSignatureWithTimeout(kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+	}, 200*time.Millisecond, nil); err != ErrTimeout {
+		t.Fatal("expected ErrTimeout, got", err)
+	}
+
+	response, err := ec.RetryLast()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.SignResponse == nil || response.SignResponse.Signature == nil {
+		t.Fatal("expected the retried request to be signed, got", response)
+	}
+	if _, err := ec.RetryLast(); err != ErrNoFailedRequest {
+		t.Fatal("expected the failed request forgotten after succeeding, got", err)
+	}
+}
+
+func TestPendingRequestsNotEvicted(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := UnpairedEnclaveClient(transport, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil, WithRequestCacheSize(2))
+	PairClient(t, ec)
+	defer ec.Stop()
+	pendingBefore := ec.(*EnclaveClient).pendingRequestCount()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 4; i++ {
+		go ec.RequestSignatureCtx(ctx, kr.SignRequest{Data: []byte{byte(i)}}, nil)
+	}
+	kr.TrueBefore(t, func() bool {
+		return ec.(*EnclaveClient).pendingRequestCount() == pendingBefore+4
+	}, time.Now().Add(time.Second))
+}
+
+func TestRequestCallbacksExpire(t *testing.T) {
+	callbacks := newRequestCallbacks(0, realClock{})
+	callbacks.Add("expired", "", make(chan *callbackT), time.Now().Add(-time.Second))
+	callbacks.Add("pending", "", make(chan *callbackT), time.Now().Add(time.Minute))
+	if _, ok := callbacks.Get("expired"); ok {
+		t.Fatal("expected expired callback to be dropped")
+	}
+	if _, ok := callbacks.Get("pending"); !ok || callbacks.Len() != 1 {
+		t.Fatal("expected pending callback to be kept")
+	}
+}
+
+func TestRequestCallbacksExpireByClock(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	callbacks := newRequestCallbacks(0, clock)
+	callbacks.Add("pending", "key", make(chan *callbackT), clock.Now().Add(time.Minute))
+	clock.Advance(2 * time.Minute)
+	if _, _, ok := callbacks.GetByIdempotencyKey("key"); ok {
+		t.Fatal("expected callback expired on the client's clock")
+	}
+	if _, ok := callbacks.Get("pending"); ok {
+		t.Fatal("expected callback expired on the client's clock")
+	}
+}
+
+func TestSignatureLatencyPercentiles(t *testing.T) {
+	metrics := NewMetrics()
+	for i := 1; i <= 100; i++ {
+		metrics.observeSignature(time.Duration(i)*time.Millisecond, true)
+	}
+	p50, p95, p99 := metrics.SignatureLatencyPercentiles()
+	if p50 != 50*time.Millisecond || p95 != 95*time.Millisecond || p99 != 99*time.Millisecond {
+		t.Fatal("unexpected percentiles", p50, p95, p99)
+	}
+
+	metrics.SetLatencyWindow(time.Nanosecond)
+	<-time.After(time.Millisecond)
+	if p50, _, _ = metrics.SignatureLatencyPercentiles(); p50 != 0 {
+		t.Fatal("expected samples outside the window to be dropped, got", p50)
+	}
+}
+
+func TestSignatureLatencyWindowUsesClientClock(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	ec := UnpairedEnclaveClient(&kr.ResponseTransport{T: t}, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil, WithClock(clock)).(*EnclaveClient)
+	metrics := ec.Metrics()
+	metrics.SetLatencyWindow(time.Hour)
+	metrics.observeSignature(10*time.Millisecond, true)
+
+	clock.Advance(59 * time.Minute)
+	if p50, _, _ := metrics.SignatureLatencyPercentiles(); p50 != 10*time.Millisecond {
+		t.Fatal("expected sample within the window on the client's clock, got", p50)
+	}
+	clock.Advance(2 * time.Minute)
+	if p50, _, _ := metrics.SignatureLatencyPercentiles(); p50 != 0 {
+		t.Fatal("expected sample aged out on the client's clock, got", p50)
+	}
+}
+
+func TestValidateSignRequest(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool { return ec.GetCachedMe() != nil }, time.Now().Add(time.Second))
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	if err := ec.ValidateSignRequest(kr.SignRequest{PublicKeyFingerprint: fp, Data: []byte("data")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ec.ValidateSignRequest(kr.SignRequest{PublicKeyFingerprint: fp}).(*ProtoError); !ok {
+		t.Fatal("expected ProtoError for empty data")
+	}
+	otherFp := sha256.Sum256([]byte("other key"))
+	if err := ec.ValidateSignRequest(kr.SignRequest{PublicKeyFingerprint: otherFp[:], Data: []byte("data")}); err != ErrUnknownPublicKey {
+		t.Fatal("expected ErrUnknownPublicKey, got", err)
+	}
+	if sent := transport.GetSentSignRequests(); sent != 0 {
+		t.Fatal("validation sent", sent, "sign requests")
+	}
+}
+
+func TestSignWithSelectedKey(t *testing.T) {
+	workKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workPK, err := ssh.NewPublicKey(&workKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &kr.ResponseTransport{T: t, ExtraKeys: []crypto.Signer{workKey}}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool { return ec.GetCachedMe() != nil }, time.Now().Add(time.Second))
+
+	digest := sha256.Sum256([]byte("work"))
+	workFp := sha256.Sum256(workPK.Marshal())
+	signResponse, _, err := ec.RequestSignature(kr.SignRequest{PublicKeyFingerprint: workFp[:], Data: digest[:]}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPKCS1v15(&workKey.PublicKey, crypto.SHA256, digest[:], *signResponse.Signature); err != nil {
+		t.Fatal("expected a signature by the selected key, got", err)
+	}
+
+	digest = sha256.Sum256([]byte("paired"))
+	signResponse, _, err = ec.RequestSignature(kr.SignRequest{Data: digest[:]}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sk, _ := kr.TestMe(t)
+	if err := rsa.VerifyPKCS1v15(&sk.PublicKey, crypto.SHA256, digest[:], *signResponse.Signature); err != nil {
+		t.Fatal("expected the paired key to sign without a fingerprint, got", err)
+	}
+
+	otherFp := sha256.Sum256([]byte("other key"))
+	if _, _, err := ec.RequestSignature(kr.SignRequest{PublicKeyFingerprint: otherFp[:], Data: digest[:]}, nil); err != ErrKeyNotFound {
+		t.Fatal("expected ErrKeyNotFound from the phone, got", err)
+	}
+	if _, err := ec.AvailableKeys(); err != nil {
+		t.Fatal(err)
+	}
+	sent := transport.GetSentSignRequests()
+	if _, _, err := ec.RequestSignature(kr.SignRequest{PublicKeyFingerprint: otherFp[:], Data: []byte("unlisted")}, nil); err != ErrKeyNotFound {
+		t.Fatal("expected ErrKeyNotFound for an unlisted key, got", err)
+	}
+	if transport.GetSentSignRequests() != sent {
+		t.Fatal("expected no request for a key the phone did not list")
+	}
+	if err := ec.ValidateSignRequest(kr.SignRequest{PublicKeyFingerprint: workFp[:], Data: []byte("data")}); err != nil {
+		t.Fatal("expected a listed key to validate, got", err)
+	}
+}
+
+func TestSignatureAlgorithm(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	digest := sha256.Sum256([]byte("data"))
+	algorithm := "rsa-sha2-256"
+	signResponse, _, err := ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: fp,
+		Data:                 digest[:],
+		Algorithm:            &algorithm,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signResponse.Algorithm == nil || *signResponse.Algorithm != algorithm {
+		t.Fatal("expected response signed with", algorithm)
+	}
+
+	transport.Lock()
+	sha1 := "ssh-rsa"
+	transport.SignatureAlgorithm = &sha1
+	transport.Unlock()
+	otherDigest := sha256.Sum256([]byte("other data"))
+	_, _, err = ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: fp,
+		Data:                 otherDigest[:],
+		Algorithm:            &algorithm,
+	}, nil)
+	if _, ok := err.(*ProtoError); !ok {
+		t.Fatal("expected ProtoError for mismatched algorithm, got", err)
+	}
+}
+
+func TestCorruptCiphertextInBatch(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	transport.Lock()
+	transport.CorruptCiphertexts = true
+	transport.Unlock()
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("data"))
+	start := time.Now()
+	signResponse, _, err := ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signResponse == nil || signResponse.Signature == nil {
+		t.Fatal("expected signature despite corrupt ciphertexts")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal("corrupt ciphertexts delayed the response by", elapsed)
+	}
+}
+
+func TestOversizedCiphertext(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClientShortTimeouts(transport, WithMaxCiphertextSize(1<<16)).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	if err := ec.handleCiphertext(make([]byte, 1<<16+1), SQS); err != ErrMessageTooLarge {
+		t.Fatal("expected ErrMessageTooLarge, got", err)
+	}
+	if ec.Metrics().OversizedCiphertexts() != 1 {
+		t.Fatal("expected the oversized ciphertext counted")
+	}
+	if _, err := ec.RequestList(); err != nil {
+		t.Fatal("expected responses under the limit to be handled, got", err)
+	}
+}
+
+func TestForeignCiphertext(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	//	a pairing between another workstation and phone
+	foreign, err := kr.GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enclavePublicKey, _, err := kr.GenKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign.EnclavePublicKey = &enclavePublicKey
+	ciphertext, err := foreign.EncryptMessage([]byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ec.handleCiphertext(ciphertext, SQS); err != ErrForeignCiphertext {
+		t.Fatal("expected ErrForeignCiphertext, got", err)
+	}
+	if count := ec.Metrics().ForeignCiphertexts(); count != 1 {
+		t.Fatal("expected 1 foreign ciphertext, got", count)
+	}
+	//	malformed ciphertexts are still errors
+	if err := ec.handleCiphertext([]byte("corrupt"), SQS); err == nil || err == ErrForeignCiphertext {
+		t.Fatal("expected error for malformed ciphertext, got", err)
+	}
+}
+
+func (l *recordingLogger) contains(substr string) bool {
+	l.Lock()
+	defer l.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, Ack: true, SendAfterHalfAckDelay: true}
+	ec := NewTestEnclaveClient(transport)
+	logger := &recordingLogger{}
+	ec.SetLogger(logger)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("data"))
+	ctx := WithRequestID(context.Background(), "trace-me")
+	if _, _, err := ec.RequestSignatureCtx(ctx, kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"[req=trace-me] request ACKed",
+		"[req=trace-me] found callback for request",
+	} {
+		if !logger.contains(line) {
+			t.Fatal("expected log line", line)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	if stats := ec.Stats(); stats.Paired || stats.PendingRequests != 0 {
+		t.Fatal("unexpected stats before pairing", stats)
+	}
+	testSignatureSuccess(t, ec)
+
+	stats := ec.Stats()
+	if !stats.Paired {
+		t.Fatal("expected paired stats")
+	}
+	if stats.PendingRequests != 0 || stats.OutgoingQueueDepth != 0 {
+		t.Fatal("expected nothing pending, got", stats)
+	}
+	if stats.LastSignatureLatency <= 0 {
+		t.Fatal("expected last signature latency, got", stats.LastSignatureLatency)
+	}
+}
+
+func TestKeyUnwrapStats(t *testing.T) {
+	ec := NewTestEnclaveClient(&kr.ResponseTransport{T: t})
+	if stats := ec.Stats(); stats.KeyUnwraps != 0 || !stats.LastKeyUnwrap.IsZero() {
+		t.Fatal("unexpected key unwraps before pairing", stats)
+	}
+	before := time.Now()
+	PairClient(t, ec)
+	defer ec.Stop()
+	stats := ec.Stats()
+	if stats.KeyUnwraps != 1 {
+		t.Fatal("expected one key unwrap, got", stats.KeyUnwraps)
+	}
+	if stats.LastKeyUnwrap.Before(before) || stats.LastKeyUnwrap.After(time.Now()) {
+		t.Fatal("expected the key unwrap time, got", stats.LastKeyUnwrap)
+	}
+}
+
+func TestClose(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	events := ec.Subscribe()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := ec.RequestMe(kr.MeRequest{}, false)
+		errChan <- err
+	}()
+	kr.TrueBefore(t, func() bool {
+		return ec.Stats().PendingRequests > 0
+	}, time.Now().Add(time.Second))
+
+	if err := ec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errChan:
+		if err != ErrClosed {
+			t.Fatal("expected pending request to fail with ErrClosed, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request not failed on close")
+	}
+	for range events {
+	}
+	if _, err := ec.RequestMe(kr.MeRequest{}, false); err != ErrClosed {
+		t.Fatal("expected ErrClosed after close, got", err)
+	}
+	if err := ec.Start(); err != ErrClosed {
+		t.Fatal("expected ErrClosed starting closed client, got", err)
+	}
+	if err := ec.Close(); err != nil {
+		t.Fatal("expected second close to succeed, got", err)
+	}
+}
+
+func TestPairFailsPendingRequests(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, DoNotRespond: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := ec.RequestList()
+		errChan <- err
+	}()
+	//	the list request, and PairClient's unanswered me request
+	kr.TrueBefore(t, func() bool {
+		return ec.Stats().PendingRequests == 2
+	}, time.Now().Add(time.Second))
+
+	if _, err := ec.Pair(kr.PairingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errChan:
+		if err != ErrRepaired {
+			t.Fatal("expected pending request to fail with ErrRepaired, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request not failed on re-pair")
+	}
+}
+
+func TestPairWithRelay(t *testing.T) {
+	relay := &kr.RelayConfig{Region: "eu-west-1", SQSEndpoint: "https://sqs.example.com"}
+	ec := NewTestEnclaveClientShortTimeouts(&kr.ResponseTransport{T: t}, WithRelay(relay))
+	if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ec.Stop()
+
+	ps, err := ec.Pair(kr.PairingOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Relay != relay {
+		t.Fatal("expected pairing on the configured relay")
+	}
+	other := &kr.RelayConfig{Region: "ap-south-1"}
+	ps, err = ec.Pair(kr.PairingOptions{Relay: other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Relay != other {
+		t.Fatal("expected pairing options to override the relay")
+	}
+}
+
+func TestRememberHost(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	ec.SetRememberHostTTL(time.Hour)
+
+	me, _, _ := kr.TestMe(t)
+	sign := func(data string) {
+		digest := sha256.Sum256([]byte(data))
+		if _, _, err := ec.RequestSignature(kr.SignRequest{
+			PublicKeyFingerprint: me.PublicKeyFingerprint(),
+			Data:                 digest[:],
+			HostAuth:             &kr.HostAuth{HostNames: []string{"example.com"}},
+		}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sign("first")
+	sign("second")
+	ec.ForgetHost("example.com")
+	sign("third")
+
+	auditLog := ec.AuditLog()
+	if len(auditLog) != 3 {
+		t.Fatal("expected 3 audit entries, got", len(auditLog))
+	}
+	for i, autoApproved := range []bool{false, true, false} {
+		if auditLog[i].AutoApproved != autoApproved {
+			t.Fatal("signature", i, "auto approved:", auditLog[i].AutoApproved)
+		}
+	}
+}
+
+func TestHostApprovalsPersisted(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	persister := &kr.MemoryPersister{}
+	expired := kr.NewHostApprovals()
+	expired.Approved["old.example.com"] = kr.HostApproval{At: clock.Now().Add(-2 * time.Hour), Expires: clock.Now().Add(-time.Hour)}
+	persister.SaveHostApprovals(expired)
+
+	transport := &kr.ResponseTransport{T: t}
+	ec := UnpairedEnclaveClient(transport, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil, WithClock(clock)).(*EnclaveClient)
+	PairClient(t, ec)
+	ec.SetRememberHostTTL(time.Hour)
+	if _, ok := ec.hostApprovals.Approved["old.example.com"]; ok {
+		t.Fatal("expected the expired approval dropped on start")
+	}
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("data"))
+	signRequest := kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+		HostAuth:             &kr.HostAuth{HostNames: []string{"example.com"}},
+	}
+	if _, _, err := ec.RequestSignature(signRequest, nil); err != nil {
+		t.Fatal(err)
+	}
+	approvals, err := persister.LoadHostApprovals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approval, ok := approvals.Approved["example.com"]; !ok || !approval.Expires.Equal(approval.At.Add(time.Hour)) {
+		t.Fatal("expected the approval persisted, got", approvals)
+	}
+
+	ec.ForgetHost("example.com")
+	ec.Stop()
+
+	restarted := UnpairedEnclaveClient(transport, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil, WithClock(clock)).(*EnclaveClient)
+	if err := restarted.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Stop()
+	restarted.SetRememberHostTTL(time.Hour)
+	clock.Advance(10 * time.Minute)
+	if policy := restarted.rememberHostPolicy(signRequest); policy == nil || policy.TTLSeconds != 600 {
+		t.Fatal("expected forgetting to survive a restart, got", policy)
+	}
+}
+
+func TestHostPolicy(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	sign := func(host string) (err error) {
+		digest := sha256.Sum256([]byte(host))
+		_, _, err = ec.RequestSignature(kr.SignRequest{
+			PublicKeyFingerprint: me.PublicKeyFingerprint(),
+			Data:                 digest[:],
+			HostAuth:             &kr.HostAuth{HostNames: []string{host}},
+		}, nil)
+		return
+	}
+
+	if err := ec.SetHostPolicy(HostPolicy{Deny: []string{"*.example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sign("git.example.com"); err != ErrPolicyDenied {
+		t.Fatal("expected denied host to fail with ErrPolicyDenied, got", err)
+	}
+	if err := sign("example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ec.SetHostPolicy(HostPolicy{Allow: []string{"github.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sign("example.org"); err != ErrPolicyDenied {
+		t.Fatal("expected host outside the allowlist to fail with ErrPolicyDenied, got", err)
+	}
+	if err := sign("github.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := ec.Metrics().requestsByType["sign"]; sent != 2 {
+		t.Fatal("expected only allowed requests to reach the phone, got", sent)
+	}
+	if err := ec.SetHostPolicy(HostPolicy{Deny: []string{"["}}); err == nil {
+		t.Fatal("expected invalid pattern to be rejected")
+	}
+}
+
+func TestHostPolicyInBatch(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	if err := ec.SetHostPolicy(HostPolicy{Deny: []string{"*.example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	me, _, _ := kr.TestMe(t)
+	digestA := sha256.Sum256([]byte("a"))
+	digestB := sha256.Sum256([]byte("b"))
+	signRequests := []kr.SignRequest{
+		kr.SignRequest{
+			PublicKeyFingerprint: me.PublicKeyFingerprint(),
+			Data:                 digestA[:],
+			HostAuth:             &kr.HostAuth{HostNames: []string{"example.org"}},
+		},
+		kr.SignRequest{
+			PublicKeyFingerprint: me.PublicKeyFingerprint(),
+			Data:                 digestB[:],
+			HostAuth:             &kr.HostAuth{HostNames: []string{"git.example.com"}},
+		},
+	}
+	if _, err := ec.RequestSignatureBatch(signRequests); err != ErrPolicyDenied {
+		t.Fatal("expected a batch with a denied host to fail with ErrPolicyDenied, got", err)
+	}
+	request, err := kr.NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.SignBatchRequest = &kr.SignBatchRequest{Requests: signRequests}
+	if _, err := ec.RawRequest(request, 0); err != ErrPolicyDenied {
+		t.Fatal("expected a raw batch with a denied host to fail with ErrPolicyDenied, got", err)
+	}
+	if sent := ec.Metrics().requestsByType["sign_batch"]; sent != 0 {
+		t.Fatal("expected no batch to reach the phone, got", sent)
+	}
+	if entries := ec.AuditLog(); len(entries) != 2 || entries[0].Succeeded || entries[0].HostNames[0] != "git.example.com" {
+		t.Fatal("expected the denied requests audited, got", entries)
+	}
+}
+
+func TestRememberHostPolicyAfterForget(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	ec := UnpairedEnclaveClient(&kr.NoopTransport{}, &kr.MemoryPersister{}, nil, nil, nil, WithClock(clock)).(*EnclaveClient)
+	signRequest := kr.SignRequest{HostAuth: &kr.HostAuth{HostNames: []string{"example.com"}}}
+	if policy := ec.rememberHostPolicy(signRequest); policy != nil {
+		t.Fatal("expected no policy by default, got", policy)
+	}
+
+	ec.SetRememberHostTTL(time.Hour)
+	ec.ForgetHost("example.com")
+	if policy := ec.rememberHostPolicy(signRequest); policy != nil {
+		t.Fatal("expected no policy right after forgetting, got", policy)
+	}
+	//	only approvals made since the host was forgotten count
+	clock.Advance(10 * time.Minute)
+	if policy := ec.rememberHostPolicy(signRequest); policy == nil || policy.Host != "example.com" || policy.TTLSeconds != 600 {
+		t.Fatal("expected TTL shortened to time since forgetting, got", policy)
+	}
+	clock.Advance(time.Hour)
+	if policy := ec.rememberHostPolicy(signRequest); policy == nil || policy.TTLSeconds != 3600 {
+		t.Fatal("expected full TTL once forgetting expires, got", policy)
+	}
+}
+
+func TestRequestListStream(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	for i := 0; i < 2*LIST_PAGE_SIZE+1; i++ {
+		_, sk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.ExtraKeys = append(transport.ExtraKeys, sk)
+	}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	var pageSizes []int
+	fingerprints := map[string]bool{}
+	for chunk := range ec.RequestListStream(context.Background()) {
+		if chunk.Err != nil {
+			t.Fatal(chunk.Err)
+		}
+		pageSizes = append(pageSizes, len(chunk.Keys))
+		for _, key := range chunk.Keys {
+			fingerprints[string(key.PublicKeyFingerprint())] = true
+		}
+	}
+	if len(pageSizes) != 3 || pageSizes[0] != LIST_PAGE_SIZE || pageSizes[2] != 2 {
+		t.Fatal("expected the keys in pages of", LIST_PAGE_SIZE, "got", pageSizes)
+	}
+	if len(fingerprints) != 2*LIST_PAGE_SIZE+2 {
+		t.Fatal("expected every key once, got", len(fingerprints))
+	}
+}
+
+func TestRequestListStreamOutlastsTimeout(t *testing.T) {
+	//	each page comes well within the list timeout, all of them take longer
+	transport := &kr.ResponseTransport{T: t, PageDelay: 600 * time.Millisecond}
+	for i := 0; i < 4*LIST_PAGE_SIZE; i++ {
+		_, sk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport.ExtraKeys = append(transport.ExtraKeys, sk)
+	}
+	ec := NewTestEnclaveClientShortTimeouts(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	start := time.Now()
+	var pages int
+	for chunk := range ec.RequestListStream(context.Background()) {
+		if chunk.Err != nil {
+			t.Fatal(chunk.Err)
+		}
+		pages++
+	}
+	if pages != 5 {
+		t.Fatal("expected every page, got", pages)
+	}
+	if elapsed := time.Since(start); elapsed < ec.getTimeouts().List.Fail+kr.SHORT_ACK_DELAY {
+		t.Fatal("expected the pages to take longer than the timeout, took", elapsed)
+	}
+}
+
+func TestRequestListStreamCanceled(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	transport.Lock()
+	transport.DoNotRespond = true
+	transport.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	for chunk := range ec.RequestListStream(ctx) {
+		if !errors.Is(chunk.Err, context.DeadlineExceeded) {
+			t.Fatal("expected the list to be canceled, got", chunk)
+		}
+	}
+}
+
+func TestAvailableKeys(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	keys, err := ec.AvailableKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	me, _, _ := kr.TestMe(t)
+	pk, err := me.SSHPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Fingerprint != ssh.FingerprintSHA256(pk) || keys[0].Type != pk.Type() || keys[0].Comment != me.Email {
+		t.Fatal("unexpected keys", keys)
+	}
+
+	//	answered from the cache
+	requests := ec.Metrics().requestsByType["list"]
+	if _, err := ec.AvailableKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if ec.Metrics().requestsByType["list"] != requests {
+		t.Fatal("expected cached keys without another list request")
+	}
+}
+
+func TestThrottledRead(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	transport.Lock()
+	transport.ThrottleReads = 1
+	transport.Unlock()
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("data"))
+	signResponse, _, err := ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signResponse == nil || signResponse.Signature == nil {
+		t.Fatal("expected signature after throttled read")
+	}
+	if throttled := ec.Metrics().Throttled(); throttled != 1 {
+		t.Fatal("expected 1 throttled read, got", throttled)
+	}
+}
+
+func TestTransientReadErrorsRetried(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	transport.Lock()
+	transport.ReadErrors = []error{
+		awserr.New("RequestError", "send request failed", nil),
+		awserr.New("RequestError", "send request failed", nil),
+	}
+	transport.Unlock()
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("data"))
+	signResponse, _, err := ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signResponse == nil || signResponse.Signature == nil {
+		t.Fatal("expected signature after transient read errors")
+	}
+}
+
+func TestPermanentReadErrorFailsRequest(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+
+	transport.Lock()
+	denied := awserr.New("AccessDenied", "access to the resource is denied", nil)
+	transport.ReadErrors = []error{denied, denied, denied}
+	transport.Unlock()
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("data"))
+	start := time.Now()
+	_, _, err := ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+	}, nil)
+	var fatalErr *FatalRecvError
+	if !errors.As(err, &fatalErr) {
+		t.Fatal("expected FatalRecvError, got", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("permanent read error did not fail the request promptly")
+	}
+}
+
+func TestRawRequest(t *testing.T) {
+	endpoint := "arn:aws:sns:us-east-1:911777333295:endpoint/APNS/test"
+	transport := &kr.ResponseTransport{T: t, SNSEndpointARN: &endpoint}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	request, err := kr.NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.MeRequest = &kr.MeRequest{}
+	response, err := ec.RawRequest(request, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.MeResponse == nil || response.SNSEndpointARN == nil || *response.SNSEndpointARN != endpoint {
+		t.Fatal("expected the full response, got", response)
+	}
+
+	transport.Lock()
+	transport.DoNotRespond = true
+	transport.Unlock()
+	start := time.Now()
+	if _, err = ec.RawRequest(request, 200*time.Millisecond); err != ErrTimeout {
+		t.Fatal("expected ErrTimeout, got", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("expected the given timeout to apply")
+	}
+}
+
+func TestUnpairDevice(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	second, err := ec.Pair(kr.PairingOptions{Add: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := ec.PairedDevices()
+	if len(devices) != 2 {
+		t.Fatal("expected 2 devices, got", len(devices))
+	}
+	secondID, _ := second.DeriveUUID()
+	if err = ec.UnpairDevice(strings.ToUpper(secondID.String())); err != nil {
+		t.Fatal(err)
+	}
+	devices = ec.PairedDevices()
+	if len(devices) != 1 || devices[0].ID == secondID.String() || !ec.IsPaired() {
+		t.Fatal("expected only the first device to remain paired")
+	}
+	if err = ec.UnpairDevice(secondID.String()); err != ErrDeviceNotFound {
+		t.Fatal("expected ErrDeviceNotFound, got", err)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	digest := sha256.Sum256([]byte("data"))
+	signRequest := kr.SignRequest{PublicKeyFingerprint: []byte("fp"), Data: digest[:]}
+	first, _ := kr.NewRequest()
+	first.SignRequest = &signRequest
+	resent, _ := kr.NewRequest()
+	resent.SignRequest = &signRequest
+	if idempotencyKey(first) == "" || idempotencyKey(first) != idempotencyKey(resent) {
+		t.Fatal("expected resent sign request to share the idempotency key")
+	}
+	otherDigest := sha256.Sum256([]byte("other data"))
+	resent.SignRequest = &kr.SignRequest{PublicKeyFingerprint: []byte("fp"), Data: otherDigest[:]}
+	if idempotencyKey(first) == idempotencyKey(resent) {
+		t.Fatal("expected different sign requests to have different idempotency keys")
+	}
+	if idempotencyKey(kr.Request{MeRequest: &kr.MeRequest{}}) != "" {
+		t.Fatal("expected no idempotency key for a me request")
+	}
+}
+
+func TestResponseMatchedByIdempotencyKey(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	ps := PairClient(t, ec)
+	defer ec.Stop()
+	client := ec.(*EnclaveClient)
+
+	cb := make(chan *callbackT, 1)
+	client.Lock()
+	client.requestCallbacksByRequestID.Add("resent", "key", cb, time.Now().Add(time.Minute))
+	client.Unlock()
+
+	signature := []byte("signature")
+	response, _ := json.Marshal(kr.Response{
+		RequestID:      "original",
+		IdempotencyKey: "key",
+		SignResponse:   &kr.SignResponse{Signature: &signature},
+	})
+	if err := client.handleMessage(ps, response, SQS); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case callback := <-cb:
+		if callback.response.RequestID != "resent" {
+			t.Fatal("expected response to be delivered as the resent request, got", callback.response.RequestID)
+		}
+	default:
+		t.Fatal("expected response for the original request to answer the resent one")
+	}
+	client.Lock()
+	_, pending := client.requestCallbacksByRequestID.Get("resent")
+	client.Unlock()
+	if pending {
+		t.Fatal("expected the resent request to no longer be pending")
+	}
+}
+
+func TestCertSignature(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool { return ec.GetCachedMe() != nil }, time.Now().Add(time.Second))
+
+	_, _, ca := kr.TestMe(t)
+	userKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userPK, err := ssh.NewPublicKey(userKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             userPK,
+		CertType:        ssh.UserCert,
+		KeyId:           "user",
+		ValidPrincipals: []string{"user"},
+		ValidAfter:      uint64(time.Now().Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	signature, err := ec.RequestCertSignature(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature.Format != ssh.KeyAlgoRSASHA512 {
+		t.Fatal("expected certificate signed with", ssh.KeyAlgoRSASHA512, "got", signature.Format)
+	}
+	checker := ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), ca.Marshal())
+		},
+	}
+	if err = checker.CheckCert("user", cert); err != nil {
+		t.Fatal(err)
+	}
+
+	transport.Lock()
+	sha1 := "ssh-rsa"
+	transport.SignatureAlgorithm = &sha1
+	transport.Unlock()
+	if _, err = ec.RequestCertSignature(cert); err == nil {
+		t.Fatal("expected signature with the wrong algorithm to be rejected")
+	}
+}
+
+func TestRotatePairing(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	previous := PairClient(t, ec)
+	defer ec.Stop()
+
+	pairingSecret, rotated, err := ec.RotatePairing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated {
+		t.Fatal("expected the phone to accept the rotation")
+	}
+	pairingSecrets := ec.(*EnclaveClient).getPairingSecrets()
+	if len(pairingSecrets) != 1 || !pairingSecrets[0].Equals(pairingSecret) || pairingSecrets[0].Equals(previous) {
+		t.Fatal("expected only the rotated pairing to remain")
+	}
+	if !ec.IsPaired() {
+		t.Fatal("expected rotated pairing to be paired")
+	}
+}
+
+func TestRotatePairingUnsupported(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, RotationUnsupported: true}
+	ec := NewTestEnclaveClientShortTimeouts(transport)
+	previous := PairClient(t, ec)
+	defer ec.Stop()
+
+	pairingSecret, rotated, err := ec.RotatePairing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated {
+		t.Fatal("expected fallback to re-pair")
+	}
+	if pairingSecret.IsPaired() {
+		t.Fatal("expected new pairing to wait for a scan")
+	}
+	if !previous.IsPaired() || len(ec.(*EnclaveClient).getPairingSecrets()) != 2 {
+		t.Fatal("expected previous pairing to remain until the new one completes")
+	}
+
+	//	scanning the new pairing replaces the previous one
+	if _, err := ec.RequestMe(kr.MeRequest{}, true); err != nil {
+		t.Fatal(err)
+	}
+	pairingSecrets := ec.(*EnclaveClient).getPairingSecrets()
+	if len(pairingSecrets) != 1 || !pairingSecrets[0].Equals(pairingSecret) {
+		t.Fatal("expected only the new pairing to remain")
+	}
+}
+
+func TestRequestMeOrCached(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, stale, err := ec.RequestMeOrCached()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me == nil || stale {
+		t.Fatal("expected a fresh profile")
+	}
+
+	transport.Lock()
+	transport.DoNotRespond = true
+	transport.Unlock()
+	start := time.Now()
+	me, stale, err = ec.RequestMeOrCached()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me == nil || !stale {
+		t.Fatal("expected the stale cached profile")
+	}
+	if time.Since(start) > ME_CACHED_FALLBACK_TIMEOUT+time.Second {
+		t.Fatal("waited for the phone instead of falling back to the cache")
+	}
+}
+
+func TestResponseTransport(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	request, err := kr.NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.ListRequest = &kr.ListRequest{}
+	response, err := ec.RequestGeneric(request, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Transport != SQS {
+		t.Fatal("expected response to arrive via", SQS, "got", response.Transport)
+	}
+}
+
+func TestSignatureBatch(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	otherFp := sha256.Sum256([]byte("other key"))
+	digestA := sha256.Sum256([]byte("a"))
+	digestB := sha256.Sum256([]byte("b"))
+	signResponses, err := ec.RequestSignatureBatch([]kr.SignRequest{
+		kr.SignRequest{PublicKeyFingerprint: fp, Data: digestA[:]},
+		kr.SignRequest{PublicKeyFingerprint: otherFp[:], Data: digestB[:]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signResponses) != 2 {
+		t.Fatal("expected a response per request, got", len(signResponses))
+	}
+	if signResponses[0].Signature == nil || signResponses[0].Error != nil {
+		t.Fatal("expected first request to be signed")
+	}
+	if signResponses[1].Signature != nil || signResponses[1].Error == nil {
+		t.Fatal("expected second request to fail on its own")
+	}
+	if entries := ec.AuditLog(); len(entries) != 2 || !entries[0].Succeeded || entries[1].Succeeded {
+		t.Fatal("expected an audit entry per batched request")
+	}
+}
+
+func TestSignatureBatchVerified(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, CorruptSignatures: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+
+	me, _, _ := kr.TestMe(t)
+	algorithm := ssh.KeyAlgoRSASHA256
+	session, err := kr.RandNBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signResponses, err := ec.RequestSignatureBatch([]kr.SignRequest{
+		kr.SignRequest{
+			PublicKeyFingerprint: me.PublicKeyFingerprint(),
+			Data: ssh.Marshal(signaturePayloadWithoutPubkey{
+				Session: session,
+				Type:    50,
+				User:    "git",
+				Service: "ssh-connection",
+				Method:  "publickey",
+				Sign:    true,
+				Algo:    []byte(algorithm),
+			}),
+			Algorithm: &algorithm,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signResponses) != 1 || signResponses[0].Signature != nil || signResponses[0].Error == nil || *signResponses[0].Error != ErrSignatureInvalid.Error() {
+		t.Fatal("expected the invalid signature replaced by its error, got", signResponses)
+	}
+	if entries := ec.AuditLog(); len(entries) != 1 || entries[0].Succeeded || entries[0].Error == nil {
+		t.Fatal("expected the invalid signature audited as failed, got", entries)
+	}
+}
+
+func TestSignatureBatchFallbackContinuesAfterError(t *testing.T) {
+	oldVersion := semver.MustParse("2.4.0")
+	transport := &kr.ResponseTransport{T: t, EnclaveVersion: &oldVersion}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+
+	me, _, _ := kr.TestMe(t)
+	otherFp := sha256.Sum256([]byte("other key"))
+	digestA := sha256.Sum256([]byte("a"))
+	digestB := sha256.Sum256([]byte("b"))
+	signResponses, err := ec.RequestSignatureBatch([]kr.SignRequest{
+		kr.SignRequest{PublicKeyFingerprint: otherFp[:], Data: digestA[:]},
+		kr.SignRequest{PublicKeyFingerprint: me.PublicKeyFingerprint(), Data: digestB[:]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signResponses) != 2 {
+		t.Fatal("expected a response per request, got", len(signResponses))
+	}
+	if signResponses[0].Signature != nil || signResponses[0].Error == nil {
+		t.Fatal("expected first request to fail on its own")
+	}
+	if signResponses[1].Signature == nil || signResponses[1].Error != nil {
+		t.Fatal("expected second request to be signed")
+	}
+}
+
+func TestRequestsAdaptToEnclaveVersion(t *testing.T) {
+	oldVersion := semver.MustParse("2.4.0")
+	transport := &kr.ResponseTransport{T: t, EnclaveVersion: &oldVersion}
+	ec := NewTestEnclaveClient(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+
+	me, _, _ := kr.TestMe(t)
+	digestA := sha256.Sum256([]byte("a"))
+	digestB := sha256.Sum256([]byte("b"))
+	requests := ec.Metrics().requestsByType["sign"]
+	signResponses, err := ec.RequestSignatureBatch([]kr.SignRequest{
+		kr.SignRequest{PublicKeyFingerprint: me.PublicKeyFingerprint(), Data: digestA[:]},
+		kr.SignRequest{PublicKeyFingerprint: me.PublicKeyFingerprint(), Data: digestB[:]},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signResponses) != 2 || signResponses[0].Signature == nil || signResponses[1].Signature == nil {
+		t.Fatal("expected both requests signed")
+	}
+	if ec.Metrics().requestsByType["sign"] != requests+2 {
+		t.Fatal("expected a sign request per signature for a phone predating batches")
+	}
+
+	ca, err := me.SSHPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{Key: ca, CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity}
+	if _, err := ec.RequestCertSignature(cert); err != kr.ErrUnsupportedByEnclave {
+		t.Fatal("expected ErrUnsupportedByEnclave, got", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClientShortTimeouts(transport,
+		WithRateLimit("sign", RateLimit{Rate: 0.01, Burst: 1}),
+		WithRateLimit("list", RateLimit{Rate: 20, Burst: 1, Queue: true}),
+	).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	me, _, _ := kr.TestMe(t)
+	fp := me.PublicKeyFingerprint()
+	for i, expected := range []error{nil, ErrRateLimited} {
+		digest := sha256.Sum256([]byte{byte(i)})
+		_, _, err := ec.RequestSignature(kr.SignRequest{PublicKeyFingerprint: fp, Data: digest[:]}, nil)
+		if err != expected {
+			t.Fatal("expected", expected, "got", err)
+		}
+	}
+	if ec.Metrics().RateLimited() != 1 {
+		t.Fatal("expected the rejected signature counted")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := ec.RequestList(); err != nil {
+			t.Fatal("expected queued list request to succeed, got", err)
+		}
+	}
+	if ec.Metrics().RateLimited() != 2 {
+		t.Fatal("expected the queued list request counted")
+	}
+}
+
+func TestRateLimitNeverRefilled(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClientShortTimeouts(transport,
+		WithRateLimit("list", RateLimit{Rate: 0, Burst: 1, Queue: true}),
+	).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	if _, err := ec.RequestList(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ec.RequestList(); err != ErrRateLimited {
+		t.Fatal("expected ErrRateLimited rather than waiting forever, got", err)
+	}
+}
+
+func TestRateLimitCanceled(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClientShortTimeouts(transport,
+		WithRateLimit("list", RateLimit{Rate: 0.01, Burst: 1, Queue: true}),
+	).(*EnclaveClient)
+	defer ec.Stop()
+
+	listRequest := kr.Request{ListRequest: &kr.ListRequest{}}
+	if err := ec.waitForRateLimit(context.Background(), listRequest); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := ec.waitForRateLimit(ctx, listRequest)
+	if _, ok := err.(*CanceledError); !ok || !errors.Is(err, context.Canceled) {
+		t.Fatal("expected a CanceledError, got", err)
+	}
+}
+
+func TestApprovalPendingEvent(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, ApprovalPending: true}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+	events := ec.Subscribe()
+	defer ec.Unsubscribe(events)
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("approve me"))
+	ctx := WithRequestID(context.Background(), "approval-pending")
+	signResponse, _, err := ec.RequestSignatureCtx(ctx, kr.SignRequest{PublicKeyFingerprint: me.PublicKeyFingerprint(), Data: digest[:]}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signResponse == nil || signResponse.Signature == nil {
+		t.Fatal("expected the final response after approval pending, got", signResponse)
+	}
+	for {
+		select {
+		case event := <-events:
+			if event.Type == PairingEventApprovalPending {
+				if event.RequestID != "approval-pending" {
+					t.Fatal("expected the pending request's ID, got", event.RequestID)
+				}
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected an approval pending event")
+		}
+	}
+}
+
+func TestSignatureVerification(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+
+	me, _, pk := kr.TestMe(t)
+	algorithm := ssh.KeyAlgoRSASHA256
+	newSignRequest := func() kr.SignRequest {
+		session, err := kr.RandNBytes(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kr.SignRequest{
+			PublicKeyFingerprint: me.PublicKeyFingerprint(),
+			Data: ssh.Marshal(signaturePayloadWithoutPubkey{
+				Session: session,
+				Type:    50,
+				User:    "git",
+				Service: "ssh-connection",
+				Method:  "publickey",
+				Sign:    true,
+				Algo:    []byte(algorithm),
+			}),
+			Algorithm: &algorithm,
+		}
+	}
+	signRequest := newSignRequest()
+	signResponse, _, err := ec.RequestSignature(signRequest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := signedPayload(signRequest, pk)
+	if err := pk.Verify(payload, &ssh.Signature{Format: algorithm, Blob: *signResponse.Signature}); err != nil {
+		t.Fatal("expected a valid signature over the restored payload:", err)
+	}
+
+	transport.Lock()
+	transport.CorruptSignatures = true
+	transport.Unlock()
+	signResponse, _, err = ec.RequestSignature(newSignRequest(), nil)
+	if err != ErrSignatureInvalid || signResponse != nil {
+		t.Fatal("expected ErrSignatureInvalid, got", err)
+	}
+	if entries := ec.AuditLog(); len(entries) != 2 || entries[1].Succeeded {
+		t.Fatal("expected the invalid signature audited as failed")
+	}
+}
+
+func TestEd25519Signatures(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t, Ed25519: true}
+	ec := NewTestEnclaveClient(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+
+	me, _, pk := kr.TestMeEd25519(t)
+	if cachedPK, err := ec.GetCachedMe().SSHPublicKey(); err != nil || cachedPK.Type() != ssh.KeyAlgoED25519 {
+		t.Fatal("expected the ed25519 profile cached, got", cachedPK, err)
+	}
+	keys, err := ec.AvailableKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Type != ssh.KeyAlgoED25519 {
+		t.Fatal("expected the ed25519 key listed, got", keys)
+	}
+
+	newSignRequest := func() kr.SignRequest {
+		session, err := kr.RandNBytes(32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return kr.SignRequest{
+			PublicKeyFingerprint: me.PublicKeyFingerprint(),
+			Data: ssh.Marshal(signaturePayloadWithoutPubkey{
+				Session: session,
+				Type:    50,
+				User:    "git",
+				Service: "ssh-connection",
+				Method:  "publickey",
+				Sign:    true,
+				Algo:    []byte(ssh.KeyAlgoED25519),
+			}),
+		}
+	}
+	signRequest := newSignRequest()
+	signResponse, _, err := ec.RequestSignature(signRequest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := signedPayload(signRequest, pk)
+	if err := pk.Verify(payload, &ssh.Signature{Format: ssh.KeyAlgoED25519, Blob: *signResponse.Signature}); err != nil {
+		t.Fatal("expected a valid ed25519 signature over the restored payload:", err)
+	}
+
+	transport.Lock()
+	transport.CorruptSignatures = true
+	transport.Unlock()
+	signResponse, _, err = ec.RequestSignature(newSignRequest(), nil)
+	if err != ErrSignatureInvalid || signResponse != nil {
+		t.Fatal("expected ErrSignatureInvalid, got", err)
+	}
+}
+
+//	The system clock, except that once armed the wall clock jumps back an
+//	hour after the next reading, as after NTP corrects a clock running fast
+type backwardsClock struct {
+	sync.Mutex
+	armed  bool
+	jumped bool
+}
+
+func (clock *backwardsClock) arm() {
+	clock.Lock()
+	defer clock.Unlock()
+	clock.armed = true
+}
+
+func (clock *backwardsClock) Now() time.Time {
+	clock.Lock()
+	defer clock.Unlock()
+	if clock.jumped {
+		return time.Now().Add(-time.Hour)
+	}
+	if clock.armed {
+		clock.jumped = true
+	}
+	return time.Now()
+}
+
+func (clock *backwardsClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func TestReceiveTimeoutSurvivesClockJump(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	clock := &backwardsClock{}
+	ec := UnpairedEnclaveClient(transport, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil, WithClock(clock)).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	transport.Lock()
+	transport.DoNotRespond = true
+	transport.Unlock()
+	clock.arm()
+
+	request, err := kr.NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.ListRequest = &kr.ListRequest{}
+	cb := make(chan *callbackT, 5)
+	done := make(chan error, 1)
+	go func() {
+		done <- ec.sendRequestAndReceiveResponses(context.Background(), ec.getPairingSecret(), request, cb, 200*time.Millisecond, false, false)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the wait to time out despite the clock jumping back")
+	}
+	if callback := <-cb; callback != nil {
+		t.Fatal("expected the request evicted")
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	policy := RetryPolicy{Jitter: 0.2}
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		d := policy.jitter(time.Second)
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatal("jittered duration out of range:", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("expected jittered durations to vary")
+	}
+	if d := (RetryPolicy{}).jitter(time.Second); d != time.Second {
+		t.Fatal("expected no jitter by default, got", d)
+	}
+}
+
+type recordingLogger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(args ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, fmt.Sprint(args...))
+}
+
+func (l *recordingLogger) Error(args ...interface{})   { l.record(args...) }
+func (l *recordingLogger) Warning(args ...interface{}) { l.record(args...) }
+func (l *recordingLogger) Notice(args ...interface{})  { l.record(args...) }
+func (l *recordingLogger) Info(args ...interface{})    { l.record(args...) }
+
+func TestSetLogger(t *testing.T) {
+	ec := NewTestEnclaveClient(&kr.NoopTransport{})
+	logger := &recordingLogger{}
+	ec.SetLogger(logger)
+	if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ec.Stop()
+
+	logger.Lock()
+	defer logger.Unlock()
+	if len(logger.lines) == 0 {
+		t.Fatal("expected client to log through the configured logger")
+	}
+}
+
+func TestSetLoggerWhileRunning(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	logger := &recordingLogger{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			ec.SetLogger(logger)
+			ec.SetLogger(nil)
+		}
+		ec.SetLogger(logger)
+	}()
+	testSignatureSuccess(t, ec)
+	<-done
+	testSignatureSuccess(t, ec)
+
+	logger.Lock()
+	defer logger.Unlock()
+	if len(logger.lines) == 0 {
+		t.Fatal("expected the client to log through the replacement logger")
+	}
+}
+
+func TestReadOnlyPairing(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ec.Stop()
+	ps, err := ec.Pair(kr.PairingOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ps.ReadOnly {
+		t.Fatal("expected read-only pairing")
+	}
+	if _, err := ec.RequestMe(kr.MeRequest{}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ec.RequestList(); err != nil {
+		t.Fatal(err)
+	}
+
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("data"))
+	_, _, err = ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+	}, nil)
+	if err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got", err)
+	}
+	if sent := transport.GetSentSignRequests(); sent != 0 {
+		t.Fatal("read-only pairing sent", sent, "sign requests")
+	}
+}
+
+//	A bluetooth driver whose writes block until released
+type hangingBluetoothDriver struct {
+	release chan struct{}
+}
+
+func (bt *hangingBluetoothDriver) AddService(uuid.UUID) (err error)    { return }
+func (bt *hangingBluetoothDriver) RemoveService(uuid.UUID) (err error) { return }
+func (bt *hangingBluetoothDriver) ReadChan() (readChan chan []byte, err error) {
+	readChan = make(chan []byte)
+	return
+}
+func (bt *hangingBluetoothDriver) Write(uuid.UUID, []byte) (err error) {
+	<-bt.release
+	return
+}
+func (bt *hangingBluetoothDriver) Stop() {}
+
+func TestBluetoothWriteTimeout(t *testing.T) {
+	ec := NewTestEnclaveClient(&kr.NoopTransport{}).(*EnclaveClient)
+	ps, err := kr.GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &hangingBluetoothDriver{release: make(chan struct{})}
+	defer close(bt.release)
+	ec.Lock()
+	ec.bt = bt
+	ec.btWriteTimeout = 10 * time.Millisecond
+	ec.Unlock()
+
+	for i := 0; i < MAX_PENDING_BLUETOOTH_WRITES; i++ {
+		if err := ec.writeBluetooth(ps, []byte("ciphertext")); err != ErrBluetoothWriteTimeout {
+			t.Fatal("expected ErrBluetoothWriteTimeout, got", err)
+		}
+	}
+	if err := ec.writeBluetooth(ps, []byte("ciphertext")); err != ErrTooManyBluetoothWrites {
+		t.Fatal("expected ErrTooManyBluetoothWrites, got", err)
+	}
+}
+
+func TestFraming(t *testing.T) {
+	payload := []byte{kr.HEADER_CIPHERTEXT, 1, 2, 3}
+	frame := encodeFrame(payload)
+	decoded, err := decodeFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, payload) {
+		t.Fatal("frame payload mismatch")
+	}
+
+	if _, err := decodeFrame(frame[:len(frame)-1]); err != ErrPartialFrame {
+		t.Fatal("expected ErrPartialFrame for truncated frame, got", err)
+	}
+	if _, err := decodeFrame(append(frame, 4)); err != ErrPartialFrame {
+		t.Fatal("expected ErrPartialFrame for extended frame, got", err)
+	}
+	if _, err := decodeFrame(frame[:3]); err != ErrPartialFrame {
+		t.Fatal("expected ErrPartialFrame for truncated header, got", err)
+	}
+
+	//	phones predating framing send bare ciphertexts
+	decoded, err = decodeFrame(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, payload) {
+		t.Fatal("unframed data altered")
+	}
+}
+
+//	A bluetooth driver that records its services and writes
+type recordingBluetoothDriver struct {
+	sync.Mutex
+	services []uuid.UUID
+	writes   chan []byte
+}
+
+func (bt *recordingBluetoothDriver) AddService(serviceUUID uuid.UUID) (err error) {
+	bt.Lock()
+	defer bt.Unlock()
+	bt.services = append(bt.services, serviceUUID)
+	return
+}
+func (bt *recordingBluetoothDriver) RemoveService(uuid.UUID) (err error) { return }
+func (bt *recordingBluetoothDriver) ReadChan() (readChan chan []byte, err error) {
+	readChan = make(chan []byte)
+	return
+}
+func (bt *recordingBluetoothDriver) Write(_ uuid.UUID, data []byte) (err error) {
+	bt.writes <- data
+	return
+}
+func (bt *recordingBluetoothDriver) Stop() {}
+
+//	A bluetooth driver whose writes always fail
+type failingBluetoothDriver struct {
+	recordingBluetoothDriver
+}
+
+func (bt *failingBluetoothDriver) Write(uuid.UUID, []byte) (err error) {
+	return errors.New("adapter wedged")
+}
+
+func TestBluetoothWatchdogRestartsDriver(t *testing.T) {
+	var drivers int32
+	ec := UnpairedEnclaveClient(&kr.ImmediatePairTransport{}, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil,
+		WithBluetoothDriver(func() (BluetoothDriverI, error) {
+			atomic.AddInt32(&drivers, 1)
+			return &failingBluetoothDriver{}, nil
+		}),
+	).(*EnclaveClient)
+	ps := PairClient(t, ec)
+	defer ec.Stop()
+
+	for i := 0; i < BLUETOOTH_WATCHDOG_FAILURES; i++ {
+		if err := ec.writeBluetooth(ps, []byte("ciphertext")); err == nil {
+			t.Fatal("expected write to fail")
+		}
+	}
+	if _, err := ec.BluetoothStatus(); err == nil || !strings.Contains(err.Error(), ErrBluetoothUnhealthy.Error()) {
+		t.Fatal("expected unhealthy bluetooth status, got", err)
+	}
+	kr.TrueBefore(t, func() bool {
+		return atomic.LoadInt32(&drivers) == 2
+	}, time.Now().Add(MIN_BLUETOOTH_RESTART_BACKOFF+time.Second))
+}
+
+func TestBluetoothRestartBackoffUsesClientClock(t *testing.T) {
+	var drivers int32
+	clock := &fakeClock{now: time.Now()}
+	ec := UnpairedEnclaveClient(&kr.ImmediatePairTransport{}, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil,
+		WithClock(clock),
+		WithBluetoothDriver(func() (BluetoothDriverI, error) {
+			atomic.AddInt32(&drivers, 1)
+			return &failingBluetoothDriver{}, nil
+		}),
+	).(*EnclaveClient)
+	ps := PairClient(t, ec)
+	defer ec.Stop()
+
+	for i := 0; i < BLUETOOTH_WATCHDOG_FAILURES; i++ {
+		ec.writeBluetooth(ps, []byte("ciphertext"))
+	}
+	backoffPending := func() bool {
+		clock.Lock()
+		defer clock.Unlock()
+		for _, timer := range clock.timers {
+			if timer.at.Equal(clock.now.Add(MIN_BLUETOOTH_RESTART_BACKOFF)) {
+				return true
+			}
+		}
+		return false
+	}
+	kr.TrueBefore(t, backoffPending, time.Now().Add(time.Second))
+	if atomic.LoadInt32(&drivers) != 1 {
+		t.Fatal("expected no restart before the client's clock passes the backoff")
+	}
+
+	clock.Advance(MIN_BLUETOOTH_RESTART_BACKOFF)
+	kr.TrueBefore(t, func() bool {
+		return atomic.LoadInt32(&drivers) == 2
+	}, time.Now().Add(time.Second))
+}
+
+func TestBluetoothFramedBySupportedVersion(t *testing.T) {
+	ec := NewTestEnclaveClient(&kr.NoopTransport{}).(*EnclaveClient)
+	ps, err := kr.GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &recordingBluetoothDriver{writes: make(chan []byte, 1)}
+	ec.Lock()
+	ec.bt = bt
+	ec.Unlock()
+	transport := &bluetoothTransport{ec}
+	ciphertext := []byte{kr.HEADER_CIPHERTEXT, 1, 2, 3}
+
+	if _, err := transport.Send(ps, nil, ciphertext, false, kr.DiscardLogger); err != nil {
+		t.Fatal(err)
+	}
+	if written := <-bt.writes; !bytes.Equal(written, ciphertext) {
+		t.Fatal("expected unframed write to phone of unknown version")
+	}
+
+	ec.Lock()
+	ec.setEnclaveVersion(ps, kr.ENCLAVE_VERSION_SUPPORTS_FRAMED_BLUETOOTH)
+	ec.Unlock()
+	if _, err := transport.Send(ps, nil, ciphertext, false, kr.DiscardLogger); err != nil {
+		t.Fatal(err)
+	}
+	if written := <-bt.writes; !bytes.Equal(written, encodeFrame(ciphertext)) {
+		t.Fatal("expected framed write to phone supporting framing")
+	}
+}
+
+func TestInjectedBluetoothDriver(t *testing.T) {
+	bt := &recordingBluetoothDriver{writes: make(chan []byte, 16)}
+	ec := UnpairedEnclaveClient(&kr.ImmediatePairTransport{}, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil,
+		WithBluetoothDriver(func() (BluetoothDriverI, error) {
+			return bt, nil
+		}),
+	)
+	ps := PairClient(t, ec)
+	defer ec.Stop()
+
+	if status, _ := ec.BluetoothStatus(); status != BluetoothScanning {
+		t.Fatal("expected injected driver to be scanning, got", status)
+	}
+	serviceUUID, err := ps.DeriveUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt.Lock()
+	defer bt.Unlock()
+	if len(bt.services) != 1 || bt.services[0] != serviceUUID {
+		t.Fatal("expected pairing service added to injected driver, got", bt.services)
+	}
+}
+
+func TestUSBDetectedAfterStart(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	usb := &recordingBluetoothDriver{writes: make(chan []byte, 16)}
+	var attached int32
+	ec := UnpairedEnclaveClient(&kr.ImmediatePairTransport{}, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil,
+		WithClock(clock),
+		WithUSBDriver(func() (BluetoothDriverI, error) {
+			if atomic.LoadInt32(&attached) == 0 {
+				return nil, ErrNoUSBDevice
+			}
+			return usb, nil
+		}),
+	).(*EnclaveClient)
+	ps := PairClient(t, ec)
+	defer ec.Stop()
+	transport := &usbTransport{ec}
+	if transport.Connected() {
+		t.Fatal("expected no phone on USB")
+	}
+
+	//	plugged in after Start
+	atomic.StoreInt32(&attached, 1)
+	kr.TrueBefore(t, func() bool {
+		clock.Advance(USB_DETECT_INTERVAL)
+		return transport.Connected()
+	}, time.Now().Add(time.Second))
+	serviceUUID, err := ps.DeriveUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	usb.Lock()
+	services := usb.services
+	usb.Unlock()
+	if len(services) != 1 || services[0] != serviceUUID {
+		t.Fatal("expected pairing service added to the USB driver, got", services)
+	}
+
+	//	a write to adb does not mean the app received it
+	delivered, err := transport.Send(ps, nil, []byte("ciphertext"), false, kr.DiscardLogger)
+	if err != nil || delivered {
+		t.Fatal("expected the other transports still used, got", delivered, err)
+	}
+}
+
+func TestBluetoothDisabledByEnv(t *testing.T) {
+	os.Setenv(DISABLE_BLUETOOTH_ENV, "1")
+	defer os.Unsetenv(DISABLE_BLUETOOTH_ENV)
+	ec := UnpairedEnclaveClient(&kr.ResponseTransport{T: t}, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil,
+		WithBluetoothDriver(func() (BluetoothDriverI, error) {
+			t.Fatal("expected bluetooth driver not to be created")
+			return nil, nil
+		}),
+	)
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	if status, err := ec.BluetoothStatus(); status != BluetoothDisabled || err != nil {
+		t.Fatal("expected bluetooth disabled, got", status, err)
+	}
+	if _, err := ec.RequestList(); err != nil {
+		t.Fatal("expected pairing to work over SQS, got", err)
+	}
+}
+
+func TestLocalTransportsOnly(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport).(*EnclaveClient)
+	PairClient(t, ec)
+	defer ec.Stop()
+	kr.TrueBefore(t, func() bool {
+		return ec.GetCachedMe() != nil
+	}, time.Now().Add(time.Second))
+	//	paired over SQS, now limited as by WithLocalTransportsOnly
+	ec.localOnly = true
+
+	reads := transport.GetReads()
+	me, _, _ := kr.TestMe(t)
+	digest := sha256.Sum256([]byte("data"))
+	start := time.Now()
+	_, _, err := ec.RequestSignature(kr.SignRequest{
+		PublicKeyFingerprint: me.PublicKeyFingerprint(),
+		Data:                 digest[:],
+	}, nil)
+	if err != ErrNoLocalTransport {
+		t.Fatal("expected ErrNoLocalTransport, got", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("expected the request to fail fast")
+	}
+	if sent := transport.GetSentSignRequests(); sent != 0 {
+		t.Fatal("expected nothing sent over SNS/SQS, got", sent)
+	}
+	if transport.GetReads() > reads+1 {
+		t.Fatal("expected SQS not polled for the request")
+	}
+}
+
+func TestSNSDisabledByEnv(t *testing.T) {
+	os.Setenv(DISABLE_SNS_ENV, "1")
+	defer os.Unsetenv(DISABLE_SNS_ENV)
+	ec := UnpairedEnclaveClient(&kr.ResponseTransport{T: t}, &kr.MemoryPersister{}, nil, nil, nil).(*EnclaveClient)
+	if !ec.localOnly {
+		t.Fatal("expected local transports only")
+	}
+}
+
+func TestServiceUUIDFailureFallsBackToSQS(t *testing.T) {
+	bt := &recordingBluetoothDriver{writes: make(chan []byte, 16)}
+	ec := UnpairedEnclaveClient(&kr.ResponseTransport{T: t}, &kr.MemoryPersister{}, nil, kr.SetupLogging("test", logging.INFO, false), nil,
+		WithBluetoothDriver(func() (BluetoothDriverI, error) {
+			return bt, nil
+		}),
+	).(*EnclaveClient)
+	ec.deriveServiceUUID = func(*kr.PairingSecret) (uuid.UUID, error) {
+		return uuid.UUID{}, errors.New("bad workstation key")
+	}
+	PairClient(t, ec)
+	defer ec.Stop()
+
+	status, err := ec.BluetoothStatus()
+	if status != BluetoothError || err == nil || !strings.Contains(err.Error(), "bad workstation key") {
+		t.Fatal("expected bluetooth error with the reason, got", status, err)
+	}
+	bt.Lock()
+	services := len(bt.services)
+	bt.Unlock()
+	if services != 0 {
+		t.Fatal("expected no bluetooth service without a service UUID")
+	}
+	if _, err := ec.RequestList(); err != nil {
+		t.Fatal("expected pairing to keep working over SQS, got", err)
+	}
+}
+
+func TestParseADBDevices(t *testing.T) {
+	output := "List of devices attached\nemulator-5554\tdevice\n0123456789ABCDEF\tunauthorized\nHT4CTJT00106\tdevice\n\n"
+	serials := parseADBDevices(output)
+	if len(serials) != 2 || serials[0] != "emulator-5554" || serials[1] != "HT4CTJT00106" {
+		t.Fatal("unexpected serials", serials)
+	}
+}
+
+func TestUSBDriver(t *testing.T) {
+	local, phone := net.Pipe()
+	driver := newUSBDriver(local)
+	defer driver.Stop()
+	subscribed, unsubscribed := uuid.NewV4(), uuid.NewV4()
+	driver.AddService(subscribed)
+	readChan, _ := driver.ReadChan()
+
+	go phone.Write(append(unsubscribed.Bytes(), encodeFrame([]byte("ignored"))...))
+	go phone.Write(append(subscribed.Bytes(), encodeFrame([]byte("ciphertext"))...))
+	select {
+	case payload := <-readChan:
+		if string(payload) != "ciphertext" {
+			t.Fatal("expected payload for subscribed service, got", string(payload))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out reading from USB")
+	}
+
+	written := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := io.ReadAtLeast(phone, buf, uuidLen+FRAME_HEADER_LEN+len("reply"))
+		written <- buf[:n]
+	}()
+	if err := driver.Write(subscribed, []byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(<-written, append(subscribed.Bytes(), encodeFrame([]byte("reply"))...)) {
+		t.Fatal("unexpected USB write")
+	}
+
+	phone.Close()
+	select {
+	case _, ok := <-readChan:
+		if ok {
+			t.Fatal("expected read channel to close on disconnect")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read channel not closed on disconnect")
+	}
+}
+
+func TestPairAndWait(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ec.Stop()
+
+	var shown *kr.PairingSecret
+	me, err := ec.PairAndWait(context.Background(), kr.PairingOptions{}, func(ps *kr.PairingSecret) {
+		shown = ps
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMe, _, _ := kr.TestMe(t)
+	if me == nil || !me.Equal(testMe) {
+		t.Fatal("unexpected profile")
+	}
+	if shown == nil || !shown.IsPaired() {
+		t.Fatal("expected the pairing to be shown and completed")
+	}
+}
+
+func TestWaitForMe(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	ec := NewTestEnclaveClient(transport)
+	defer ec.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := ec.WaitForMe(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the wait to be canceled, got", err)
+	}
+
+	waited := make(chan *kr.Profile, 1)
+	go func() {
+		me, err := ec.WaitForMe(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		waited <- me
+	}()
+	PairClient(t, ec)
+	testMe, _, _ := kr.TestMe(t)
+	select {
+	case me := <-waited:
+		if me == nil || !me.Equal(testMe) {
+			t.Fatal("unexpected profile")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected WaitForMe to return once the phone responded")
+	}
+
+	if me, err := ec.WaitForMe(context.Background()); err != nil || me == nil {
+		t.Fatal("expected the cached profile, got", err)
+	}
+}
+
+func TestCancelPairing(t *testing.T) {
+	transport := &kr.ResponseTransport{T: t}
+	persister := &kr.MemoryPersister{}
+	ec := UnpairedEnclaveClient(transport, persister, nil, kr.SetupLogging("test", logging.INFO, false), nil)
+	if err := ec.CancelPairing(); err != ErrNoPairingInProgress {
+		t.Fatal("expected ErrNoPairingInProgress, got", err)
+	}
+	firstPairing := PairClient(t, ec)
+	defer ec.Stop()
+
+	if _, err := ec.Pair(kr.PairingOptions{Add: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ec.CancelPairing(); err != nil {
+		t.Fatal(err)
+	}
+	pairingSecrets := ec.(*EnclaveClient).getPairingSecrets()
+	if len(pairingSecrets) != 1 || !pairingSecrets[0].Equals(firstPairing) || !firstPairing.IsPaired() {
+		t.Fatal("expected only the completed pairing to remain")
+	}
+	pairings, err := persister.LoadPairings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairings) != 1 {
+		t.Fatal("expected one persisted pairing, got", len(pairings))
+	}
+	if err := ec.CancelPairing(); err != ErrNoPairingInProgress {
+		t.Fatal("expected ErrNoPairingInProgress, got", err)
+	}
+	testSignatureSuccess(t, ec)
+}
+
+func TestPairAndWaitCanceled(t *testing.T) {
+	//	the phone never scans the pairing
+	ec := NewTestEnclaveClientShortTimeouts(&kr.NoopTransport{})
+	if err := ec.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ec.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := ec.PairAndWait(ctx, kr.PairingOptions{}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the wait to be canceled, got", err)
+	}
+	if len(ec.(*EnclaveClient).getPairingSecrets()) != 0 {
+		t.Fatal("expected the incomplete pairing to be abandoned")
+	}
+}
@@ -21,7 +21,7 @@ func NewTestEnclaveClient(transport kr.Transport) EnclaveClientI {
 	)
 }
 
-func NewTestEnclaveClientShortTimeouts(transport kr.Transport) EnclaveClientI {
+func NewTestEnclaveClientShortTimeouts(transport kr.Transport, opts ...EnclaveClientOption) EnclaveClientI {
 	shortTimeouts := kr.Timeouts{
 		Me: kr.TimeoutPhases{
 			Alert: 800 * time.Millisecond,
@@ -35,6 +35,10 @@ func NewTestEnclaveClientShortTimeouts(transport kr.Transport) EnclaveClientI {
 			Alert: 800 * time.Millisecond,
 			Fail:  1600 * time.Millisecond,
 		},
+		List: kr.TimeoutPhases{
+			Alert: 800 * time.Millisecond,
+			Fail:  1600 * time.Millisecond,
+		},
 		ACKDelay: kr.SHORT_ACK_DELAY,
 	}
 
@@ -44,6 +48,7 @@ func NewTestEnclaveClientShortTimeouts(transport kr.Transport) EnclaveClientI {
 		&shortTimeouts,
 		kr.SetupLogging("test", logging.INFO, false),
 		nil,
+		opts...,
 	)
 	return ec
 }
@@ -0,0 +1,116 @@
+package krd
+
+import (
+	"sync"
+
+	"github.com/kryptco/kr"
+)
+
+type PairingEventType int
+
+const (
+	PairingEventPaired PairingEventType = iota
+	PairingEventKeyUnwrapped
+	PairingEventMeUpdated
+	PairingEventUnpaired
+	PairingEventPushEndpointUpdated
+	PairingEventApprovalPending
+)
+
+func (t PairingEventType) String() string {
+	switch t {
+	case PairingEventPaired:
+		return "paired"
+	case PairingEventKeyUnwrapped:
+		return "key_unwrapped"
+	case PairingEventMeUpdated:
+		return "me_updated"
+	case PairingEventUnpaired:
+		return "unpaired"
+	case PairingEventPushEndpointUpdated:
+		return "push_endpoint_updated"
+	case PairingEventApprovalPending:
+		return "approval_pending"
+	}
+	return "unknown"
+}
+
+type PairingEvent struct {
+	Type PairingEventType
+	//	Set for PairingEventMeUpdated
+	Profile *kr.Profile
+	//	Set for PairingEventPushEndpointUpdated, when the phone registers for
+	//	push notifications or its endpoint changes
+	SNSEndpointARN *string
+	//	Set for PairingEventApprovalPending, when the phone is waiting for the
+	//	user to approve this request
+	RequestID string
+}
+
+const pairingEventBufferSize = 16
+
+//	Fans pairing events out to subscribers. Events are dropped for
+//	subscribers that fall behind rather than blocking the enclave client.
+type pairingEvents struct {
+	sync.Mutex
+	subscribers []chan PairingEvent
+	closed      bool
+}
+
+//	Once closed, subscribers get an already closed channel
+func (pe *pairingEvents) subscribe() <-chan PairingEvent {
+	pe.Lock()
+	defer pe.Unlock()
+	ch := make(chan PairingEvent, pairingEventBufferSize)
+	if pe.closed {
+		close(ch)
+		return ch
+	}
+	pe.subscribers = append(pe.subscribers, ch)
+	return ch
+}
+
+//	Close every subscription
+func (pe *pairingEvents) close() {
+	pe.Lock()
+	defer pe.Unlock()
+	for _, subscriber := range pe.subscribers {
+		close(subscriber)
+	}
+	pe.subscribers = nil
+	pe.closed = true
+}
+
+func (pe *pairingEvents) unsubscribe(ch <-chan PairingEvent) {
+	pe.Lock()
+	defer pe.Unlock()
+	for i, subscriber := range pe.subscribers {
+		if (<-chan PairingEvent)(subscriber) == ch {
+			pe.subscribers = append(pe.subscribers[:i], pe.subscribers[i+1:]...)
+			close(subscriber)
+			return
+		}
+	}
+}
+
+func (pe *pairingEvents) publish(event PairingEvent) {
+	pe.Lock()
+	defer pe.Unlock()
+	for _, subscriber := range pe.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+//	Receive pairing lifecycle events. The channel is buffered; events are
+//	dropped if the subscriber falls behind.
+func (ec *EnclaveClient) Subscribe() <-chan PairingEvent {
+	return ec.events.subscribe()
+}
+
+//	Stop receiving events on a channel returned by Subscribe and close it.
+func (ec *EnclaveClient) Unsubscribe(ch <-chan PairingEvent) {
+	ec.events.unsubscribe(ch)
+}
@@ -0,0 +1,39 @@
+package krd
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+//	Begins a length-prefixed frame. Distinct from the header bytes that begin
+//	a bare ciphertext, so frames and unframed ciphertexts can share a channel.
+const FRAME_HEADER byte = 0xF0
+
+const FRAME_HEADER_LEN = 5
+
+var ErrPartialFrame = errors.New("frame length does not match its contents")
+
+//	Prefix payload with FRAME_HEADER and its length as a big-endian uint32
+func encodeFrame(payload []byte) (frame []byte) {
+	frame = make([]byte, FRAME_HEADER_LEN, FRAME_HEADER_LEN+len(payload))
+	frame[0] = FRAME_HEADER
+	binary.BigEndian.PutUint32(frame[1:FRAME_HEADER_LEN], uint32(len(payload)))
+	frame = append(frame, payload...)
+	return
+}
+
+//	Return the payload of a frame, or ErrPartialFrame if data was truncated
+//	or extended in transit. Data not starting with FRAME_HEADER comes from a
+//	phone that predates framing and is returned as is.
+func decodeFrame(data []byte) (payload []byte, err error) {
+	if len(data) == 0 || data[0] != FRAME_HEADER {
+		payload = data
+		return
+	}
+	if len(data) < FRAME_HEADER_LEN || uint64(len(data)-FRAME_HEADER_LEN) != uint64(binary.BigEndian.Uint32(data[1:FRAME_HEADER_LEN])) {
+		err = ErrPartialFrame
+		return
+	}
+	payload = data[FRAME_HEADER_LEN:]
+	return
+}
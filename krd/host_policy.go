@@ -0,0 +1,83 @@
+package krd
+
+import (
+	"errors"
+	"path"
+
+	"github.com/kryptco/kr"
+)
+
+var ErrPolicyDenied = errors.New("Signature request denied by host policy")
+
+//	Hosts the workstation may request SSH signatures for, checked before a
+//	request reaches the phone. Entries are host names or path.Match patterns
+//	such as *.example.com. Deny takes precedence; a non-empty Allow denies
+//	every host it does not match, including requests without a host.
+type HostPolicy struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+//	Whether the policy permits signing for a connection to hostNames, the
+//	names and addresses of a single host
+func (policy HostPolicy) Allows(hostNames []string) bool {
+	for _, host := range hostNames {
+		if matchesAny(policy.Deny, host) {
+			return false
+		}
+	}
+	if len(policy.Allow) == 0 {
+		return true
+	}
+	for _, host := range hostNames {
+		if matchesAny(policy.Allow, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy HostPolicy) validate() (err error) {
+	for _, pattern := range append(append([]string{}, policy.Allow...), policy.Deny...) {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func matchesAny(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+//	Replace the host policy, which may be changed at any time. The zero
+//	HostPolicy, the default, allows every host.
+func (ec *EnclaveClient) SetHostPolicy(policy HostPolicy) (err error) {
+	if err = policy.validate(); err != nil {
+		return
+	}
+	ec.Lock()
+	defer ec.Unlock()
+	ec.hostPolicy = policy
+	return
+}
+
+func (ec *EnclaveClient) checkHostPolicy(signRequest kr.SignRequest) (err error) {
+	ec.Lock()
+	policy := ec.hostPolicy
+	ec.Unlock()
+	var hostNames []string
+	if signRequest.HostAuth != nil {
+		hostNames = signRequest.HostAuth.HostNames
+	}
+	if !policy.Allows(hostNames) {
+		ec.log.Warning("signature request for", hostNames, "denied by host policy")
+		err = ErrPolicyDenied
+	}
+	return
+}
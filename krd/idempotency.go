@@ -0,0 +1,36 @@
+package krd
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/kryptco/kr"
+)
+
+//	Key shared by requests that ask the phone for the same approval, derived
+//	from their contents so that a request resent under a new RequestID, e.g.
+//	by ssh retrying authentication, carries the same key. Empty for requests
+//	that do not prompt.
+func idempotencyKey(request kr.Request) (key string) {
+	var approval interface{}
+	switch {
+	case request.SignRequest != nil:
+		approval = request.SignRequest
+	case request.GitSignRequest != nil:
+		approval = request.GitSignRequest
+	case request.SignBatchRequest != nil:
+		approval = request.SignBatchRequest
+	case request.CertSignRequest != nil:
+		approval = request.CertSignRequest
+	default:
+		return
+	}
+	approvalJson, err := json.Marshal(approval)
+	if err != nil {
+		return
+	}
+	digest := sha256.Sum256(approvalJson)
+	key = base64.RawURLEncoding.EncodeToString(digest[:])
+	return
+}
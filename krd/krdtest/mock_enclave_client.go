@@ -0,0 +1,720 @@
+//	Package krdtest provides an in-memory krd.EnclaveClientI for testing code
+//	that talks to an enclave client without Bluetooth or SQS/SNS.
+package krdtest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/kryptco/kr"
+	"github.com/kryptco/kr/krd"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ krd.EnclaveClientI = &MockEnclaveClient{}
+
+//	Answers requests with canned responses and records every request it
+//	receives. Set Err (e.g. to krd.ErrTimeout) to make every request fail.
+//	A nil canned response is answered with krd.ErrTimeout.
+type MockEnclaveClient struct {
+	sync.Mutex
+	MeResponse      *kr.MeResponse
+	SignResponse    *kr.SignResponse
+	GitSignResponse *kr.GitSignResponse
+	ListResponse    *kr.ListResponse
+	HostsResponse   *kr.HostsResponse
+	CertSignature   *ssh.Signature
+	Err             error
+	Version         semver.Version
+
+	paired          bool
+	pairingSecret   *kr.PairingSecret
+	cachedMe        *kr.Profile
+	requests        []kr.Request
+	timeouts        kr.Timeouts
+	retryPolicy     krd.RetryPolicy
+	bluetoothStatus krd.BluetoothStatus
+	subscribers     []chan krd.PairingEvent
+	auditLog        []krd.SignatureAuditEntry
+	metrics         *krd.Metrics
+	hostPolicy      krd.HostPolicy
+	lastFailed      *kr.Request
+}
+
+//	A mock that considers itself paired and answers MeRequests with me.
+func NewMockEnclaveClient(me kr.Profile) *MockEnclaveClient {
+	return &MockEnclaveClient{
+		MeResponse: &kr.MeResponse{Me: me},
+		Version:    kr.CURRENT_VERSION,
+		paired:     true,
+		timeouts:   kr.DefaultTimeouts(),
+		metrics:    krd.NewMetrics(),
+	}
+}
+
+//	Requests received so far, in order.
+func (mock *MockEnclaveClient) Requests() (requests []kr.Request) {
+	mock.Lock()
+	defer mock.Unlock()
+	requests = append(requests, mock.requests...)
+	return
+}
+
+//	The last timeouts passed to SetRequestTimeouts.
+func (mock *MockEnclaveClient) Timeouts() kr.Timeouts {
+	mock.Lock()
+	defer mock.Unlock()
+	return mock.timeouts
+}
+
+//	The last policy passed to SetRetryPolicy.
+func (mock *MockEnclaveClient) RetryPolicy() krd.RetryPolicy {
+	mock.Lock()
+	defer mock.Unlock()
+	return mock.retryPolicy
+}
+
+func (mock *MockEnclaveClient) SetBluetoothStatus(status krd.BluetoothStatus) {
+	mock.Lock()
+	defer mock.Unlock()
+	mock.bluetoothStatus = status
+}
+
+//	Records request and returns the error it should fail with, if any.
+func (mock *MockEnclaveClient) record(request kr.Request) (err error) {
+	mock.Lock()
+	defer mock.Unlock()
+	request.Prepare()
+	mock.requests = append(mock.requests, request)
+	mock.lastFailed = nil
+	if mock.Err != nil {
+		err = mock.Err
+		mock.lastFailed = &request
+		return
+	}
+	if !mock.paired {
+		err = krd.ErrNotPaired
+		return
+	}
+	return
+}
+
+func (mock *MockEnclaveClient) publish(event krd.PairingEvent) {
+	for _, subscriber := range mock.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+func (mock *MockEnclaveClient) Setup(ps *kr.PairingSecret) (err error) {
+	return
+}
+
+func (mock *MockEnclaveClient) PushAlert(ps *kr.PairingSecret, alertText string, message []byte) (err error) {
+	return
+}
+
+func (mock *MockEnclaveClient) SendMessage(ps *kr.PairingSecret, message []byte) (err error) {
+	return
+}
+
+func (mock *MockEnclaveClient) Read(notifier *kr.Notifier, ps *kr.PairingSecret) (ciphertexts [][]byte, err error) {
+	return
+}
+
+func (mock *MockEnclaveClient) Pair(opts kr.PairingOptions) (pairing *kr.PairingSecret, err error) {
+	pairing, err = kr.GeneratePairingSecret(opts.WorkstationName)
+	if err != nil {
+		return
+	}
+	//	stands in for the key of a phone scanning the pairing
+	enclavePublicKey, err := kr.RandNBytes(32)
+	if err != nil {
+		return
+	}
+	pairing.EnclavePublicKey = &enclavePublicKey
+	mock.Lock()
+	defer mock.Unlock()
+	mock.pairingSecret = pairing
+	mock.paired = true
+	mock.publish(krd.PairingEvent{Type: krd.PairingEventPaired})
+	return
+}
+
+//	Pairs immediately and returns the MeResponse profile
+func (mock *MockEnclaveClient) PairAndWait(ctx context.Context, opts kr.PairingOptions, onPairingSecret func(*kr.PairingSecret)) (me *kr.Profile, err error) {
+	pairing, err := mock.Pair(opts)
+	if err != nil {
+		return
+	}
+	if onPairingSecret != nil {
+		onPairingSecret(pairing)
+	}
+	meResponse, err := mock.RequestMeCtx(ctx, kr.MeRequest{}, true)
+	if err != nil {
+		return
+	}
+	profile := meResponse.Me
+	me = &profile
+	return
+}
+
+func (mock *MockEnclaveClient) IsPaired() bool {
+	mock.Lock()
+	defer mock.Unlock()
+	return mock.paired
+}
+
+func (mock *MockEnclaveClient) Unpair() {
+	mock.Lock()
+	defer mock.Unlock()
+	mock.paired = false
+	mock.pairingSecret = nil
+	mock.cachedMe = nil
+	mock.publish(krd.PairingEvent{Type: krd.PairingEventUnpaired})
+}
+
+//	The mock's pairings complete immediately, leaving none to cancel
+func (mock *MockEnclaveClient) CancelPairing() error {
+	return krd.ErrNoPairingInProgress
+}
+
+//	The mock has a single device, identified by its pairing's UUID
+func (mock *MockEnclaveClient) PairedDevices() (devices []kr.PairedDevice) {
+	mock.Lock()
+	defer mock.Unlock()
+	if mock.pairingSecret == nil {
+		return
+	}
+	if id, err := mock.pairingSecret.DeriveUUID(); err == nil {
+		devices = append(devices, kr.PairedDevice{ID: id.String(), Paired: mock.paired})
+	}
+	return
+}
+
+func (mock *MockEnclaveClient) PairedSince() (since time.Time, ok bool) {
+	mock.Lock()
+	pairingSecret, paired := mock.pairingSecret, mock.paired
+	mock.Unlock()
+	if pairingSecret == nil || !paired {
+		return
+	}
+	return pairingSecret.PairedSince()
+}
+
+func (mock *MockEnclaveClient) PairingFingerprint() (fingerprint string, err error) {
+	mock.Lock()
+	pairingSecret, paired := mock.pairingSecret, mock.paired
+	mock.Unlock()
+	if pairingSecret == nil || !paired {
+		err = krd.ErrNotPaired
+		return
+	}
+	return pairingSecret.Fingerprint()
+}
+
+func (mock *MockEnclaveClient) UnpairDevice(id string) (err error) {
+	for _, device := range mock.PairedDevices() {
+		if device.ID == id {
+			mock.Unpair()
+			return
+		}
+	}
+	err = krd.ErrDeviceNotFound
+	return
+}
+
+func (mock *MockEnclaveClient) Start() (err error) {
+	return
+}
+
+func (mock *MockEnclaveClient) Stop() (err error) {
+	return
+}
+
+//	Makes every later request fail with krd.ErrClosed
+func (mock *MockEnclaveClient) Close() (err error) {
+	mock.Lock()
+	defer mock.Unlock()
+	mock.Err = krd.ErrClosed
+	return
+}
+
+func (mock *MockEnclaveClient) RequestMe(meRequest kr.MeRequest, isPairing bool) (*kr.MeResponse, error) {
+	return mock.RequestMeCtx(context.Background(), meRequest, isPairing)
+}
+
+func (mock *MockEnclaveClient) RequestMeCtx(ctx context.Context, meRequest kr.MeRequest, isPairing bool) (meResponse *kr.MeResponse, err error) {
+	if err = mock.record(kr.Request{MeRequest: &meRequest}); err != nil {
+		return
+	}
+	mock.Lock()
+	defer mock.Unlock()
+	if mock.MeResponse == nil {
+		err = krd.ErrTimeout
+		return
+	}
+	meResponse = mock.MeResponse
+	me := meResponse.Me
+	mock.cachedMe = &me
+	mock.publish(krd.PairingEvent{Type: krd.PairingEventMeUpdated, Profile: &me})
+	return
+}
+
+func (mock *MockEnclaveClient) GetCachedMe() *kr.Profile {
+	mock.Lock()
+	defer mock.Unlock()
+	return mock.cachedMe
+}
+
+//	Returns once RequestMe caches a profile, or immediately if one is cached
+func (mock *MockEnclaveClient) WaitForMe(ctx context.Context) (me *kr.Profile, err error) {
+	events := mock.Subscribe()
+	defer mock.Unsubscribe(events)
+	if me = mock.GetCachedMe(); me != nil {
+		return
+	}
+	for {
+		select {
+		case event := <-events:
+			if event.Type == krd.PairingEventMeUpdated {
+				me = event.Profile
+				return
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+}
+
+//	Falls back to the cached profile when MeResponse is unset
+func (mock *MockEnclaveClient) RequestMeOrCached() (me *kr.Profile, stale bool, err error) {
+	if !mock.IsPaired() {
+		err = krd.ErrNotPaired
+		return
+	}
+	meResponse, err := mock.RequestMe(kr.MeRequest{}, false)
+	if err == nil {
+		fresh := meResponse.Me
+		me = &fresh
+		return
+	}
+	if me = mock.GetCachedMe(); me != nil {
+		stale = true
+		err = nil
+	}
+	return
+}
+
+func (mock *MockEnclaveClient) RefreshMe() (me *kr.Profile, err error) {
+	if !mock.IsPaired() {
+		err = krd.ErrNotPaired
+		return
+	}
+	meResponse, err := mock.RequestMe(kr.MeRequest{}, false)
+	if err != nil {
+		return
+	}
+	profile := meResponse.Me
+	me = &profile
+	return
+}
+
+func (mock *MockEnclaveClient) CachedPublicKeyWire() (wire []byte, err error) {
+	me := mock.GetCachedMe()
+	if me == nil {
+		err = krd.ErrNoCachedMe
+		return
+	}
+	wire = append(wire, me.SSHWirePublicKey...)
+	return
+}
+
+func (mock *MockEnclaveClient) CachedAuthorizedKeyLine() (line string, err error) {
+	me := mock.GetCachedMe()
+	if me == nil {
+		err = krd.ErrNoCachedMe
+		return
+	}
+	line, err = me.AuthorizedKeyString()
+	return
+}
+
+func (mock *MockEnclaveClient) RequestSignature(signRequest kr.SignRequest, onACK func()) (*kr.SignResponse, semver.Version, error) {
+	return mock.RequestSignatureCtx(context.Background(), signRequest, onACK)
+}
+
+func (mock *MockEnclaveClient) RequestSignatureWithTimeout(signRequest kr.SignRequest, timeout time.Duration, onACK func()) (*kr.SignResponse, semver.Version, error) {
+	return mock.RequestSignatureCtx(context.Background(), signRequest, onACK)
+}
+
+func (mock *MockEnclaveClient) RequestSignatureCtx(ctx context.Context, signRequest kr.SignRequest, onACK func()) (signResponse *kr.SignResponse, version semver.Version, err error) {
+	defer func() {
+		entry := krd.SignatureAuditEntry{
+			Time:                 time.Now(),
+			PublicKeyFingerprint: signRequest.PublicKeyFingerprint,
+			Command:              signRequest.Command,
+			Succeeded:            err == nil && signResponse.Signature != nil,
+		}
+		if err != nil {
+			errStr := err.Error()
+			entry.Error = &errStr
+		}
+		mock.Lock()
+		mock.auditLog = append(mock.auditLog, entry)
+		mock.Unlock()
+	}()
+	var hostNames []string
+	if signRequest.HostAuth != nil {
+		hostNames = signRequest.HostAuth.HostNames
+	}
+	mock.Lock()
+	policy := mock.hostPolicy
+	mock.Unlock()
+	if !policy.Allows(hostNames) {
+		err = krd.ErrPolicyDenied
+		return
+	}
+	if err = mock.record(kr.Request{SignRequest: &signRequest}); err != nil {
+		return
+	}
+	mock.Lock()
+	defer mock.Unlock()
+	if mock.SignResponse == nil {
+		err = krd.ErrTimeout
+		return
+	}
+	signResponse, version = mock.SignResponse, mock.Version
+	return
+}
+
+//	Answers every request in the batch with SignResponse
+func (mock *MockEnclaveClient) RequestSignatureBatch(signRequests []kr.SignRequest) (signResponses []*kr.SignResponse, err error) {
+	if err = mock.record(kr.Request{SignBatchRequest: &kr.SignBatchRequest{Requests: signRequests}}); err != nil {
+		return
+	}
+	mock.Lock()
+	defer mock.Unlock()
+	if mock.SignResponse == nil {
+		err = krd.ErrTimeout
+		return
+	}
+	for range signRequests {
+		signResponse := *mock.SignResponse
+		signResponses = append(signResponses, &signResponse)
+	}
+	return
+}
+
+//	Sets CertSignature on cert without checking its contents
+func (mock *MockEnclaveClient) RequestCertSignature(cert *ssh.Certificate) (signature *ssh.Signature, err error) {
+	if err = mock.record(kr.Request{CertSignRequest: &kr.CertSignRequest{}}); err != nil {
+		return
+	}
+	mock.Lock()
+	defer mock.Unlock()
+	if mock.CertSignature == nil {
+		err = krd.ErrTimeout
+		return
+	}
+	signature = mock.CertSignature
+	cert.Signature = signature
+	return
+}
+
+//	Only checks that the request has data, the mock holds no keys
+func (mock *MockEnclaveClient) ValidateSignRequest(signRequest kr.SignRequest) (err error) {
+	if len(signRequest.Data) == 0 {
+		err = errors.New("sign request data is empty")
+	}
+	return
+}
+
+func (mock *MockEnclaveClient) RequestList() (*kr.ListResponse, error) {
+	return mock.RequestListCtx(context.Background())
+}
+
+func (mock *MockEnclaveClient) RequestListCtx(ctx context.Context) (listResponse *kr.ListResponse, err error) {
+	if err = mock.record(kr.Request{ListRequest: &kr.ListRequest{}}); err != nil {
+		return
+	}
+	mock.Lock()
+	defer mock.Unlock()
+	if mock.ListResponse == nil {
+		err = krd.ErrTimeout
+		return
+	}
+	listResponse = mock.ListResponse
+	return
+}
+
+//	Yields the whole of ListResponse as a single chunk
+func (mock *MockEnclaveClient) RequestListStream(ctx context.Context) <-chan krd.ListChunk {
+	chunks := make(chan krd.ListChunk, 1)
+	listResponse, err := mock.RequestListCtx(ctx)
+	if err != nil {
+		chunks <- krd.ListChunk{Err: err}
+	} else {
+		chunks <- krd.ListChunk{Keys: listResponse.Keys}
+	}
+	close(chunks)
+	return chunks
+}
+
+func (mock *MockEnclaveClient) AvailableKeys() (keys []krd.KeyInfo, err error) {
+	listResponse, err := mock.RequestList()
+	if err != nil {
+		return
+	}
+	for _, profile := range listResponse.Keys {
+		info, keyErr := krd.KeyInfoFromProfile(profile)
+		if keyErr != nil {
+			err = keyErr
+			return
+		}
+		keys = append(keys, info)
+	}
+	return
+}
+
+func (mock *MockEnclaveClient) RequestGitSignature(gitSignRequest kr.GitSignRequest, onACK func()) (gitSignResponse *kr.GitSignResponse, version semver.Version, err error) {
+	if err = mock.record(kr.Request{GitSignRequest: &gitSignRequest}); err != nil {
+		return
+	}
+	mock.Lock()
+	defer mock.Unlock()
+	if mock.GitSignResponse == nil {
+		err = krd.ErrTimeout
+		return
+	}
+	gitSignResponse, version = mock.GitSignResponse, mock.Version
+	return
+}
+
+func (mock *MockEnclaveClient) RequestGeneric(request kr.Request, onACK func()) (response kr.Response, err error) {
+	if err = mock.record(request); err != nil {
+		return
+	}
+	mock.Lock()
+	defer mock.Unlock()
+	response = kr.Response{
+		RequestID:       request.RequestID,
+		Version:         mock.Version,
+		MeResponse:      mock.MeResponse,
+		SignResponse:    mock.SignResponse,
+		GitSignResponse: mock.GitSignResponse,
+		ListResponse:    mock.ListResponse,
+		HostsResponse:   mock.HostsResponse,
+	}
+	return
+}
+
+func (mock *MockEnclaveClient) RawRequest(request kr.Request, timeout time.Duration) (response *kr.Response, err error) {
+	rawResponse, err := mock.RequestGeneric(request, nil)
+	if err != nil {
+		return
+	}
+	response = &rawResponse
+	return
+}
+
+//	Answered like RequestGeneric, whatever the type of the failed request
+func (mock *MockEnclaveClient) RetryLast() (response kr.Response, err error) {
+	mock.Lock()
+	lastFailed := mock.lastFailed
+	mock.lastFailed = nil
+	mock.Unlock()
+	if lastFailed == nil {
+		err = krd.ErrNoFailedRequest
+		return
+	}
+	request := *lastFailed
+	request.RequestID = ""
+	return mock.RequestGeneric(request, nil)
+}
+
+func (mock *MockEnclaveClient) RequestNoOp() (err error) {
+	mock.Lock()
+	defer mock.Unlock()
+	if !mock.paired {
+		err = krd.ErrNotPaired
+	}
+	return
+}
+
+//	Always empty, the mock never waits for a key
+func (mock *MockEnclaveClient) QueueDepth() int {
+	return 0
+}
+
+func (mock *MockEnclaveClient) Stats() krd.Stats {
+	return krd.Stats{Paired: mock.IsPaired()}
+}
+
+func (mock *MockEnclaveClient) SetRequestTimeouts(me, sign, list time.Duration) {
+	mock.Lock()
+	defer mock.Unlock()
+	if me > 0 {
+		mock.timeouts.Me.Fail = me
+	}
+	if sign > 0 {
+		mock.timeouts.Sign.Fail = sign
+	}
+	if list > 0 {
+		mock.timeouts.List.Fail = list
+	}
+}
+
+func (mock *MockEnclaveClient) SetRetryPolicy(policy krd.RetryPolicy) {
+	mock.Lock()
+	defer mock.Unlock()
+	mock.retryPolicy = policy
+}
+
+//	The mock does not log
+func (mock *MockEnclaveClient) SetLogger(logger kr.Logger) {}
+
+func (mock *MockEnclaveClient) Subscribe() <-chan krd.PairingEvent {
+	mock.Lock()
+	defer mock.Unlock()
+	ch := make(chan krd.PairingEvent, 16)
+	mock.subscribers = append(mock.subscribers, ch)
+	return ch
+}
+
+func (mock *MockEnclaveClient) Unsubscribe(ch <-chan krd.PairingEvent) {
+	mock.Lock()
+	defer mock.Unlock()
+	for i, subscriber := range mock.subscribers {
+		if (<-chan krd.PairingEvent)(subscriber) == ch {
+			mock.subscribers = append(mock.subscribers[:i], mock.subscribers[i+1:]...)
+			close(subscriber)
+			return
+		}
+	}
+}
+
+//	The mock is always warm
+func (mock *MockEnclaveClient) Warmup(ctx context.Context) (err error) {
+	mock.Lock()
+	defer mock.Unlock()
+	if !mock.paired {
+		err = krd.ErrNotPaired
+	}
+	return
+}
+
+func (mock *MockEnclaveClient) Ping() (rtt time.Duration, err error) {
+	if err = mock.record(kr.Request{MeRequest: &kr.MeRequest{}}); err != nil {
+		return
+	}
+	return
+}
+
+func (mock *MockEnclaveClient) BluetoothStatus() (krd.BluetoothStatus, error) {
+	mock.Lock()
+	defer mock.Unlock()
+	return mock.bluetoothStatus, nil
+}
+
+//	The mock always signs without prompting, so remembering hosts is a no-op
+func (mock *MockEnclaveClient) SetRememberHostTTL(time.Duration) {}
+func (mock *MockEnclaveClient) ForgetHost(string)                {}
+func (mock *MockEnclaveClient) ForgetAllHosts()                  {}
+
+//	Requests the policy denies fail with krd.ErrPolicyDenied without being
+//	recorded
+func (mock *MockEnclaveClient) SetHostPolicy(policy krd.HostPolicy) (err error) {
+	mock.Lock()
+	defer mock.Unlock()
+	mock.hostPolicy = policy
+	return
+}
+
+func (mock *MockEnclaveClient) AuditLog() (entries []krd.SignatureAuditEntry) {
+	mock.Lock()
+	defer mock.Unlock()
+	entries = append(entries, mock.auditLog...)
+	return
+}
+
+func (mock *MockEnclaveClient) Diagnostics() kr.Diagnostics {
+	status, _ := mock.BluetoothStatus()
+	diagnostics := kr.Diagnostics{
+		Version:          kr.CURRENT_VERSION.String(),
+		Bluetooth:        status.String(),
+		RecentSignatures: []krd.SignatureAuditEntry{},
+	}
+	for _, device := range mock.PairedDevices() {
+		diagnostics.Paired = diagnostics.Paired || device.Paired
+		diagnostics.Pairings = append(diagnostics.Pairings, kr.PairingDiagnostics{ID: device.ID, Paired: device.Paired})
+	}
+	return diagnostics
+}
+
+func (mock *MockEnclaveClient) CancelRequest(requestID string) error {
+	return krd.ErrRequestNotPending
+}
+
+//	Requests to the mock are never pending
+func (mock *MockEnclaveClient) CancelAll() int {
+	return 0
+}
+
+//	Never incremented, the mock sends nothing to a phone
+func (mock *MockEnclaveClient) Metrics() *krd.Metrics {
+	mock.Lock()
+	defer mock.Unlock()
+	if mock.metrics == nil {
+		mock.metrics = krd.NewMetrics()
+	}
+	return mock.metrics
+}
+
+func (mock *MockEnclaveClient) ExportPairing(passphrase string) (data []byte, err error) {
+	mock.Lock()
+	pairingSecret := mock.pairingSecret
+	mock.Unlock()
+	if pairingSecret == nil {
+		err = krd.ErrNotPaired
+		return
+	}
+	data, err = kr.ExportPairingSecret(pairingSecret, passphrase)
+	return
+}
+
+func (mock *MockEnclaveClient) ImportPairing(data []byte, passphrase string) (err error) {
+	pairingSecret, err := kr.ImportPairingSecret(data, passphrase)
+	if err != nil {
+		return
+	}
+	mock.Lock()
+	defer mock.Unlock()
+	mock.pairingSecret = pairingSecret
+	mock.paired = true
+	mock.cachedMe = nil
+	mock.publish(krd.PairingEvent{Type: krd.PairingEventPaired})
+	return
+}
+
+//	Rotation always succeeds without a rescan
+func (mock *MockEnclaveClient) RotatePairing() (pairing *kr.PairingSecret, rotated bool, err error) {
+	if !mock.IsPaired() {
+		err = krd.ErrNotPaired
+		return
+	}
+	mock.Lock()
+	pairing, err = kr.GeneratePairingSecret(&mock.pairingSecret.WorkstationName)
+	if err == nil {
+		mock.pairingSecret = pairing
+		rotated = true
+	}
+	mock.Unlock()
+	return
+}
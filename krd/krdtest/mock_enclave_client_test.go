@@ -0,0 +1,47 @@
+package krdtest
+
+import (
+	"testing"
+
+	"github.com/kryptco/kr"
+	"github.com/kryptco/kr/krd"
+)
+
+func TestMockCannedResponses(t *testing.T) {
+	me, _, _ := kr.TestMe(t)
+	mock := NewMockEnclaveClient(me)
+	signature := []byte("signature")
+	mock.SignResponse = &kr.SignResponse{Signature: &signature}
+
+	meResponse, err := mock.RequestMe(kr.MeRequest{}, false)
+	if err != nil || !meResponse.Me.Equal(me) {
+		t.Fatal("unexpected me response", err)
+	}
+	signResponse, _, err := mock.RequestSignature(kr.SignRequest{Data: []byte("data")}, nil)
+	if err != nil || string(*signResponse.Signature) != "signature" {
+		t.Fatal("unexpected sign response", err)
+	}
+	if _, err = mock.RequestList(); err != krd.ErrTimeout {
+		t.Fatal("expected timeout without a canned list response, got", err)
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 3 || requests[0].MeRequest == nil || requests[1].SignRequest == nil || requests[2].ListRequest == nil {
+		t.Fatal("unexpected recorded requests", requests)
+	}
+}
+
+func TestMockInjectedError(t *testing.T) {
+	me, _, _ := kr.TestMe(t)
+	mock := NewMockEnclaveClient(me)
+	mock.Err = krd.ErrTimeout
+
+	if _, _, err := mock.RequestSignature(kr.SignRequest{}, nil); err != krd.ErrTimeout {
+		t.Fatal("expected injected timeout, got", err)
+	}
+	mock.Unpair()
+	mock.Err = nil
+	if _, err := mock.RequestMe(kr.MeRequest{}, false); err != krd.ErrNotPaired {
+		t.Fatal("expected not paired, got", err)
+	}
+}
@@ -0,0 +1,98 @@
+package krd
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+//	Samples older than this are dropped unless configured otherwise
+const DEFAULT_LATENCY_WINDOW = 24 * time.Hour
+
+//	Bounds memory use when many signatures fall within the window
+const MAX_LATENCY_SAMPLES = 4096
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+//	Latencies observed within a sliding window. Must be used with its
+//	Metrics locked.
+type latencyWindow struct {
+	window  time.Duration
+	samples []latencySample
+	//	Ages samples, the real clock when nil
+	clock Clock
+}
+
+func (lw *latencyWindow) now() time.Time {
+	if lw.clock == nil {
+		return time.Now()
+	}
+	return lw.clock.Now()
+}
+
+func (lw *latencyWindow) prune(now time.Time) {
+	window := lw.window
+	if window <= 0 {
+		window = DEFAULT_LATENCY_WINDOW
+	}
+	firstKept := 0
+	for firstKept < len(lw.samples) && now.Sub(lw.samples[firstKept].at) > window {
+		firstKept++
+	}
+	if overflow := len(lw.samples) - firstKept - MAX_LATENCY_SAMPLES; overflow > 0 {
+		firstKept += overflow
+	}
+	if firstKept > 0 {
+		lw.samples = append([]latencySample{}, lw.samples[firstKept:]...)
+	}
+}
+
+func (lw *latencyWindow) observe(latency time.Duration) {
+	now := lw.now()
+	lw.samples = append(lw.samples, latencySample{at: now, latency: latency})
+	lw.prune(now)
+}
+
+//	Nearest-rank percentiles, each q in [0, 1]. Zero when no samples are
+//	in the window.
+func (lw *latencyWindow) percentiles(qs ...float64) (latencies []time.Duration) {
+	lw.prune(lw.now())
+	latencies = make([]time.Duration, len(qs))
+	if len(lw.samples) == 0 {
+		return
+	}
+	sorted := make([]time.Duration, len(lw.samples))
+	for i, sample := range lw.samples {
+		sorted[i] = sample.latency
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i, q := range qs {
+		rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		if rank >= len(sorted) {
+			rank = len(sorted) - 1
+		}
+		latencies[i] = sorted[rank]
+	}
+	return
+}
+
+//	p50, p95 and p99 latency of successful signatures within the window
+func (m *Metrics) SignatureLatencyPercentiles() (p50, p95, p99 time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	latencies := m.signatureLatencies.percentiles(0.5, 0.95, 0.99)
+	return latencies[0], latencies[1], latencies[2]
+}
+
+//	How far back SignatureLatencyPercentiles looks. Defaults to a day.
+func (m *Metrics) SetLatencyWindow(window time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.signatureLatencies.window = window
+}
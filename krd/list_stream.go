@@ -0,0 +1,153 @@
+package krd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/kryptco/kr"
+)
+
+//	Keys per page asked for by RequestListStream
+const LIST_PAGE_SIZE = 16
+
+//	Keys from one page of the phone's list. Err is set, on the last chunk, if
+//	the list failed.
+type ListChunk struct {
+	Keys []kr.Profile
+	Err  error
+}
+
+type listPageHandlerKey struct{}
+
+//	Requests made with the returned context pass each page of a paged list
+//	but the last to handler as it arrives
+func withListPageHandler(ctx context.Context, handler func(kr.ListResponse)) context.Context {
+	return context.WithValue(ctx, listPageHandlerKey{}, handler)
+}
+
+func listPageHandlerFromContext(ctx context.Context) func(kr.ListResponse) {
+	handler, _ := ctx.Value(listPageHandlerKey{}).(func(kr.ListResponse))
+	return handler
+}
+
+//	List the phone's keys, yielding each page as it arrives rather than once
+//	the whole list has, e.g. to fill a key picker. Pages received more than
+//	once, e.g. over both bluetooth and SQS, are yielded once, but may arrive
+//	out of order. The channel is closed after the last page, or after a chunk
+//	carrying the error that ended the list. Canceling ctx abandons the list.
+func (client *EnclaveClient) RequestListStream(ctx context.Context) <-chan ListChunk {
+	chunks := make(chan ListChunk)
+	queue := &listChunkQueue{wake: make(chan struct{}, 1)}
+
+	go kr.RecoverToLog(func() {
+		defer queue.close()
+		seen := map[int]bool{}
+		onPage := func(page kr.ListResponse) {
+			if seen[page.Page] {
+				return
+			}
+			seen[page.Page] = true
+			queue.push(ListChunk{Keys: page.Keys})
+		}
+		listResponse, err := client.requestPagedList(withListPageHandler(ctx, onPage))
+		switch {
+		case err != nil:
+		case listResponse == nil:
+			err = &ProtoError{fmt.Errorf("no list response")}
+		case listResponse.Error != nil:
+			err = fmt.Errorf("list failed: %s", *listResponse.Error)
+		default:
+			onPage(*listResponse)
+			return
+		}
+		queue.push(ListChunk{Err: err})
+	}, client.log)
+
+	go kr.RecoverToLog(func() {
+		defer close(chunks)
+		for {
+			chunk, ok := queue.pop(ctx)
+			if !ok {
+				return
+			}
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}, client.log)
+	return chunks
+}
+
+func (client *EnclaveClient) requestPagedList(ctx context.Context) (listResponse *kr.ListResponse, err error) {
+	request, err := kr.NewRequest()
+	if err != nil {
+		client.log.Error(err)
+		return
+	}
+	request.ListRequest = &kr.ListRequest{PageSize: LIST_PAGE_SIZE}
+	timeout := request.RequestParameters(client.getTimeouts()).Timeout
+	response, err := client.requestGenericWithTimeouts(ctx, request, timeout, nil)
+	if err != nil {
+		return
+	}
+	listResponse = response.ListResponse
+	return
+}
+
+//	Chunks waiting for the stream's reader, so that a slow reader never holds
+//	up the responses the request is receiving
+type listChunkQueue struct {
+	sync.Mutex
+	chunks []ListChunk
+	closed bool
+	//	signaled on push and close
+	wake chan struct{}
+}
+
+func (queue *listChunkQueue) push(chunk ListChunk) {
+	queue.Lock()
+	queue.chunks = append(queue.chunks, chunk)
+	queue.Unlock()
+	queue.signal()
+}
+
+func (queue *listChunkQueue) close() {
+	queue.Lock()
+	queue.closed = true
+	queue.Unlock()
+	queue.signal()
+}
+
+func (queue *listChunkQueue) signal() {
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+//	Next chunk, waiting for one to be pushed. ok is false once the queue is
+//	closed and drained, or ctx is done.
+func (queue *listChunkQueue) pop(ctx context.Context) (chunk ListChunk, ok bool) {
+	for {
+		queue.Lock()
+		if len(queue.chunks) > 0 {
+			chunk, ok = queue.chunks[0], true
+			queue.chunks = queue.chunks[1:]
+			queue.Unlock()
+			return
+		}
+		closed := queue.closed
+		queue.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case <-queue.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package krd
+
+import (
+	"errors"
+	"os"
+	"strconv"
+)
+
+//	Returned as soon as a request cannot be sent because the client is
+//	limited to local transports and neither USB nor bluetooth is connected
+var ErrNoLocalTransport = errors.New("No USB or bluetooth connection to the phone, and SNS/SQS is disabled")
+
+//	Set to a true value, e.g. KR_DISABLE_SNS=1, to reach the phone over USB
+//	and bluetooth only, never sending to SNS or polling SQS, e.g. to keep
+//	requests off AWS
+const DISABLE_SNS_ENV = "KR_DISABLE_SNS"
+
+func snsDisabledByEnv() bool {
+	disabled, _ := strconv.ParseBool(os.Getenv(DISABLE_SNS_ENV))
+	return disabled
+}
@@ -0,0 +1,46 @@
+package krd
+
+import (
+	"sync/atomic"
+
+	"github.com/kryptco/kr"
+)
+
+//	Route the client's logging to logger, or discard it if logger is nil.
+//	Safe to call while the client is running.
+func (ec *EnclaveClient) SetLogger(logger kr.Logger) {
+	ec.log.set(logger)
+}
+
+//	Forwards to a logger that can be replaced while the client's goroutines
+//	are logging
+type swappableLogger struct {
+	current atomic.Value
+}
+
+//	atomic.Value needs every stored value to have the same concrete type
+type loggerBox struct {
+	kr.Logger
+}
+
+func newSwappableLogger(logger kr.Logger) (swappable *swappableLogger) {
+	swappable = &swappableLogger{}
+	swappable.set(logger)
+	return
+}
+
+func (l *swappableLogger) set(logger kr.Logger) {
+	if logger == nil {
+		logger = kr.DiscardLogger
+	}
+	l.current.Store(loggerBox{logger})
+}
+
+func (l *swappableLogger) get() kr.Logger {
+	return l.current.Load().(loggerBox).Logger
+}
+
+func (l *swappableLogger) Error(args ...interface{})   { l.get().Error(args...) }
+func (l *swappableLogger) Warning(args ...interface{}) { l.get().Warning(args...) }
+func (l *swappableLogger) Notice(args ...interface{})  { l.get().Notice(args...) }
+func (l *swappableLogger) Info(args ...interface{})    { l.get().Info(args...) }
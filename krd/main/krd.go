@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime/debug"
@@ -88,6 +89,16 @@ func main() {
 
 	log.Notice("krd launched and listening on UNIX socket")
 
+	//	e.g. KR_METRICS_ADDR=localhost:9464
+	if metricsAddr := os.Getenv("KR_METRICS_ADDR"); metricsAddr != "" {
+		go func() {
+			err := http.ListenAndServe(metricsAddr, controlServer.EnclaveClient().Metrics())
+			if err != nil {
+				log.Error("metrics server return:", err)
+			}
+		}()
+	}
+
 	go func() {
 		sigchain.ServeDashboardIfParamsPresent()
 	}()
@@ -0,0 +1,258 @@
+package krd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	Counters describing the requests sent to the phone. Served in the
+//	Prometheus text format by ServeHTTP.
+type Metrics struct {
+	sync.Mutex
+	requestsByType        map[string]uint64
+	responsesByMedium     map[string]uint64
+	throttledByOperation  map[string]uint64
+	rateLimitedByType     map[string]uint64
+	evictedByReason       map[string]uint64
+	foreignCiphertexts    uint64
+	oversizedCiphertexts  uint64
+	signaturesSucceeded   uint64
+	signaturesFailed      uint64
+	signatureLatencyTotal time.Duration
+	signatureLatencies    latencyWindow
+	lastSignatureLatency  time.Duration
+	keyUnwraps            uint64
+	lastKeyUnwrap         time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requestsByType:       map[string]uint64{},
+		responsesByMedium:    map[string]uint64{},
+		throttledByOperation: map[string]uint64{},
+		rateLimitedByType:    map[string]uint64{},
+		evictedByReason:      map[string]uint64{},
+	}
+}
+
+//	Why a request was given up on without a response
+const (
+	//	no response before the request's timeout, usually because the phone
+	//	is unreachable
+	EVICTED_TIMEOUT = "timeout"
+	//	the caller's context was canceled
+	EVICTED_CANCELED = "canceled"
+	//	dropped from the full outgoing queue before it could be sent
+	EVICTED_CAPACITY = "capacity"
+)
+
+func requestType(request kr.Request) string {
+	switch {
+	case request.SignRequest != nil:
+		return "sign"
+	case request.GitSignRequest != nil:
+		return "git_sign"
+	case request.SignBatchRequest != nil:
+		return "sign_batch"
+	case request.CertSignRequest != nil:
+		return "cert_sign"
+	case request.MeRequest != nil:
+		return "me"
+	case request.ListRequest != nil:
+		return "list"
+	case request.HostsRequest != nil:
+		return "hosts"
+	case request.UnpairRequest != nil:
+		return "unpair"
+	case request.ReadTeamRequest != nil, request.TeamOperationRequest != nil, request.LogDecryptionRequest != nil:
+		return "team"
+	}
+	return "noop"
+}
+
+func (m *Metrics) countRequest(request kr.Request) {
+	m.Lock()
+	defer m.Unlock()
+	m.requestsByType[requestType(request)]++
+}
+
+func (m *Metrics) countResponse(medium string) {
+	m.Lock()
+	defer m.Unlock()
+	m.responsesByMedium[medium]++
+}
+
+func (m *Metrics) countThrottled(operation string) {
+	m.Lock()
+	defer m.Unlock()
+	m.throttledByOperation[operation]++
+}
+
+//	Number of SQS/SNS sends and receives throttled by AWS
+func (m *Metrics) Throttled() (total uint64) {
+	m.Lock()
+	defer m.Unlock()
+	for _, count := range m.throttledByOperation {
+		total += count
+	}
+	return
+}
+
+func (m *Metrics) countRateLimited(requestType string) {
+	m.Lock()
+	defer m.Unlock()
+	m.rateLimitedByType[requestType]++
+}
+
+//	Number of requests delayed or rejected by a rate limit
+func (m *Metrics) RateLimited() (total uint64) {
+	m.Lock()
+	defer m.Unlock()
+	for _, count := range m.rateLimitedByType {
+		total += count
+	}
+	return
+}
+
+func (m *Metrics) countEvicted(reason string) {
+	m.Lock()
+	defer m.Unlock()
+	m.evictedByReason[reason]++
+}
+
+//	Number of requests given up on without a response for reason, one of
+//	EVICTED_TIMEOUT, EVICTED_CANCELED or EVICTED_CAPACITY
+func (m *Metrics) Evicted(reason string) uint64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.evictedByReason[reason]
+}
+
+func (m *Metrics) countForeignCiphertext() {
+	m.Lock()
+	defer m.Unlock()
+	m.foreignCiphertexts++
+}
+
+//	Number of received ciphertexts that no pairing could decrypt
+func (m *Metrics) ForeignCiphertexts() uint64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.foreignCiphertexts
+}
+
+func (m *Metrics) countOversizedCiphertext() {
+	m.Lock()
+	defer m.Unlock()
+	m.oversizedCiphertexts++
+}
+
+//	Number of received ciphertexts dropped for exceeding the maximum size
+func (m *Metrics) OversizedCiphertexts() uint64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.oversizedCiphertexts
+}
+
+func (m *Metrics) observeKeyUnwrap(at time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	m.keyUnwraps++
+	m.lastKeyUnwrap = at
+}
+
+//	Number of times the phone's wrapped key was unwrapped, i.e. pairings
+//	completed or rotated
+func (m *Metrics) KeyUnwraps() uint64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.keyUnwraps
+}
+
+//	Time of the most recent key unwrap, zero if there has been none
+func (m *Metrics) LastKeyUnwrap() time.Time {
+	m.Lock()
+	defer m.Unlock()
+	return m.lastKeyUnwrap
+}
+
+func (m *Metrics) observeSignature(latency time.Duration, succeeded bool) {
+	m.Lock()
+	defer m.Unlock()
+	if succeeded {
+		m.signaturesSucceeded++
+		m.signatureLatencyTotal += latency
+		m.signatureLatencies.observe(latency)
+		m.lastSignatureLatency = latency
+	} else {
+		m.signaturesFailed++
+	}
+}
+
+//	Mean time taken by successful signatures
+func (m *Metrics) AverageSignatureLatency() time.Duration {
+	m.Lock()
+	defer m.Unlock()
+	if m.signaturesSucceeded == 0 {
+		return 0
+	}
+	return m.signatureLatencyTotal / time.Duration(m.signaturesSucceeded)
+}
+
+//	Time taken by the most recent successful signature
+func (m *Metrics) LastSignatureLatency() time.Duration {
+	m.Lock()
+	defer m.Unlock()
+	return m.lastSignatureLatency
+}
+
+func writeLabeledCounter(w io.Writer, name, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, key, values[key])
+	}
+}
+
+func (m *Metrics) WriteText(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+	writeLabeledCounter(w, "kr_requests_total", "Requests sent to the phone, by type.", "type", m.requestsByType)
+	writeLabeledCounter(w, "kr_responses_total", "Responses received from the phone, by transport.", "medium", m.responsesByMedium)
+	writeLabeledCounter(w, "kr_aws_throttled_total", "SQS/SNS operations throttled by AWS, by operation.", "operation", m.throttledByOperation)
+	writeLabeledCounter(w, "kr_rate_limited_total", "Requests delayed or rejected by a rate limit, by type.", "type", m.rateLimitedByType)
+	writeLabeledCounter(w, "kr_evicted_requests_total", "Requests given up on without a response, by reason.", "reason", m.evictedByReason)
+	fmt.Fprintf(w, "# HELP kr_foreign_ciphertexts_total Received ciphertexts no pairing could decrypt.\n# TYPE kr_foreign_ciphertexts_total counter\nkr_foreign_ciphertexts_total %d\n", m.foreignCiphertexts)
+	fmt.Fprintf(w, "# HELP kr_oversized_ciphertexts_total Received ciphertexts dropped for exceeding the maximum size.\n# TYPE kr_oversized_ciphertexts_total counter\nkr_oversized_ciphertexts_total %d\n", m.oversizedCiphertexts)
+	fmt.Fprintf(w, "# HELP kr_key_unwraps_total Wrapped keys received from the phone.\n# TYPE kr_key_unwraps_total counter\nkr_key_unwraps_total %d\n", m.keyUnwraps)
+	writeLabeledCounter(w, "kr_signatures_total", "Completed signature requests, by result.", "result", map[string]uint64{
+		"success": m.signaturesSucceeded,
+		"failure": m.signaturesFailed,
+	})
+	fmt.Fprintf(w, "# HELP kr_signature_latency_seconds Time taken by successful signatures.\n# TYPE kr_signature_latency_seconds summary\n")
+	quantiles := []float64{0.5, 0.95, 0.99}
+	for i, latency := range m.signatureLatencies.percentiles(quantiles...) {
+		fmt.Fprintf(w, "kr_signature_latency_seconds{quantile=\"%g\"} %g\n", quantiles[i], latency.Seconds())
+	}
+	fmt.Fprintf(w, "kr_signature_latency_seconds_sum %g\n", m.signatureLatencyTotal.Seconds())
+	fmt.Fprintf(w, "kr_signature_latency_seconds_count %d\n", m.signaturesSucceeded)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteText(w)
+}
+
+func (ec *EnclaveClient) Metrics() *Metrics {
+	return ec.metrics
+}
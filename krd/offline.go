@@ -0,0 +1,17 @@
+package krd
+
+import (
+	"errors"
+)
+
+//	Returned as soon as a request cannot be sent while nothing is connected
+//	to the phone, rather than after waiting out the request's timeout
+var ErrOffline = errors.New("Phone unreachable, check your network connection")
+
+//	Whether a failed send means the phone cannot be reached at all: no
+//	transport was connected to it and SNS/SQS rejected the message outright.
+//	Throttled sends will still go through and are not offline.
+func isOffline(connected bool, sendErr error) bool {
+	_, sendFailed := sendErr.(*SendError)
+	return !connected && sendFailed
+}
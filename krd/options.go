@@ -0,0 +1,86 @@
+package krd
+
+import (
+	"github.com/kryptco/kr"
+)
+
+//	Optional configuration passed to UnpairedEnclaveClient
+type EnclaveClientOption func(*EnclaveClient)
+
+//	Size of the caches of acknowledged requests and in-flight signatures, and
+//	the initial capacity of the pending request table. Defaults to 128.
+func WithRequestCacheSize(size int) EnclaveClientOption {
+	return func(ec *EnclaveClient) {
+		ec.requestCacheSize = size
+	}
+}
+
+//	Cap the messages queued while waiting for the phone's key, MAX_QUEUED_MESSAGES
+//	by default, and choose what happens to messages queued beyond it.
+//	DropNewest by default.
+func WithOutgoingQueueLimit(capacity int, overflow QueueOverflowPolicy) EnclaveClientOption {
+	return func(ec *EnclaveClient) {
+		ec.outgoingQueue.capacity = capacity
+		ec.outgoingQueue.overflow = overflow
+	}
+}
+
+//	Clock used for request timeouts, retries and receive backoff. The system
+//	clock by default.
+func WithClock(clock Clock) EnclaveClientOption {
+	return func(ec *EnclaveClient) {
+		ec.clock = clock
+	}
+}
+
+//	Create new pairings' queues on relay rather than the one configured by
+//	the KR_AWS_REGION, KR_AWS_ACCOUNT_ID, KR_SQS_ENDPOINT and KR_SNS_ENDPOINT
+//	environment variables, or the hosted relay if none are set.
+func WithRelay(relay *kr.RelayConfig) EnclaveClientOption {
+	return func(ec *EnclaveClient) {
+		ec.relay = relay
+	}
+}
+
+//	Limit requests of requestType, e.g. "sign" or "list", so a runaway client
+//	cannot drain the phone's battery or exhaust SNS limits. Unlimited by
+//	default.
+func WithRateLimit(requestType string, limit RateLimit) EnclaveClientOption {
+	return func(ec *EnclaveClient) {
+		ec.rateLimits[requestType] = newTokenBucket(limit)
+	}
+}
+
+//	Drop received ciphertexts larger than size bytes with ErrMessageTooLarge.
+//	DEFAULT_MAX_CIPHERTEXT_SIZE by default.
+func WithMaxCiphertextSize(size int) EnclaveClientOption {
+	return func(ec *EnclaveClient) {
+		ec.maxCiphertextSize = size
+	}
+}
+
+//	Reach the phone over USB and bluetooth only, as with KR_DISABLE_SNS.
+//	Requests fail with ErrNoLocalTransport while neither is connected.
+func WithLocalTransportsOnly() EnclaveClientOption {
+	return func(ec *EnclaveClient) {
+		ec.localOnly = true
+	}
+}
+
+//	Create bluetooth drivers with newDriver rather than NewBluetoothDriver,
+//	e.g. for another BLE stack or an in-memory driver in tests. Called on
+//	Start and whenever the driver is restarted.
+func WithBluetoothDriver(newDriver func() (BluetoothDriverI, error)) EnclaveClientOption {
+	return func(ec *EnclaveClient) {
+		ec.newBluetoothDriver = newDriver
+	}
+}
+
+//	Connect to phones attached over USB with newDriver rather than
+//	NewUSBDriver, e.g. an in-memory driver in tests. Called every
+//	USB_DETECT_INTERVAL while no phone is connected.
+func WithUSBDriver(newDriver func() (BluetoothDriverI, error)) EnclaveClientOption {
+	return func(ec *EnclaveClient) {
+		ec.newUSBDriver = newDriver
+	}
+}
@@ -0,0 +1,115 @@
+package krd
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+const MAX_QUEUED_MESSAGES = 128
+
+//	Returned by requests that could not be queued while waiting for the
+//	phone's key under the RejectWithError overflow policy
+var ErrQueueFull = errors.New("Outgoing queue is full")
+
+//	What to do with a message queued while the outgoing queue is full
+type QueueOverflowPolicy int
+
+const (
+	//	drop the message being queued
+	DropNewest QueueOverflowPolicy = iota
+	//	drop the longest queued message to make room
+	DropOldest
+	//	drop the message being queued and fail its request with ErrQueueFull
+	RejectWithError
+)
+
+//	Messages waiting for a pairing to complete. Drained highest priority
+//	first, FIFO within a priority.
+type outgoingQueue struct {
+	messages []kr.QueuedMessage
+	//	MAX_QUEUED_MESSAGES if zero
+	capacity int
+	overflow QueueOverflowPolicy
+	//	messages dropped by push because the queue was full
+	dropped uint64
+}
+
+//	Replace the queued messages, keeping the capacity and overflow policy
+func (queue *outgoingQueue) load(messages []kr.QueuedMessage) {
+	queue.messages = nil
+	for _, message := range messages {
+		queue.push(message)
+	}
+}
+
+func (queue *outgoingQueue) clear() {
+	queue.messages = nil
+}
+
+func (queue *outgoingQueue) full() bool {
+	capacity := queue.capacity
+	if capacity <= 0 {
+		capacity = MAX_QUEUED_MESSAGES
+	}
+	return len(queue.messages) >= capacity
+}
+
+//	Returns false if the queue is full and message was dropped
+func (queue *outgoingQueue) push(message kr.QueuedMessage) bool {
+	for queue.full() {
+		queue.dropped++
+		if queue.overflow != DropOldest || len(queue.messages) == 0 {
+			return false
+		}
+		queue.dropOldest()
+	}
+	//	insert after every message of equal or higher priority
+	index := sort.Search(len(queue.messages), func(i int) bool {
+		return queue.messages[i].Priority < message.Priority
+	})
+	queue.messages = append(queue.messages, kr.QueuedMessage{})
+	copy(queue.messages[index+1:], queue.messages[index:])
+	queue.messages[index] = message
+	return true
+}
+
+//	Remove and return all messages not older than MAX_QUEUED_MESSAGE_AGE,
+//	in send order
+func (queue *outgoingQueue) drain(now time.Time) (messages []kr.QueuedMessage) {
+	messages = freshQueuedMessages(queue.messages, now)
+	queue.messages = nil
+	return
+}
+
+func (queue *outgoingQueue) dropOldest() {
+	oldest := 0
+	for i, message := range queue.messages {
+		if message.QueuedAt.Before(queue.messages[oldest].QueuedAt) {
+			oldest = i
+		}
+	}
+	queue.messages = append(queue.messages[:oldest], queue.messages[oldest+1:]...)
+}
+
+func (queue *outgoingQueue) len() int {
+	return len(queue.messages)
+}
+
+func freshQueuedMessages(queue []kr.QueuedMessage, now time.Time) (fresh []kr.QueuedMessage) {
+	for _, queuedMessage := range queue {
+		if now.Sub(queuedMessage.QueuedAt) < MAX_QUEUED_MESSAGE_AGE {
+			fresh = append(fresh, queuedMessage)
+		}
+	}
+	return
+}
+
+//	Number of messages waiting for the phone's key
+func (ec *EnclaveClient) QueueDepth() int {
+	ec.Lock()
+	defer ec.Unlock()
+	return ec.outgoingQueue.len()
+}
@@ -0,0 +1,45 @@
+package krd
+
+import (
+	"context"
+
+	"github.com/kryptco/kr"
+)
+
+//	Pair and block until the phone completes the pairing or ctx is done,
+//	returning the phone's profile. onPairingSecret is called with the new
+//	pairing, e.g. to display its QR code, before waiting. A pairing that does
+//	not complete is abandoned.
+func (ec *EnclaveClient) PairAndWait(ctx context.Context, pairingOptions kr.PairingOptions, onPairingSecret func(*kr.PairingSecret)) (me *kr.Profile, err error) {
+	pairingSecret, err := ec.Pair(pairingOptions)
+	if err != nil {
+		return
+	}
+	if onPairingSecret != nil {
+		onPairingSecret(pairingSecret)
+	}
+	defer func() {
+		if err != nil && !pairingSecret.IsPaired() {
+			ec.Lock()
+			ec.unpair(pairingSecret, false)
+			ec.Unlock()
+		}
+	}()
+	for {
+		var meResponse *kr.MeResponse
+		meResponse, err = ec.RequestMeCtx(ctx, kr.MeRequest{}, true)
+		if err == nil && meResponse != nil {
+			profile := meResponse.Me
+			me = &profile
+			return
+		}
+		//	keep waiting for the phone to scan until the caller gives up
+		if ctx.Err() != nil {
+			err = &CanceledError{ctx.Err()}
+			return
+		}
+		if err != nil && err != ErrTimeout {
+			return
+		}
+	}
+}
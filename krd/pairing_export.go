@@ -0,0 +1,62 @@
+package krd
+
+import (
+	"github.com/kryptco/kr"
+)
+
+//	Serialize the primary pairing so it can be installed on another
+//	workstation with ImportPairing. The export contains the pairing's secret
+//	key; pass a passphrase to encrypt it.
+func (ec *EnclaveClient) ExportPairing(passphrase string) (data []byte, err error) {
+	ps := ec.getPairingSecret()
+	if ps == nil || !ps.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+	data, err = kr.ExportPairingSecret(ps, passphrase)
+	return
+}
+
+//	Replace the current pairings with one exported by ExportPairing. Like
+//	re-pairing, requests in flight fail with ErrRepaired and queued ones are
+//	dropped.
+func (ec *EnclaveClient) ImportPairing(data []byte, passphrase string) (err error) {
+	pairingSecret, err := kr.ImportPairingSecret(data, passphrase)
+	if err != nil {
+		return
+	}
+
+	ec.Lock()
+	defer ec.Unlock()
+	if ec.closed {
+		err = ErrClosed
+		return
+	}
+	ec.cancelPendingRequests(&callbackT{err: ErrRepaired})
+	for _, ps := range ec.pairingSecrets {
+		ec.deactivatePairing(ps)
+		delete(ec.pairingRotations, string(ps.WorkstationPublicKey))
+		delete(ec.enclaveVersions, string(ps.WorkstationPublicKey))
+	}
+	ec.availableKeys = nil
+	ec.outgoingQueue.clear()
+	ec.saveOutgoingQueue()
+	ec.pairingSecrets = []*kr.PairingSecret{pairingSecret}
+	ec.cachedMe = nil
+	ec.Persister.DeleteMe()
+	ec.savePairings()
+
+	if !ec.localOnly {
+		go kr.RecoverToLog(func() {
+			setupErr := ec.Transport.Setup(pairingSecret)
+			if setupErr != nil {
+				ec.log.Error(setupErr)
+			}
+		}, ec.log)
+	}
+
+	//	re-derives the bluetooth service UUID from the imported keys
+	ec.activatePairing(pairingSecret)
+	ec.events.publish(PairingEvent{Type: PairingEventPaired})
+	return
+}
@@ -0,0 +1,154 @@
+package krd
+
+import (
+	"github.com/blang/semver"
+	"github.com/kryptco/kr"
+)
+
+//	A path for messages to the phone. sendMessage sends each message over
+//	the client's transports in order; the phone ignores the duplicates.
+type PhoneTransport interface {
+	//	Medium reported for responses received over this transport
+	Medium() string
+	//	Whether the phone is reachable over this transport without being
+	//	woken up
+	Connected() bool
+	//	Send message, already encrypted for pairingSecret as ciphertext.
+	//	alert asks transports that can wake the phone to do so. delivered
+	//	means the message reached the phone directly, so the transports after
+	//	this one are skipped. Failures not returned are logged to log.
+	Send(pairingSecret *kr.PairingSecret, message []byte, ciphertext []byte, alert bool, log kr.Logger) (delivered bool, err error)
+}
+
+//	Writes to a phone attached over USB. Ordered first, since a phone on a
+//	cable answers fastest. A successful write only means adb accepted it, not
+//	that the app is listening, so the message is still sent over the other
+//	transports.
+type usbTransport struct {
+	client *EnclaveClient
+}
+
+func (transport *usbTransport) Medium() string {
+	return USB
+}
+
+func (transport *usbTransport) Connected() bool {
+	transport.client.Lock()
+	defer transport.client.Unlock()
+	return transport.client.usb != nil
+}
+
+func (transport *usbTransport) Send(pairingSecret *kr.PairingSecret, message []byte, ciphertext []byte, alert bool, log kr.Logger) (delivered bool, err error) {
+	client := transport.client
+	client.Lock()
+	usb := client.usb
+	client.Unlock()
+	if usb == nil {
+		return
+	}
+	serviceUUID, err := pairingSecret.DeriveUUID()
+	if err != nil {
+		return
+	}
+	if writeErr := usb.Write(serviceUUID, ciphertext); writeErr != nil {
+		log.Error("error writing to USB", writeErr)
+	}
+	return
+}
+
+//	Writes ciphertexts to the phone's bluetooth service, framed when the phone
+//	supports it so that partial writes are detectable
+type bluetoothTransport struct {
+	client *EnclaveClient
+}
+
+func (transport *bluetoothTransport) Medium() string {
+	return BLUETOOTH
+}
+
+func (transport *bluetoothTransport) Connected() bool {
+	status, _ := transport.client.BluetoothStatus()
+	return status == BluetoothConnected
+}
+
+//	Bluetooth is best effort: the write happens in the background and errors
+//	are reflected in the bluetooth status rather than returned
+func (transport *bluetoothTransport) Send(pairingSecret *kr.PairingSecret, message []byte, ciphertext []byte, alert bool, log kr.Logger) (delivered bool, err error) {
+	client := transport.client
+	if client.supportsFramedBluetooth(pairingSecret) {
+		ciphertext = encodeFrame(ciphertext)
+	}
+	go func() {
+		err := client.writeBluetooth(pairingSecret, ciphertext)
+		if err != nil {
+			log.Error("error writing to Bluetooth", err)
+			client.Lock()
+			client.setBluetoothStatus(BluetoothError, err)
+			client.Unlock()
+		}
+	}()
+	return
+}
+
+//	Sends messages through the client's kr.Transport, i.e. SNS and SQS
+type queueTransport struct {
+	client *EnclaveClient
+}
+
+func (transport *queueTransport) Medium() string {
+	return SQS
+}
+
+//	Messages over SNS/SQS reach the phone by waking it with a push
+//	notification or once it next opens Krypton
+func (transport *queueTransport) Connected() bool {
+	return false
+}
+
+func (transport *queueTransport) Send(pairingSecret *kr.PairingSecret, message []byte, ciphertext []byte, alert bool, log kr.Logger) (delivered bool, err error) {
+	client := transport.client
+	if alert {
+		err = client.Transport.PushAlert(pairingSecret, "Krypton Request", message)
+	} else {
+		err = client.Transport.SendMessage(pairingSecret, message)
+	}
+	if err != nil {
+		err = client.classifyTransportError("send", err, func(err error) error { return &SendError{err} })
+		return
+	}
+	return
+}
+
+//	Record the version of the phone behind pairingSecret from one of its
+//	responses. Must be called with client locked
+func (client *EnclaveClient) setEnclaveVersion(pairingSecret *kr.PairingSecret, version semver.Version) {
+	if pairingSecret == nil || version.Equals(semver.Version{}) {
+		return
+	}
+	client.enclaveVersions[string(pairingSecret.WorkstationPublicKey)] = version
+}
+
+//	The version of the phone behind pairingSecret, ok false until it has
+//	responded
+func (client *EnclaveClient) getEnclaveVersion(pairingSecret *kr.PairingSecret) (version semver.Version, ok bool) {
+	client.Lock()
+	defer client.Unlock()
+	version, ok = client.enclaveVersions[string(pairingSecret.WorkstationPublicKey)]
+	return
+}
+
+func (client *EnclaveClient) supportsFramedBluetooth(pairingSecret *kr.PairingSecret) bool {
+	version, ok := client.getEnclaveVersion(pairingSecret)
+	return ok && version.GTE(kr.ENCLAVE_VERSION_SUPPORTS_FRAMED_BLUETOOTH)
+}
+
+//	Whether every phone a request may be sent to is known to be at least
+//	version. Phones that have not responded yet are assumed current.
+func (client *EnclaveClient) phonesSupport(version semver.Version) bool {
+	for _, ps := range client.getRequestPairingSecrets(false) {
+		if phoneVersion, ok := client.getEnclaveVersion(ps); ok && phoneVersion.LT(version) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package krd
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	Override how long Ping waits for the phone. Zero uses the me timeout.
+func (client *EnclaveClient) SetPingTimeout(timeout time.Duration) {
+	client.Lock()
+	defer client.Unlock()
+	client.pingTimeout = timeout
+}
+
+//	Check that a paired phone is reachable without prompting the user.
+//	Returns the round trip latency, or ErrTimeout if no phone responded.
+func (client *EnclaveClient) Ping() (latency time.Duration, err error) {
+	return client.pingCtx(context.Background())
+}
+
+func (client *EnclaveClient) pingCtx(ctx context.Context) (latency time.Duration, err error) {
+	if !client.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+	request, err := kr.NewRequest()
+	if err != nil {
+		return
+	}
+	request.MeRequest = &kr.MeRequest{}
+
+	client.Lock()
+	timeout := client.pingTimeout
+	if timeout == 0 {
+		timeout = client.Timeouts.Me.Fail
+	}
+	client.Unlock()
+
+	start := client.clock.Now()
+	callback, err := client.tryRequest(ctx, request, requestOptions{
+		timeout: timeout,
+		silent:  true,
+	})
+	if err != nil {
+		return
+	}
+	latency = client.clock.Now().Sub(start)
+	if callback == nil || callback.response.MeResponse == nil {
+		return
+	}
+
+	//	the ping carries no PGP user ID, so only replace the cached profile
+	//	if the phone's key changed
+	me := callback.response.MeResponse.Me
+	client.Lock()
+	keyChanged := client.cachedMe == nil || !bytes.Equal(client.cachedMe.SSHWirePublicKey, me.SSHWirePublicKey)
+	client.Unlock()
+	if keyChanged {
+		client.updateCachedMe(me)
+	}
+	return
+}
@@ -0,0 +1,170 @@
+package krd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	Polls one pairing's queue on behalf of every request waiting on it, so
+//	concurrent requests share a single receive loop rather than each polling
+//	SQS. Fields are guarded by the EnclaveClient lock.
+type queueReceiver struct {
+	waiters int
+	//	closed after each receive, then replaced
+	received chan struct{}
+	//	signaled to poll again without waiting out the backoff
+	wake chan struct{}
+	//	set once a receive fails permanently, failing every waiter
+	err error
+}
+
+//	SQS refused a receive in a way retrying cannot fix, e.g. due to revoked
+//	credentials
+type FatalRecvError struct {
+	error
+}
+
+func (err *FatalRecvError) Error() string {
+	return fmt.Sprintf("FatalRecvError: %s", err.error)
+}
+
+//	Poll promptly, e.g. because a request was just sent or reached its
+//	deadline
+func (receiver *queueReceiver) poke() {
+	select {
+	case receiver.wake <- struct{}{}:
+	default:
+	}
+}
+
+//	Register as waiting on responses from pairingSecret's queue, starting its
+//	receive loop if none is running. Call release once done waiting; the loop
+//	stops when no request is waiting.
+func (client *EnclaveClient) waitOnQueue(pairingSecret *kr.PairingSecret) (receiver *queueReceiver, release func()) {
+	key := string(pairingSecret.WorkstationPublicKey)
+	client.Lock()
+	receiver, ok := client.queueReceivers[key]
+	if !ok {
+		receiver = &queueReceiver{
+			received: make(chan struct{}),
+			wake:     make(chan struct{}, 1),
+		}
+		client.queueReceivers[key] = receiver
+		go kr.RecoverToLog(func() {
+			client.receiveFromQueue(pairingSecret, receiver)
+		}, client.log)
+	}
+	receiver.waiters++
+	client.Unlock()
+	receiver.poke()
+
+	release = func() {
+		client.Lock()
+		receiver.waiters--
+		idle := receiver.waiters == 0
+		client.Unlock()
+		if idle {
+			//	let the loop stop rather than poll once more
+			receiver.poke()
+		}
+	}
+	return
+}
+
+//	Channel closed once the receive in progress, or the next one, completes
+func (client *EnclaveClient) nextReceive(receiver *queueReceiver) <-chan struct{} {
+	client.Lock()
+	defer client.Unlock()
+	return receiver.received
+}
+
+func (client *EnclaveClient) receiveFromQueue(pairingSecret *kr.PairingSecret, receiver *queueReceiver) {
+	key := string(pairingSecret.WorkstationPublicKey)
+	backoff, errBackoff := MIN_RECEIVE_BACKOFF, MIN_RECEIVE_ERROR_BACKOFF
+	for {
+		client.Lock()
+		if receiver.waiters == 0 {
+			delete(client.queueReceivers, key)
+			client.Unlock()
+			return
+		}
+		client.Unlock()
+
+		n, err := client.receiveQueued(pairingSecret)
+
+		client.Lock()
+		receiver.err = nil
+		if _, fatal := err.(*FatalRecvError); fatal {
+			receiver.err = err
+		}
+		close(receiver.received)
+		receiver.received = make(chan struct{})
+		client.Unlock()
+
+		if err == nil {
+			errBackoff = MIN_RECEIVE_ERROR_BACKOFF
+		}
+		var wait time.Duration
+		switch {
+		case err != nil:
+			client.log.Error("queue err:", err)
+			//	transient errors are retried with backoff until the waiting
+			//	requests time out
+			wait = errBackoff
+			errBackoff *= 2
+			if errBackoff > MAX_RECEIVE_ERROR_BACKOFF {
+				errBackoff = MAX_RECEIVE_ERROR_BACKOFF
+			}
+			if errors.Is(err, ErrThrottled) {
+				wait = MIN_THROTTLED_BACKOFF
+				backoff = MAX_RECEIVE_BACKOFF
+			}
+		case n > 0:
+			backoff = MIN_RECEIVE_BACKOFF
+			continue
+		default:
+			wait = backoff
+			backoff *= 2
+			if backoff > MAX_RECEIVE_BACKOFF {
+				backoff = MAX_RECEIVE_BACKOFF
+			}
+		}
+		select {
+		case <-client.clock.After(wait):
+		case <-receiver.wake:
+			if err == nil {
+				backoff = MIN_RECEIVE_BACKOFF
+			}
+		}
+	}
+}
+
+//	Read and handle one batch of ciphertexts from pairingSecret's queue
+func (client *EnclaveClient) receiveQueued(pairingSecret *kr.PairingSecret) (numReceived int, err error) {
+	ciphertexts, err := client.Transport.Read(client.notifier, pairingSecret)
+	if err != nil {
+		err = client.classifyTransportError("receive", err, func(err error) error {
+			if kr.IsPermanentAWSError(err) {
+				return &FatalRecvError{err}
+			}
+			return &RecvError{err}
+		})
+		return
+	}
+
+	numReceived = len(ciphertexts)
+	//	a bad ciphertext must not hold up responses batched with it, the
+	//	request is only given up on once its own callback expires
+	for i, ctxt := range ciphertexts {
+		ctxtErr := client.handleCiphertext(ctxt, SQS)
+		switch ctxtErr {
+		case nil, kr.ErrWaitingForKey, ErrForeignCiphertext:
+		default:
+			client.log.Error(fmt.Sprintf("ciphertext %d of %d:", i+1, numReceived), ctxtErr)
+		}
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package krd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	Returned by requests beyond their type's rate limit when the limit does
+//	not queue them
+var ErrRateLimited = errors.New("Too many requests, try again shortly")
+
+//	A token bucket: Burst requests at once, refilled at Rate requests per
+//	second. Requests beyond it wait for a token if Queue is set and fail with
+//	ErrRateLimited otherwise. A bucket with no positive Rate is never
+//	refilled, so requests beyond its Burst fail even if Queue is set.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+	Queue bool
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+//	Returned by take when no token will ever be available
+const neverRefilled = time.Duration(math.MaxInt64)
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+	}
+}
+
+//	Take a token, or return how long until one is available
+func (bucket *tokenBucket) take(now time.Time) (wait time.Duration) {
+	if bucket.last.IsZero() {
+		bucket.last = now
+	}
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * bucket.limit.Rate
+		if bucket.tokens > float64(bucket.limit.Burst) {
+			bucket.tokens = float64(bucket.limit.Burst)
+		}
+		bucket.last = now
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return
+	}
+	if bucket.limit.Rate <= 0 {
+		return neverRefilled
+	}
+	wait = time.Duration((1 - bucket.tokens) / bucket.limit.Rate * float64(time.Second))
+	if wait <= 0 {
+		wait = time.Nanosecond
+	}
+	return
+}
+
+//	Wait for, or fail with ErrRateLimited without, a token from the rate limit
+//	of request's type. Requests of types without a limit pass immediately.
+func (ec *EnclaveClient) waitForRateLimit(ctx context.Context, request kr.Request) (err error) {
+	requestType := requestType(request)
+	engaged := false
+	for {
+		ec.Lock()
+		bucket, ok := ec.rateLimits[requestType]
+		if !ok {
+			ec.Unlock()
+			return
+		}
+		wait := bucket.take(ec.clock.Now())
+		queue := bucket.limit.Queue
+		ec.Unlock()
+		if wait == 0 {
+			return
+		}
+		if !engaged {
+			engaged = true
+			ec.metrics.countRateLimited(requestType)
+			ec.log.Warning(requestType, "request rate limited")
+		}
+		if !queue || wait == neverRefilled {
+			err = ErrRateLimited
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = &CanceledError{ctx.Err()}
+			return
+		case <-ec.clock.After(wait):
+		}
+	}
+}
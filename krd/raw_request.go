@@ -0,0 +1,24 @@
+package krd
+
+import (
+	"context"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	Send request, of any type, and return the phone's full response,
+//	including fields the typed methods drop such as SNSEndpointARN. A zero
+//	timeout uses the usual timeout for the request's type.
+func (client *EnclaveClient) RawRequest(request kr.Request, timeout time.Duration) (response *kr.Response, err error) {
+	timeouts := request.RequestParameters(client.getTimeouts()).Timeout
+	if timeout > 0 {
+		timeouts.Fail = timeout
+	}
+	rawResponse, err := client.requestGenericWithTimeouts(context.Background(), request, timeouts, nil)
+	if err != nil {
+		return
+	}
+	response = &rawResponse
+	return
+}
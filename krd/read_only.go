@@ -0,0 +1,41 @@
+package krd
+
+import (
+	"errors"
+
+	"github.com/kryptco/kr"
+)
+
+var ErrReadOnly = errors.New("Workstation is paired read-only and cannot request signatures")
+
+//	Requests an observer pairing may send
+func allowedReadOnly(request kr.Request) bool {
+	switch {
+	case request.MeRequest != nil, request.ListRequest != nil, request.RotatePairingRequest != nil:
+		return true
+	case request.SignRequest != nil, request.GitSignRequest != nil, request.SignBatchRequest != nil, request.CertSignRequest != nil,
+		request.HostsRequest != nil, request.ReadTeamRequest != nil, request.TeamOperationRequest != nil,
+		request.LogDecryptionRequest != nil:
+		return false
+	}
+	//	no-op
+	return true
+}
+
+//	Drop read-only pairings unless the request is allowed on them, failing
+//	with ErrReadOnly if only read-only pairings remain
+func withoutReadOnlyPairings(request kr.Request, pairingSecrets []*kr.PairingSecret) (allowed []*kr.PairingSecret, err error) {
+	if allowedReadOnly(request) {
+		allowed = pairingSecrets
+		return
+	}
+	for _, ps := range pairingSecrets {
+		if !ps.ReadOnly {
+			allowed = append(allowed, ps)
+		}
+	}
+	if len(allowed) == 0 && len(pairingSecrets) > 0 {
+		err = ErrReadOnly
+	}
+	return
+}
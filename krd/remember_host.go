@@ -0,0 +1,107 @@
+package krd
+
+import (
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	How long after the user approves a sign request for a host the phone may
+//	approve further requests for that host without prompting. Zero, the
+//	default, always prompts.
+func (ec *EnclaveClient) SetRememberHostTTL(ttl time.Duration) {
+	ec.Lock()
+	defer ec.Unlock()
+	ec.rememberHostTTL = ttl
+}
+
+//	Make the phone prompt for host again, disregarding approvals made before
+//	now, including ones remembered before the daemon restarted
+func (ec *EnclaveClient) ForgetHost(host string) {
+	ec.Lock()
+	defer ec.Unlock()
+	now := ec.clock.Now()
+	forgotten := kr.HostApproval{At: now, Expires: now.Add(ec.rememberHostTTL)}
+	if approval, ok := ec.hostApprovals.Approved[host]; ok && approval.Expires.After(forgotten.Expires) {
+		forgotten.Expires = approval.Expires
+	}
+	delete(ec.hostApprovals.Approved, host)
+	ec.hostApprovals.Forgotten[host] = forgotten
+	ec.saveHostApprovals()
+}
+
+//	ForgetHost for every host
+func (ec *EnclaveClient) ForgetAllHosts() {
+	ec.Lock()
+	defer ec.Unlock()
+	now := ec.clock.Now()
+	forgotten := kr.HostApproval{At: now, Expires: now.Add(ec.rememberHostTTL)}
+	for _, approval := range ec.hostApprovals.Approved {
+		if approval.Expires.After(forgotten.Expires) {
+			forgotten.Expires = approval.Expires
+		}
+	}
+	ec.hostApprovals = kr.NewHostApprovals()
+	ec.hostApprovals.AllForgotten = &forgotten
+	ec.saveHostApprovals()
+}
+
+//	Remember the user approving signRequest's host on the phone, so that
+//	forgetting it survives a restart. Approvals the phone made without
+//	prompting extend nothing.
+func (ec *EnclaveClient) recordHostApproval(signRequest kr.SignRequest, signResponse *kr.SignResponse) {
+	remember := signRequest.RememberHost
+	if remember == nil || signResponse == nil || signResponse.Signature == nil || signResponse.AutoApproved {
+		return
+	}
+	ec.Lock()
+	defer ec.Unlock()
+	now := ec.clock.Now()
+	ec.hostApprovals.Approved[remember.Host] = kr.HostApproval{
+		At:      now,
+		Expires: now.Add(time.Duration(remember.TTLSeconds) * time.Second),
+	}
+	ec.saveHostApprovals()
+}
+
+//	Must be called with ec locked
+func (ec *EnclaveClient) saveHostApprovals() {
+	if err := ec.Persister.SaveHostApprovals(ec.hostApprovals); err != nil {
+		ec.log.Error("error saving host approvals:", err.Error())
+	}
+}
+
+//	The RememberHost annotation for signRequest, nil to always prompt. The
+//	phone keeps the approvals, so a forgotten host is revoked by shortening
+//	the TTL to exclude approvals made before it was forgotten.
+func (ec *EnclaveClient) rememberHostPolicy(signRequest kr.SignRequest) *kr.RememberHost {
+	ec.Lock()
+	defer ec.Unlock()
+	ttl := ec.rememberHostTTL
+	if ttl <= 0 || signRequest.HostAuth == nil || len(signRequest.HostAuth.HostNames) == 0 {
+		return nil
+	}
+	host := signRequest.HostAuth.HostNames[0]
+	now := ec.clock.Now()
+	if forgotten, ok := ec.hostApprovals.Forgotten[host]; ok {
+		if sinceForgotten := now.Sub(forgotten.At); sinceForgotten < ttl {
+			ttl = sinceForgotten
+		} else if now.After(forgotten.Expires) {
+			delete(ec.hostApprovals.Forgotten, host)
+			ec.saveHostApprovals()
+		}
+	}
+	if allForgotten := ec.hostApprovals.AllForgotten; allForgotten != nil {
+		if sinceForgotten := now.Sub(allForgotten.At); sinceForgotten < ttl {
+			ttl = sinceForgotten
+		}
+	}
+	ttlSeconds := int64(ttl / time.Second)
+	if ttlSeconds <= 0 {
+		return nil
+	}
+	return &kr.RememberHost{
+		Host:       host,
+		TTLSeconds: ttlSeconds,
+	}
+}
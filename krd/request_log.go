@@ -0,0 +1,44 @@
+package krd
+
+import (
+	"github.com/kryptco/kr"
+)
+
+//	Prefixes everything logged with the ID of the request being handled, so
+//	that a request can be followed through the log by grepping for
+//	"[req=<id>]"
+type requestLogger struct {
+	kr.Logger
+	prefix string
+}
+
+//	The client's logger, prefixed with requestID unless it is empty
+func (client *EnclaveClient) requestLog(requestID string) kr.Logger {
+	if requestID == "" {
+		return client.log
+	}
+	return &requestLogger{
+		Logger: client.log,
+		prefix: "[req=" + requestID + "] ",
+	}
+}
+
+func (log *requestLogger) prefixed(args []interface{}) []interface{} {
+	return append([]interface{}{log.prefix}, args...)
+}
+
+func (log *requestLogger) Error(args ...interface{}) {
+	log.Logger.Error(log.prefixed(args)...)
+}
+
+func (log *requestLogger) Warning(args ...interface{}) {
+	log.Logger.Warning(log.prefixed(args)...)
+}
+
+func (log *requestLogger) Notice(args ...interface{}) {
+	log.Logger.Notice(log.prefixed(args)...)
+}
+
+func (log *requestLogger) Info(args ...interface{}) {
+	log.Logger.Info(log.prefixed(args)...)
+}
@@ -0,0 +1,89 @@
+package krd
+
+import (
+	"math/rand"
+	"time"
+)
+
+//	Governs how tryRequest re-sends a request that failed transiently.
+//	Attempts reuse the same RequestID so the phone can recognize a resend.
+type RetryPolicy struct {
+	//	Total number of attempts, including the first. Values less than 1
+	//	are treated as 1.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	Multiplier  float64
+	//	Fraction by which retry delays are randomly lengthened or shortened,
+	//	and request timeouts lengthened, so that requests started together,
+	//	e.g. on wake from sleep, do not all retry together
+	Jitter float64
+}
+
+//	One-shot by default: a request that times out may already have been
+//	shown to the user, so retries must be opted into.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 1,
+		BaseDelay:   500 * time.Millisecond,
+		Multiplier:  2,
+		Jitter:      0.2,
+	}
+}
+
+//	Delay before the given retry, where retry 1 follows the first attempt.
+func (policy RetryPolicy) delay(retry int) (delay time.Duration) {
+	delay = policy.BaseDelay
+	for i := 1; i < retry; i++ {
+		delay = time.Duration(float64(delay) * policy.Multiplier)
+	}
+	delay = policy.jitter(delay)
+	return
+}
+
+//	Randomize d by up to +/- Jitter
+func (policy RetryPolicy) jitter(d time.Duration) time.Duration {
+	if policy.Jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + policy.Jitter*(2*rand.Float64()-1)))
+}
+
+//	Randomly lengthen d by up to Jitter. Timeouts are never shortened, which
+//	would cut into the time the user has to respond.
+func (policy RetryPolicy) stretch(d time.Duration) time.Duration {
+	if policy.Jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + policy.Jitter*rand.Float64()))
+}
+
+func (policy RetryPolicy) attempts() int {
+	if policy.MaxAttempts < 1 {
+		return 1
+	}
+	return policy.MaxAttempts
+}
+
+func isRetryable(err error) bool {
+	if err == ErrTimeout {
+		return true
+	}
+	switch err.(type) {
+	case *RecvError, *SendError, *ThrottledError:
+		return true
+	}
+	return false
+}
+
+func (ec *EnclaveClient) SetRetryPolicy(policy RetryPolicy) {
+	ec.Lock()
+	defer ec.Unlock()
+	ec.retryPolicy = policy
+}
+
+func (ec *EnclaveClient) getRetryPolicy() (policy RetryPolicy) {
+	ec.Lock()
+	defer ec.Unlock()
+	policy = ec.retryPolicy
+	return
+}
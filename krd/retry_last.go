@@ -0,0 +1,58 @@
+package krd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+var ErrNoFailedRequest = errors.New("No failed request to retry")
+
+//	How long the most recently failed request is kept for RetryLast
+const LAST_FAILED_REQUEST_TTL = time.Minute
+
+type failedRequest struct {
+	request  kr.Request
+	timeout  kr.TimeoutPhases
+	failedAt time.Time
+}
+
+//	Keep request for RetryLast if it failed, forget the last failed request
+//	if it succeeded
+func (client *EnclaveClient) recordOutcome(request kr.Request, timeout kr.TimeoutPhases, err error) {
+	client.Lock()
+	defer client.Unlock()
+	if err == nil {
+		client.lastFailed = nil
+		return
+	}
+	if request.SignRequest != nil {
+		//	the caller may reuse its SignRequest
+		signRequest := *request.SignRequest
+		request.SignRequest = &signRequest
+	}
+	client.lastFailed = &failedRequest{
+		request:  request,
+		timeout:  timeout,
+		failedAt: client.clock.Now(),
+	}
+}
+
+//	Send the most recently failed request again under a new RequestID, e.g.
+//	for a retry button. Returns ErrNoFailedRequest if the last request
+//	succeeded or failed more than LAST_FAILED_REQUEST_TTL ago.
+func (client *EnclaveClient) RetryLast() (response kr.Response, err error) {
+	client.Lock()
+	lastFailed := client.lastFailed
+	client.lastFailed = nil
+	client.Unlock()
+	if lastFailed == nil || client.clock.Now().Sub(lastFailed.failedAt) > LAST_FAILED_REQUEST_TTL {
+		err = ErrNoFailedRequest
+		return
+	}
+	request := lastFailed.request
+	request.RequestID = ""
+	return client.requestGenericWithTimeouts(context.Background(), request, lastFailed.timeout, nil)
+}
@@ -0,0 +1,106 @@
+package krd
+
+import (
+	"context"
+
+	"github.com/kryptco/kr"
+)
+
+//	A pairing replacing an older one. The previous pairing keeps working until
+//	the replacement completes.
+type pairingRotation struct {
+	previous *kr.PairingSecret
+	//	the phone accepted the rotation, so it does not need an unpair request
+	//	for the previous pairing
+	phoneConfirmed bool
+}
+
+//	Replace the primary pairing with a freshly generated one. Phones that
+//	support rotation move to the new keys over the existing pairing and
+//	rotated is true. Otherwise the returned pairing must be scanned as for a
+//	full re-pair. Either way, the previous pairing is removed once the new one
+//	completes.
+func (ec *EnclaveClient) RotatePairing() (pairingSecret *kr.PairingSecret, rotated bool, err error) {
+	previous := ec.getPairingSecret()
+	if previous == nil || !previous.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+
+	ec.Lock()
+	pairingSecret, err = ec.generatePairing(kr.PairingOptions{
+		WorkstationName: &previous.WorkstationName,
+		ReadOnly:        previous.ReadOnly,
+		Relay:           previous.Relay,
+		//	the previous pairing carries the rotation request
+		Add: true,
+	})
+	if err != nil {
+		ec.Unlock()
+		return
+	}
+	ec.activatePairing(pairingSecret)
+	rotation := &pairingRotation{previous: previous}
+	ec.pairingRotations[string(pairingSecret.WorkstationPublicKey)] = rotation
+	ec.Unlock()
+
+	request, err := kr.NewRequest()
+	if err != nil {
+		ec.abandonRotation(pairingSecret)
+		return
+	}
+	request.RotatePairingRequest = &kr.RotatePairingRequest{
+		WorkstationPublicKey: pairingSecret.WorkstationPublicKey,
+		WorkstationName:      pairingSecret.WorkstationName,
+		Version:              pairingSecret.Version,
+		ReadOnly:             pairingSecret.ReadOnly,
+	}
+	timeouts := ec.getTimeouts()
+	callback, err := ec.tryRequest(context.Background(), request, requestOptions{
+		pairingSecrets: []*kr.PairingSecret{previous},
+		timeout:        timeouts.Pair.Fail,
+		alertTimeout:   timeouts.Pair.Alert,
+		alertText:      "Incoming pairing rotation. Open Krypton to continue.",
+	})
+	switch {
+	case err == ErrTimeout, err == nil && (callback == nil || callback.response.RotatePairingResponse == nil):
+		ec.log.Notice("phone does not support pairing rotation, falling back to re-pair")
+		err = nil
+		return
+	case err != nil:
+		ec.abandonRotation(pairingSecret)
+		return
+	case callback.response.RotatePairingResponse.Error != nil:
+		ec.log.Notice("phone declined pairing rotation:", *callback.response.RotatePairingResponse.Error)
+		return
+	}
+
+	ec.Lock()
+	rotation.phoneConfirmed = true
+	ec.Unlock()
+	rotated = true
+
+	//	receive the phone's key for the new pairing
+	_, err = ec.RequestMe(kr.MeRequest{}, true)
+	return
+}
+
+//	Drop a replacement pairing that never reached the phone
+func (ec *EnclaveClient) abandonRotation(pairingSecret *kr.PairingSecret) {
+	ec.Lock()
+	defer ec.Unlock()
+	delete(ec.pairingRotations, string(pairingSecret.WorkstationPublicKey))
+	ec.unpair(pairingSecret, false)
+}
+
+//	Remove the pairing replaced by pairingSecret, now that it has completed.
+//	Must be called with ec locked
+func (ec *EnclaveClient) completeRotation(pairingSecret *kr.PairingSecret) {
+	rotation, ok := ec.pairingRotations[string(pairingSecret.WorkstationPublicKey)]
+	if !ok {
+		return
+	}
+	delete(ec.pairingRotations, string(pairingSecret.WorkstationPublicKey))
+	ec.log.Notice("pairing rotation completed")
+	ec.unpair(rotation.previous, !rotation.phoneConfirmed)
+}
@@ -0,0 +1,21 @@
+package krd
+
+import (
+	"fmt"
+
+	"github.com/kryptco/kr"
+)
+
+//	Fail a signature signed with a different algorithm than requested, since
+//	servers that reject ssh-rsa would otherwise reject the signature.
+//	Responses that omit the algorithm come from phones that predate
+//	algorithm negotiation and are accepted.
+func checkSignatureAlgorithm(signRequest kr.SignRequest, signResponse *kr.SignResponse) (err error) {
+	if signRequest.Algorithm == nil || signResponse == nil || signResponse.Signature == nil || signResponse.Algorithm == nil {
+		return
+	}
+	if *signResponse.Algorithm != *signRequest.Algorithm {
+		err = &ProtoError{fmt.Errorf("requested signature algorithm %s, phone signed with %s", *signRequest.Algorithm, *signResponse.Algorithm)}
+	}
+	return
+}
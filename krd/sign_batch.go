@@ -0,0 +1,111 @@
+package krd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	Sign several requests with a single approval on the phone, e.g. when
+//	cloning many repositories. Responses are in the order of signRequests and
+//	each carries its own error if that request failed; err is set only if the
+//	whole batch failed. Phones predating batch signing are asked for each
+//	signature in turn.
+func (client *EnclaveClient) RequestSignatureBatch(signRequests []kr.SignRequest) (signResponses []*kr.SignResponse, err error) {
+	if len(signRequests) == 0 {
+		return
+	}
+	if !client.phonesSupport(kr.ENCLAVE_VERSION_SUPPORTS_SIGN_BATCH) {
+		client.log.Notice("phone predates batch signing, requesting signatures one at a time")
+		//	denied as a whole, as a batch would be
+		batch := kr.Request{SignBatchRequest: &kr.SignBatchRequest{Requests: signRequests}}
+		if err = client.prepareSignBatch(&batch); err != nil {
+			return
+		}
+		for _, signRequest := range batch.SignBatchRequest.Requests {
+			signResponse, _, signErr := client.RequestSignature(signRequest, nil)
+			if signErr != nil {
+				errStr := signErr.Error()
+				signResponse = &kr.SignResponse{Error: &errStr}
+			}
+			signResponses = append(signResponses, signResponse)
+		}
+		return
+	}
+	request, err := kr.NewRequest()
+	if err != nil {
+		client.log.Error(err)
+		return
+	}
+	request.SignBatchRequest = &kr.SignBatchRequest{Requests: signRequests}
+	timeout := request.RequestParameters(client.getTimeouts()).Timeout
+	response, err := client.requestGenericWithTimeouts(context.Background(), request, timeout, nil)
+	if err != nil {
+		return
+	}
+	for i := range response.SignBatchResponse.Responses {
+		signResponses = append(signResponses, &response.SignBatchResponse.Responses[i])
+	}
+	return
+}
+
+//	Select the signing key of, and check the host policy for, each request of
+//	a batch as for a single signature. The whole batch is rejected if any of
+//	its requests is. The batch is copied rather than changed in place.
+func (client *EnclaveClient) prepareSignBatch(request *kr.Request) (err error) {
+	batch := *request.SignBatchRequest
+	batch.Requests = append([]kr.SignRequest{}, batch.Requests...)
+	request.SignBatchRequest = &batch
+	for i := range batch.Requests {
+		signRequest := &batch.Requests[i]
+		if err = client.selectSigningKey(signRequest); err == nil {
+			err = client.checkHostPolicy(*signRequest)
+		}
+		if err != nil {
+			client.recordSignature(newSignatureAuditEntry(kr.Request{RequestID: request.RequestID, SignRequest: signRequest}, kr.Response{}, err))
+			return
+		}
+		signRequest.RememberHost = client.rememberHostPolicy(*signRequest)
+	}
+	return
+}
+
+//	Check each signature of a batch response as a single signature is
+//	checked, replacing those that fail with their error, and audit them. err
+//	is set only if the whole batch failed.
+func (client *EnclaveClient) checkSignBatchResponse(start time.Time, request kr.Request, response kr.Response) (err error) {
+	signRequests := request.SignBatchRequest.Requests
+	batchResponse := response.SignBatchResponse
+	switch {
+	case batchResponse == nil:
+		err = &ProtoError{fmt.Errorf("no batch sign response")}
+	case batchResponse.Error != nil:
+		err = fmt.Errorf("batch sign failed: %s", *batchResponse.Error)
+	case len(batchResponse.Responses) != len(signRequests):
+		err = &ProtoError{fmt.Errorf("batch sign returned %d responses for %d requests", len(batchResponse.Responses), len(signRequests))}
+	}
+	if err != nil {
+		for i := range signRequests {
+			client.recordSignature(newSignatureAuditEntry(kr.Request{RequestID: request.RequestID, SignRequest: &signRequests[i]}, response, err))
+		}
+		return
+	}
+	for i := range batchResponse.Responses {
+		signResponse := &batchResponse.Responses[i]
+		signErr := client.checkSignResponse(signRequests[i], signResponse, response.Version)
+		auditEntry := newSignatureAuditEntry(
+			kr.Request{RequestID: request.RequestID, SignRequest: &signRequests[i]},
+			kr.Response{SignResponse: signResponse},
+			signErr,
+		)
+		client.recordSignature(auditEntry)
+		client.metrics.observeSignature(client.clock.Now().Sub(start), auditEntry.Succeeded)
+		if signErr != nil {
+			errStr := signErr.Error()
+			*signResponse = kr.SignResponse{Error: &errStr}
+		}
+	}
+	return
+}
@@ -0,0 +1,142 @@
+package krd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	How long a completed signature is shared with identical requests
+const SIGN_DEDUP_WINDOW = 5 * time.Second
+
+//	A sign request in flight, shared by identical concurrent requests
+type signFlight struct {
+	done       chan struct{}
+	response   kr.Response
+	err        error
+	finishedAt *time.Time
+	//	whether the phone acknowledged the request, and the onACKs of the
+	//	sender and waiters to call once it does
+	acked  bool
+	onACKs []func()
+}
+
+//	Record the phone's ACK, passing it on to every request sharing the
+//	flight
+func (client *EnclaveClient) ackSignFlight(flight *signFlight) {
+	client.Lock()
+	if flight.acked {
+		client.Unlock()
+		return
+	}
+	flight.acked = true
+	onACKs := flight.onACKs
+	flight.onACKs = nil
+	client.Unlock()
+	for _, onACK := range onACKs {
+		onACK()
+	}
+}
+
+func signRequestDigest(signRequest kr.SignRequest) (digest string, err error) {
+	signRequestJson, err := json.Marshal(signRequest)
+	if err != nil {
+		return
+	}
+	digestBytes := sha256.Sum256(signRequestJson)
+	digest = string(digestBytes[:])
+	return
+}
+
+//	Whether err belongs to the caller that sent the request rather than to
+//	the signature, i.e. its context ended or it was rate limited, so identical
+//	requests waiting on it should send their own instead. Requests canceled
+//	with CancelRequest or CancelAll fail every waiter.
+func isSenderOnlyError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || err == ErrRateLimited
+}
+
+//	Send at most one of a set of identical sign requests to the phone, so
+//	that e.g. parallel git operations only prompt once. Waiters receive the
+//	response of the request that was sent, and onACK is called when the phone
+//	acknowledges it. If the sent request fails for a reason of its own, a
+//	waiter sends in its place.
+func (client *EnclaveClient) dedupSignRequest(ctx context.Context, signRequest kr.SignRequest, onACK func(), send func(onACK func()) (kr.Response, error)) (response kr.Response, err error) {
+	digest, err := signRequestDigest(signRequest)
+	if err != nil {
+		err = &ProtoError{err}
+		return
+	}
+	if onACK != nil {
+		//	a waiter may be told of the ACK of both a flight it gave up on
+		//	and the one it then sent
+		var ackOnce sync.Once
+		ackFunc := onACK
+		onACK = func() {
+			ackOnce.Do(ackFunc)
+		}
+	}
+
+	for {
+		client.Lock()
+		cached, ok := client.signFlights.Get(digest)
+		if !ok {
+			break
+		}
+		flight := cached.(*signFlight)
+		if flight.finishedAt != nil && client.clock.Now().Sub(*flight.finishedAt) >= SIGN_DEDUP_WINDOW {
+			client.signFlights.Remove(digest)
+			break
+		}
+		acked := flight.acked
+		if onACK != nil && !acked {
+			flight.onACKs = append(flight.onACKs, onACK)
+		}
+		client.Unlock()
+		client.log.Notice("sharing response of identical sign request")
+		if onACK != nil && acked {
+			onACK()
+		}
+		select {
+		case <-flight.done:
+		case <-ctx.Done():
+			err = &CanceledError{ctx.Err()}
+			return
+		}
+		if !isSenderOnlyError(flight.err) {
+			response, err = flight.response, flight.err
+			return
+		}
+		client.log.Notice("identical sign request failed on its own account, sending in its place")
+	}
+	flight := &signFlight{
+		done: make(chan struct{}),
+	}
+	if onACK != nil {
+		flight.onACKs = append(flight.onACKs, onACK)
+	}
+	client.signFlights.Add(digest, flight)
+	client.Unlock()
+
+	response, err = send(func() {
+		client.ackSignFlight(flight)
+	})
+
+	client.Lock()
+	finishedAt := client.clock.Now()
+	flight.response, flight.err, flight.finishedAt = response, err, &finishedAt
+	if err != nil {
+		//	only share failures with requests already waiting
+		if cached, ok := client.signFlights.Get(digest); ok && cached.(*signFlight) == flight {
+			client.signFlights.Remove(digest)
+		}
+	}
+	client.Unlock()
+	close(flight.done)
+	return
+}
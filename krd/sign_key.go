@@ -0,0 +1,60 @@
+package krd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+
+	"github.com/kryptco/kr"
+)
+
+var ErrKeyNotFound = errors.New("Phone does not have the requested key")
+
+//	Fill in the paired key, once its profile is cached, when signRequest
+//	names no key. A key other than the paired one must be among the phone's
+//	keys, as last listed by AvailableKeys; the phone itself decides if they
+//	have not been listed.
+func (client *EnclaveClient) selectSigningKey(signRequest *kr.SignRequest) (err error) {
+	me := client.GetCachedMe()
+	if len(signRequest.PublicKeyFingerprint) == 0 {
+		if me != nil {
+			signRequest.PublicKeyFingerprint = me.PublicKeyFingerprint()
+		}
+		return
+	}
+	if me != nil && bytes.Equal(me.PublicKeyFingerprint(), signRequest.PublicKeyFingerprint) {
+		return
+	}
+	if listed, ok := client.isListedKey(signRequest.PublicKeyFingerprint); ok && !listed {
+		err = ErrKeyNotFound
+	}
+	return
+}
+
+//	Whether fingerprint is among the keys last listed by AvailableKeys. ok is
+//	false if they have not been listed or the list has expired.
+func (client *EnclaveClient) isListedKey(fingerprint []byte) (listed bool, ok bool) {
+	client.Lock()
+	defer client.Unlock()
+	if client.availableKeys == nil || client.clock.Now().Sub(client.availableKeysAt) >= AVAILABLE_KEYS_CACHE_TTL {
+		return
+	}
+	ok = true
+	if len(fingerprint) != sha256.Size {
+		return
+	}
+	sshFingerprint := "SHA256:" + base64.RawStdEncoding.EncodeToString(fingerprint)
+	for _, key := range client.availableKeys {
+		if key.Fingerprint == sshFingerprint {
+			listed = true
+			return
+		}
+	}
+	return
+}
+
+//	Whether the phone answered that it does not have the requested key
+func isKeyNotFound(signResponse *kr.SignResponse) bool {
+	return signResponse != nil && signResponse.Error != nil && *signResponse.Error == kr.SIGN_ERROR_UNKNOWN_KEY
+}
@@ -0,0 +1,74 @@
+package krd
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/blang/semver"
+	"github.com/kryptco/kr"
+	"golang.org/x/crypto/ssh"
+)
+
+//	Returned instead of a signature that does not verify against the paired
+//	key, e.g. due to a protocol bug or tampering
+var ErrSignatureInvalid = errors.New("Signature returned by phone is invalid")
+
+//	The SSH payload the phone signed for signRequest, with the public key the
+//	agent stripped restored. ok is false if the data is not a stripped SSH
+//	signature payload.
+func signedPayload(signRequest kr.SignRequest, pk ssh.PublicKey) (payload []byte, ok bool) {
+	stripped := signaturePayloadWithoutPubkey{}
+	if err := ssh.Unmarshal(signRequest.Data, &stripped); err != nil {
+		return
+	}
+	payload = ssh.Marshal(signaturePayload{
+		Session: stripped.Session,
+		Type:    stripped.Type,
+		User:    stripped.User,
+		Service: stripped.Service,
+		Method:  stripped.Method,
+		Sign:    stripped.Sign,
+		Algo:    stripped.Algo,
+		PubKey:  pk.Marshal(),
+	})
+	ok = true
+	return
+}
+
+//	Check a successful sign response from a phone at enclaveVersion against
+//	the cached profile's key and the signed data. Signatures by other keys or
+//	over data other than an SSH signature payload cannot be checked and are
+//	accepted.
+func (client *EnclaveClient) VerifySignResponse(signRequest kr.SignRequest, signResponse *kr.SignResponse, enclaveVersion semver.Version) (err error) {
+	if signResponse == nil || signResponse.Signature == nil || signResponse.Error != nil {
+		return
+	}
+	me := client.GetCachedMe()
+	if me == nil || !bytes.Equal(me.PublicKeyFingerprint(), signRequest.PublicKeyFingerprint) {
+		return
+	}
+	pk, err := me.SSHPublicKey()
+	if err != nil {
+		return
+	}
+	payload, ok := signedPayload(signRequest, pk)
+	if !ok {
+		return
+	}
+	//	as the agent labels the signature; only RSA keys have a choice of
+	//	algorithm
+	format := pk.Type()
+	if format == ssh.KeyAlgoRSA {
+		if signRequest.Algorithm != nil && enclaveVersion.GTE(kr.ENCLAVE_VERSION_SUPPORTS_RSA_SHA2_256_512) {
+			format = *signRequest.Algorithm
+		}
+		if signResponse.Algorithm != nil {
+			format = *signResponse.Algorithm
+		}
+	}
+	if verifyErr := pk.Verify(payload, &ssh.Signature{Format: format, Blob: *signResponse.Signature}); verifyErr != nil {
+		client.log.Error("signature from phone failed verification:", verifyErr)
+		err = ErrSignatureInvalid
+	}
+	return
+}
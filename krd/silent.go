@@ -0,0 +1,36 @@
+package krd
+
+import (
+	"context"
+	"errors"
+)
+
+//	A request made with WithoutWakingPhone found no transport already
+//	connected to the phone
+var ErrNoConnectedTransport = errors.New("No transport connected to the phone")
+
+type silentKey struct{}
+
+//	Requests made with the returned context are sent only over transports
+//	already connected to the phone, i.e. USB or bluetooth, and never push an
+//	alert, so that background requests such as profile refreshes do not buzz
+//	the phone. They fail with ErrNoConnectedTransport if no transport is
+//	connected.
+func WithoutWakingPhone(ctx context.Context) context.Context {
+	return context.WithValue(ctx, silentKey{}, true)
+}
+
+func silentFromContext(ctx context.Context) bool {
+	silent, _ := ctx.Value(silentKey{}).(bool)
+	return silent
+}
+
+//	Transports of the client already connected to the phone
+func (client *EnclaveClient) connectedTransports() (transports []PhoneTransport) {
+	for _, transport := range client.transports {
+		if transport.Connected() {
+			transports = append(transports, transport)
+		}
+	}
+	return
+}
@@ -146,17 +146,23 @@ func (a *Agent) Sign(key ssh.PublicKey, data []byte) (sshSignature *ssh.Signatur
 		Data:                 data,
 		HostAuth:             hostAuth,
 	}
+	if key.Type() == ssh.KeyAlgoRSA && algo != "" {
+		signRequest.Algorithm = &algo
+	}
 	signResponse, enclaveVersion, err := a.client.RequestSignature(signRequest, func() {
 		a.notify(notifyPrefix, notifyPrefix+kr.Yellow("Krypton ▶ Phone approval required. Respond using the Krypton app"))
 	})
 	if err != nil {
 		a.log.Error(err.Error())
-		switch err {
-		case ErrNotPaired:
+		switch {
+		case errors.Is(err, ErrNotPaired):
 			a.notify(notifyPrefix, notifyPrefix+kr.Yellow("Krypton ▶ "+kr.ErrNotPaired.Error()))
-		case ErrTimeout:
+		case err == ErrTimeout:
 			a.notify(notifyPrefix, notifyPrefix+kr.Red("Krypton ▶ "+kr.ErrTimedOut.Error()))
 			a.notify(notifyPrefix, notifyPrefix+kr.Yellow("Krypton ▶ Falling back to local keys."))
+		case err == ErrOffline:
+			a.notify(notifyPrefix, notifyPrefix+kr.Red("Krypton ▶ "+ErrOffline.Error()))
+			a.notify(notifyPrefix, notifyPrefix+kr.Yellow("Krypton ▶ Falling back to local keys."))
 		}
 		return
 	}
@@ -203,6 +209,9 @@ func (a *Agent) Sign(key ssh.PublicKey, data []byte) (sshSignature *ssh.Signatur
 	if enclaveVersion.LT(kr.ENCLAVE_VERSION_SUPPORTS_RSA_SHA2_256_512) {
 		format = key.Type()
 	}
+	if signResponse.Algorithm != nil {
+		format = *signResponse.Algorithm
+	}
 	a.log.Notice("Using Public Key Signature Digest Algorithm: " + format)
 
 	sshSignature = &ssh.Signature{
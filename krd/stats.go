@@ -0,0 +1,45 @@
+package krd
+
+import (
+	"time"
+)
+
+//	Snapshot of how backed up the client is
+type Stats struct {
+	//	Requests awaiting a response from the phone
+	PendingRequests int
+	//	Messages waiting for the phone's key
+	OutgoingQueueDepth int
+	//	Zero until a signature succeeds
+	LastSignatureLatency time.Duration
+	Paired               bool
+	//	Requests given up on without a response since the client started, as
+	//	counted by Metrics.Evicted. A rising timeout count usually means the
+	//	phone is unreachable.
+	TimedOutRequests uint64
+	DroppedRequests  uint64
+	//	Times the phone's wrapped key was unwrapped; LastKeyUnwrap is zero
+	//	until the first
+	KeyUnwraps    uint64
+	LastKeyUnwrap time.Time
+}
+
+//	Consistent snapshot of the client's request and queue state
+func (ec *EnclaveClient) Stats() (stats Stats) {
+	ec.Lock()
+	defer ec.Unlock()
+	stats.PendingRequests = ec.requestCallbacksByRequestID.Len()
+	stats.OutgoingQueueDepth = ec.outgoingQueue.len()
+	stats.LastSignatureLatency = ec.metrics.LastSignatureLatency()
+	stats.TimedOutRequests = ec.metrics.Evicted(EVICTED_TIMEOUT)
+	stats.DroppedRequests = ec.metrics.Evicted(EVICTED_CAPACITY)
+	stats.KeyUnwraps = ec.metrics.KeyUnwraps()
+	stats.LastKeyUnwrap = ec.metrics.LastKeyUnwrap()
+	for _, ps := range ec.pairingSecrets {
+		if ps.IsPaired() {
+			stats.Paired = true
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,40 @@
+package krd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kryptco/kr"
+)
+
+//	Matches, via errors.Is, every ThrottledError
+var ErrThrottled = errors.New("AWS request throttled")
+
+//	Minimum wait after SQS or SNS throttles a request, retrying sooner would
+//	only be throttled again
+const MIN_THROTTLED_BACKOFF = 2 * time.Second
+
+//	SQS or SNS rejected a send or receive for exceeding its rate limit
+type ThrottledError struct {
+	error
+}
+
+func (err *ThrottledError) Error() string {
+	return fmt.Sprintf("Throttled: %s", err.error)
+}
+
+func (err *ThrottledError) Is(target error) bool {
+	return target == ErrThrottled
+}
+
+//	Wrap a transport error from operation, e.g. send, as a ThrottledError if
+//	AWS throttled it or with wrap otherwise
+func (client *EnclaveClient) classifyTransportError(operation string, err error, wrap func(error) error) error {
+	if kr.IsThrottlingError(err) {
+		client.metrics.countThrottled(operation)
+		client.log.Warning(operation, "throttled by AWS:", err)
+		return &ThrottledError{err}
+	}
+	return wrap(err)
+}
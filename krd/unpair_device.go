@@ -0,0 +1,51 @@
+package krd
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/kryptco/kr"
+)
+
+var ErrDeviceNotFound = errors.New("No paired device with that ID")
+
+//	Devices in pairing order
+func (ec *EnclaveClient) PairedDevices() (devices []kr.PairedDevice) {
+	for _, ps := range ec.getPairingSecrets() {
+		id, err := ps.DeriveUUID()
+		if err != nil {
+			ec.log.Error("error deriving pairing UUID:", err)
+			continue
+		}
+		devices = append(devices, kr.PairedDevice{
+			ID:       id.String(),
+			Paired:   ps.IsPaired(),
+			ReadOnly: ps.ReadOnly,
+		})
+	}
+	return
+}
+
+//	Unpair the device with the given ID, as listed by PairedDevices, and ask
+//	the phone to forget the pairing. Other pairings are kept.
+func (ec *EnclaveClient) UnpairDevice(id string) (err error) {
+	ec.Lock()
+	defer ec.Unlock()
+	pairingSecret := ec.findDevice(id)
+	if pairingSecret == nil {
+		err = ErrDeviceNotFound
+		return
+	}
+	err = ec.unpair(pairingSecret, true)
+	return
+}
+
+//	Must be called with ec locked
+func (ec *EnclaveClient) findDevice(id string) *kr.PairingSecret {
+	for _, ps := range ec.pairingSecrets {
+		if psID, err := ps.DeriveUUID(); err == nil && strings.EqualFold(psID.String(), id) {
+			return ps
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package krd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+//	Transport to Android phones attached over USB. adb forwards a local TCP
+//	port to a socket the Krypton app listens on; each message in either
+//	direction is the pairing's service UUID followed by a frame.
+
+const USB = "usb"
+
+const ADB_FORWARD_PORT = 9797
+const ADB_SOCKET_NAME = "localabstract:krypton"
+
+const USB_DIAL_TIMEOUT = 2 * time.Second
+
+//	How often to look for a phone attached over USB while none is connected
+const USB_DETECT_INTERVAL = 5 * time.Second
+const USB_WRITE_TIMEOUT = 5 * time.Second
+
+//	Frames longer than this mean the stream is out of sync
+const MAX_USB_FRAME_SIZE = 1 << 20
+
+const uuidLen = len(uuid.UUID{})
+
+var ErrNoUSBDevice = errors.New("No Android device connected over USB")
+
+//	Connect to the Krypton app on the first device listed by `adb devices`.
+//	Fails with ErrNoUSBDevice when none is attached or adb is not installed.
+func NewUSBDriver() (driver BluetoothDriverI, err error) {
+	output, err := exec.Command("adb", "devices").Output()
+	if err != nil {
+		err = ErrNoUSBDevice
+		return
+	}
+	serials := parseADBDevices(string(output))
+	if len(serials) == 0 {
+		err = ErrNoUSBDevice
+		return
+	}
+	local := fmt.Sprintf("tcp:%d", ADB_FORWARD_PORT)
+	if output, forwardErr := exec.Command("adb", "-s", serials[0], "forward", local, ADB_SOCKET_NAME).CombinedOutput(); forwardErr != nil {
+		err = fmt.Errorf("adb forward failed: %v: %s", forwardErr, strings.TrimSpace(string(output)))
+		return
+	}
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", ADB_FORWARD_PORT), USB_DIAL_TIMEOUT)
+	if err != nil {
+		return
+	}
+	driver = newUSBDriver(conn)
+	return
+}
+
+//	Serials of the devices ready for use in `adb devices` output. Offline and
+//	unauthorized devices are skipped.
+func parseADBDevices(output string) (serials []string) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == "device" {
+			serials = append(serials, fields[0])
+		}
+	}
+	return
+}
+
+//	Implements BluetoothDriverI over a stream connection to the phone
+type usbDriver struct {
+	sync.Mutex
+	conn       net.Conn
+	writeMutex sync.Mutex
+	services   map[uuid.UUID]bool
+	readChan   chan []byte
+}
+
+func newUSBDriver(conn net.Conn) (driver *usbDriver) {
+	driver = &usbDriver{
+		conn:     conn,
+		services: map[uuid.UUID]bool{},
+		readChan: make(chan []byte, 16),
+	}
+	go driver.read()
+	return
+}
+
+func (driver *usbDriver) AddService(serviceUUID uuid.UUID) (err error) {
+	driver.Lock()
+	defer driver.Unlock()
+	driver.services[serviceUUID] = true
+	return
+}
+
+func (driver *usbDriver) RemoveService(serviceUUID uuid.UUID) (err error) {
+	driver.Lock()
+	defer driver.Unlock()
+	delete(driver.services, serviceUUID)
+	return
+}
+
+func (driver *usbDriver) Write(serviceUUID uuid.UUID, data []byte) (err error) {
+	driver.writeMutex.Lock()
+	defer driver.writeMutex.Unlock()
+	err = driver.conn.SetWriteDeadline(time.Now().Add(USB_WRITE_TIMEOUT))
+	if err != nil {
+		return
+	}
+	_, err = driver.conn.Write(append(serviceUUID.Bytes(), encodeFrame(data)...))
+	return
+}
+
+//	Closed when the device disconnects or the driver is stopped
+func (driver *usbDriver) ReadChan() (readChan chan []byte, err error) {
+	readChan = driver.readChan
+	return
+}
+
+func (driver *usbDriver) Stop() {
+	driver.conn.Close()
+}
+
+//	Deliver payloads for added services until the connection fails
+func (driver *usbDriver) read() {
+	defer close(driver.readChan)
+	header := make([]byte, uuidLen+FRAME_HEADER_LEN)
+	for {
+		if _, err := io.ReadFull(driver.conn, header); err != nil {
+			return
+		}
+		serviceUUID, err := uuid.FromBytes(header[:uuidLen])
+		if err != nil {
+			return
+		}
+		frameHeader := header[uuidLen:]
+		length := binary.BigEndian.Uint32(frameHeader[1:])
+		if frameHeader[0] != FRAME_HEADER || length > MAX_USB_FRAME_SIZE {
+			return
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(driver.conn, payload); err != nil {
+			return
+		}
+		driver.Lock()
+		subscribed := driver.services[serviceUUID]
+		driver.Unlock()
+		if subscribed {
+			driver.readChan <- payload
+		}
+	}
+}
+
+//	Connect to a phone attached over USB whenever none is, so a phone plugged
+//	in after Start is picked up. Detection shells out to adb and dials the
+//	forwarded port, so it runs without ec locked. Returns once done is closed.
+func (ec *EnclaveClient) detectUSB(done chan struct{}) {
+	var lastErr string
+	for {
+		ec.Lock()
+		connected := ec.usb != nil
+		ec.Unlock()
+		if !connected {
+			if err := ec.startUSB(done); err != nil && err.Error() != lastErr {
+				lastErr = err.Error()
+				ec.log.Info("USB transport not started:", err)
+			} else if err == nil {
+				lastErr = ""
+			}
+		}
+		select {
+		case <-ec.clock.After(USB_DETECT_INTERVAL):
+		case <-done:
+			return
+		}
+	}
+}
+
+//	Connect to a phone attached over USB, if any, and add every pairing's
+//	service to it
+func (ec *EnclaveClient) startUSB(done chan struct{}) (err error) {
+	usb, err := ec.newUSBDriver()
+	if err != nil {
+		return
+	}
+	ec.Lock()
+	defer ec.Unlock()
+	select {
+	case <-done:
+		usb.Stop()
+		return
+	default:
+	}
+	if ec.usb != nil {
+		usb.Stop()
+		return
+	}
+	ec.log.Notice("connected to phone over USB")
+	ec.usb = usb
+	for _, ps := range ec.pairingSecrets {
+		if serviceUUID, uuidErr := ec.deriveServiceUUID(ps); uuidErr == nil {
+			usb.AddService(serviceUUID)
+		}
+	}
+	go ec.readUSB(usb)
+	return
+}
+
+//	Handle ciphertexts from usb until it disconnects or is stopped
+func (ec *EnclaveClient) readUSB(usb BluetoothDriverI) {
+	readChan, err := usb.ReadChan()
+	if err != nil {
+		ec.log.Error("error retrieving USB read channel:", err)
+		return
+	}
+	for ciphertext := range readChan {
+		if err := ec.handleCiphertext(ciphertext, USB); err != nil && err != ErrForeignCiphertext {
+			ec.log.Error("error reading USB channel:", err)
+		}
+	}
+	ec.Lock()
+	if ec.usb == usb {
+		ec.log.Notice("phone disconnected from USB")
+		ec.usb = nil
+	}
+	ec.Unlock()
+	usb.Stop()
+}
@@ -0,0 +1,48 @@
+package krd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kryptco/kr"
+)
+
+var ErrUnknownPublicKey = errors.New("Public key fingerprint does not match the paired key")
+
+//	Check a SignRequest as the phone would, without sending it. Malformed
+//	requests return a ProtoError.
+func (ec *EnclaveClient) ValidateSignRequest(signRequest kr.SignRequest) (err error) {
+	if len(signRequest.Data) == 0 {
+		err = &ProtoError{fmt.Errorf("sign request data is empty")}
+		return
+	}
+	if len(signRequest.PublicKeyFingerprint) != 0 && len(signRequest.PublicKeyFingerprint) != sha256.Size {
+		err = &ProtoError{fmt.Errorf("public key fingerprint has length %d, expected %d", len(signRequest.PublicKeyFingerprint), sha256.Size)}
+		return
+	}
+	request := kr.Request{SignRequest: &signRequest}
+	if _, marshalErr := json.Marshal(request); marshalErr != nil {
+		err = &ProtoError{marshalErr}
+		return
+	}
+	if !ec.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+	me := ec.GetCachedMe()
+	if me == nil {
+		err = ErrNoCachedMe
+		return
+	}
+	if len(signRequest.PublicKeyFingerprint) == 0 || bytes.Equal(me.PublicKeyFingerprint(), signRequest.PublicKeyFingerprint) {
+		return
+	}
+	if listed, _ := ec.isListedKey(signRequest.PublicKeyFingerprint); !listed {
+		err = ErrUnknownPublicKey
+		return
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package krd
+
+import (
+	"context"
+
+	"github.com/kryptco/kr"
+)
+
+//	Block until a profile is available, whether already cached, from a me
+//	response or once the phone's key is unwrapped, or until ctx is done.
+//	Waits on the pairing event stream, rechecking the cache with backoff in
+//	case events were dropped. Sends no requests itself.
+func (ec *EnclaveClient) WaitForMe(ctx context.Context) (me *kr.Profile, err error) {
+	events := ec.Subscribe()
+	defer ec.Unsubscribe(events)
+	backoff := MIN_RECEIVE_BACKOFF
+	for {
+		if me = ec.GetCachedMe(); me != nil {
+			return
+		}
+		select {
+		case event, ok := <-events:
+			if !ok {
+				err = ErrClosed
+				return
+			}
+			if event.Type == PairingEventMeUpdated && event.Profile != nil {
+				me = event.Profile
+				return
+			}
+		case <-ec.clock.After(backoff):
+			backoff *= 2
+			if backoff > MAX_RECEIVE_BACKOFF {
+				backoff = MAX_RECEIVE_BACKOFF
+			}
+		case <-ctx.Done():
+			err = &CanceledError{ctx.Err()}
+			return
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package krd
+
+import (
+	"context"
+)
+
+//	How recently the phone must have sent a message for Warmup to consider
+//	the connection warm
+const WARM_WINDOW = BLUETOOTH_CONNECTED_WINDOW
+
+//	Prime the connection to the phone so the next signature request is fast,
+//	e.g. when the user focuses a terminal: a silent me request wakes the
+//	phone and brings up its bluetooth connection. Returns immediately if the
+//	phone sent a message within WARM_WINDOW. Canceling ctx abandons the
+//	warmup.
+func (ec *EnclaveClient) Warmup(ctx context.Context) (err error) {
+	if !ec.IsPaired() {
+		err = ErrNotPaired
+		return
+	}
+	if ec.isWarm() {
+		return
+	}
+	_, err = ec.pingCtx(ctx)
+	return
+}
+
+func (ec *EnclaveClient) isWarm() bool {
+	ec.Lock()
+	defer ec.Unlock()
+	for _, lastActivity := range ec.lastActivityByMedium {
+		if ec.clock.Now().Sub(lastActivity) < WARM_WINDOW {
+			return true
+		}
+	}
+	return false
+}
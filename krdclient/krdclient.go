@@ -13,6 +13,8 @@ import (
 	"github.com/kryptco/kr"
 )
 
+var ErrStatusUnsupported = fmt.Errorf("Krypton daemon does not support status, update it with \"kr upgrade\".")
+
 var ErrOldKrdRunning = fmt.Errorf(kr.Red("An old version of krd is still running. Please run " + kr.Cyan("kr restart") + kr.Red(" and try again.")))
 
 func IsLatestKrdRunning() (isRunning bool, err error) {
@@ -156,6 +158,87 @@ func RequestMe() (me kr.Profile, err error) {
 	return
 }
 
+//	Request the daemon's pairing and request state over an open daemon connection
+func RequestStatusOver(conn net.Conn) (status kr.DaemonStatus, err error) {
+	statusRequest, err := http.NewRequest("GET", "/status", nil)
+	if err != nil {
+		return
+	}
+	err = statusRequest.Write(conn)
+	if err != nil {
+		err = kr.ErrConnectingToDaemon
+		return
+	}
+	statusResponse, err := http.ReadResponse(bufio.NewReader(conn), statusRequest)
+	if err != nil {
+		err = kr.ErrConnectingToDaemon
+		return
+	}
+	defer statusResponse.Body.Close()
+	if statusResponse.StatusCode != http.StatusOK {
+		err = ErrStatusUnsupported
+		return
+	}
+	err = json.NewDecoder(statusResponse.Body).Decode(&status)
+	return
+}
+
+func RequestStatus() (status kr.DaemonStatus, err error) {
+	unixFile, err := kr.KrDirFile(kr.DAEMON_SOCKET_FILENAME)
+	if err != nil {
+		err = kr.ErrConnectingToDaemon
+		return
+	}
+	daemonConn, err := kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		err = kr.ErrConnectingToDaemon
+		return
+	}
+	defer daemonConn.Close()
+	status, err = RequestStatusOver(daemonConn)
+	return
+}
+
+//	Request the profiles of every key on the phone over an open daemon connection
+func RequestListOver(conn net.Conn) (keys []kr.Profile, err error) {
+	listRequest, err := kr.NewRequest()
+	if err != nil {
+		return
+	}
+	listRequest.ListRequest = &kr.ListRequest{}
+
+	response, err := makeRequestWithJsonResponse(conn, listRequest)
+	if err != nil {
+		return
+	}
+	if response.ListResponse == nil {
+		err = fmt.Errorf("Response missing key list")
+		return
+	}
+	if response.ListResponse.Error != nil {
+		err = fmt.Errorf("Error listing keys: %s", *response.ListResponse.Error)
+		return
+	}
+	keys = response.ListResponse.Keys
+	return
+}
+
+func RequestList() (keys []kr.Profile, err error) {
+	unixFile, err := kr.KrDirFile(kr.DAEMON_SOCKET_FILENAME)
+	if err != nil {
+		err = kr.ErrConnectingToDaemon
+		return
+	}
+	daemonConn, err := kr.DaemonDialWithTimeout(unixFile)
+	if err != nil {
+		err = kr.ErrConnectingToDaemon
+		return
+	}
+	defer daemonConn.Close()
+	keys, err = RequestListOver(daemonConn)
+	return
+}
+
 func RequestGitSignatureOver(request kr.Request, conn net.Conn) (response kr.Response, err error) {
 	response, err = makeRequestWithJsonResponse(conn, request)
 	if err != nil {
@@ -268,7 +351,8 @@ func makeRequestWithJsonResponse(conn net.Conn, request kr.Request) (response kr
 	return
 }
 
-func signOver(conn net.Conn, pkFingerprint []byte, data []byte) (signature []byte, err error) {
+//	Request a signature of data by the key with pkFingerprint over an open daemon connection
+func SignOver(conn net.Conn, pkFingerprint []byte, data []byte) (signature []byte, err error) {
 	signRequest, err := kr.NewRequest()
 	if err != nil {
 		return
@@ -344,7 +428,7 @@ func Sign(pkFingerprint []byte, data []byte) (signature []byte, err error) {
 		return
 	}
 	defer daemonConn.Close()
-	return signOver(daemonConn, pkFingerprint, data)
+	return SignOver(daemonConn, pkFingerprint, data)
 }
 
 func requestNoOpOver(conn net.Conn) (err error) {
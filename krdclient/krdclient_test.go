@@ -1,6 +1,7 @@
 package krdclient
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"net"
 	"os"
@@ -65,12 +66,53 @@ func TestSign(t *testing.T) {
 	testMe, _, _ := kr.TestMe(t)
 
 	digest := sha256.Sum256([]byte{0})
-	_, err = signOver(conn, testMe.PublicKeyFingerprint(), digest[:])
+	_, err = SignOver(conn, testMe.PublicKeyFingerprint(), digest[:])
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func TestStatus(t *testing.T) {
+	ec, _, unixFile := krd.NewLocalUnixServer(t)
+	krd.PairClient(t, ec)
+	defer ec.Stop()
+
+	conn, err := net.Dial("unix", unixFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(unixFile)
+
+	status, err := RequestStatusOver(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Paired {
+		t.Fatal("expected paired status")
+	}
+}
+
+func TestList(t *testing.T) {
+	ec, _, unixFile := krd.NewLocalUnixServer(t)
+	krd.PairClient(t, ec)
+	defer ec.Stop()
+
+	conn, err := net.Dial("unix", unixFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(unixFile)
+
+	keys, err := RequestListOver(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMe, _, _ := kr.TestMe(t)
+	if len(keys) != 1 || !bytes.Equal(keys[0].SSHWirePublicKey, testMe.SSHWirePublicKey) {
+		t.Fatal("expected the paired key listed, got", keys)
+	}
+}
+
 func TestNoOp(t *testing.T) {
 	ec, _, unixFile := krd.NewLocalUnixServer(t)
 	krd.PairClient(t, ec)
@@ -8,7 +8,36 @@ import (
 	"github.com/op/go-logging"
 )
 
-var log = logging.MustGetLogger("")
+//	The logging methods used by kr and krd, satisfied by *logging.Logger, so
+//	that embedding applications can route logging into their own logger
+type Logger interface {
+	Error(args ...interface{})
+	Warning(args ...interface{})
+	Notice(args ...interface{})
+	Info(args ...interface{})
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Error(args ...interface{})   {}
+func (discardLogger) Warning(args ...interface{}) {}
+func (discardLogger) Notice(args ...interface{})  {}
+func (discardLogger) Info(args ...interface{})    {}
+
+//	Drops everything logged to it
+var DiscardLogger Logger = discardLogger{}
+
+var defaultLogger = logging.MustGetLogger("")
+var log Logger = defaultLogger
+
+//	Route the kr package's logging to logger, or discard it if logger is nil
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = DiscardLogger
+	}
+	log = logger
+}
+
 var syslogFormat = logging.MustStringFormatter(
 	`%{time:15:04:05.000} %{level:.6s} ▶ %{message}`,
 )
@@ -71,5 +100,5 @@ func SetupLogging(prefix string, defaultLogLevel logging.Level, trySyslog bool)
 	}
 
 	logging.SetBackend(leveled)
-	return log
+	return defaultLogger
 }
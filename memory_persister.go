@@ -7,8 +7,10 @@ import (
 
 type MemoryPersister struct {
 	sync.Mutex
-	me      *Profile
-	pairing *PairingSecret
+	me       *Profile
+	pairings []*PairingSecret
+	queue    []QueuedMessage
+	hosts    *HostApprovals
 }
 
 func (mp *MemoryPersister) SaveMe(me Profile) (err error) {
@@ -36,25 +38,69 @@ func (mp *MemoryPersister) DeleteMe() (err error) {
 func (mp *MemoryPersister) SaveMySSHPubKey(me Profile) (err error) {
 	return
 }
-func (mp *MemoryPersister) LoadPairing() (pairingSecret *PairingSecret, err error) {
+func (mp *MemoryPersister) LoadPairings() (pairingSecrets []*PairingSecret, err error) {
 	mp.Lock()
 	defer mp.Unlock()
-	if mp.pairing == nil {
+	if len(mp.pairings) == 0 {
 		err = fmt.Errorf("no pairing saved")
 		return
 	}
-	pairingSecret = mp.pairing
+	pairingSecrets = append(pairingSecrets, mp.pairings...)
 	return
 }
-func (mp *MemoryPersister) SavePairing(pairingSecret *PairingSecret) (err error) {
+func (mp *MemoryPersister) SavePairings(pairingSecrets []*PairingSecret) (err error) {
 	mp.Lock()
 	defer mp.Unlock()
-	mp.pairing = pairingSecret
+	mp.pairings = append([]*PairingSecret{}, pairingSecrets...)
 	return
 }
-func (mp *MemoryPersister) DeletePairing() (pairingSecret *PairingSecret, err error) {
+func (mp *MemoryPersister) DeletePairings() (err error) {
 	mp.Lock()
 	defer mp.Unlock()
-	mp.pairing = nil
+	mp.pairings = nil
+	return
+}
+func (mp *MemoryPersister) LoadQueuedMessages() (queue []QueuedMessage, err error) {
+	mp.Lock()
+	defer mp.Unlock()
+	queue = append(queue, mp.queue...)
+	return
+}
+func (mp *MemoryPersister) SaveQueuedMessages(queue []QueuedMessage) (err error) {
+	mp.Lock()
+	defer mp.Unlock()
+	mp.queue = append([]QueuedMessage{}, queue...)
+	return
+}
+func (mp *MemoryPersister) LoadHostApprovals() (approvals HostApprovals, err error) {
+	mp.Lock()
+	defer mp.Unlock()
+	if mp.hosts == nil {
+		err = fmt.Errorf("no host approvals saved")
+		return
+	}
+	approvals = copyHostApprovals(*mp.hosts)
+	return
+}
+func (mp *MemoryPersister) SaveHostApprovals(approvals HostApprovals) (err error) {
+	mp.Lock()
+	defer mp.Unlock()
+	approvals = copyHostApprovals(approvals)
+	mp.hosts = &approvals
+	return
+}
+
+func copyHostApprovals(approvals HostApprovals) (copied HostApprovals) {
+	copied = NewHostApprovals()
+	for host, approval := range approvals.Approved {
+		copied.Approved[host] = approval
+	}
+	for host, forgotten := range approvals.Forgotten {
+		copied.Forgotten[host] = forgotten
+	}
+	if approvals.AllForgotten != nil {
+		allForgotten := *approvals.AllForgotten
+		copied.AllForgotten = &allForgotten
+	}
 	return
 }
@@ -3,9 +3,11 @@ package kr
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/satori/go.uuid"
 )
@@ -21,12 +23,24 @@ type PairingSecret struct {
 	WorkstationName      string `json:"n"`
 	snsEndpointARN       *string
 	trackingID           *string
+	createdAt            *time.Time
 	Version              string `json:"v"`
+	//	Observer pairing that may read the profile and key list but not
+	//	request signatures
+	ReadOnly bool `json:"ro,omitempty"`
+	//	self-hosted relay the pairing's queues live on, nil for the hosted one
+	Relay *RelayConfig `json:"relay,omitempty"`
 	sync.Mutex
 }
 
 type PairingOptions struct {
 	WorkstationName *string `json:"name"`
+	ReadOnly        bool    `json:"read_only,omitempty"`
+	//	relay for the new pairing, overriding the daemon's
+	Relay *RelayConfig `json:"relay,omitempty"`
+	//	pair an additional phone, keeping existing completed pairings
+	//	rather than replacing them
+	Add bool `json:"add,omitempty"`
 }
 
 func (ps *PairingSecret) Equals(other *PairingSecret) bool {
@@ -66,6 +80,20 @@ func GeneratePairingSecret(workstationName *string) (ps *PairingSecret, err erro
 		ps.WorkstationName = *workstationName
 	}
 	ps.Version = CURRENT_VERSION.String()
+	now := time.Now()
+	ps.createdAt = &now
+	return
+}
+
+//	When the pairing was generated. ok is false for pairings saved before
+//	this was recorded.
+func (ps *PairingSecret) PairedSince() (since time.Time, ok bool) {
+	ps.Lock()
+	defer ps.Unlock()
+	if ps.createdAt == nil {
+		return
+	}
+	since, ok = *ps.createdAt, true
 	return
 }
 
@@ -140,6 +168,19 @@ func (ps *PairingSecret) SetSNSEndpointARN(arn *string) {
 	ps.snsEndpointARN = arn
 }
 
+//	Set the SNS endpoint ARN unless it is unchanged, reporting whether it was
+//	set
+func (ps *PairingSecret) UpdateSNSEndpointARN(arn *string) (changed bool) {
+	ps.Lock()
+	defer ps.Unlock()
+	if arn == nil || (ps.snsEndpointARN != nil && *ps.snsEndpointARN == *arn) {
+		return
+	}
+	ps.snsEndpointARN = arn
+	changed = true
+	return
+}
+
 func (ps *PairingSecret) GetSNSEndpointARN() (arn *string) {
 	ps.Lock()
 	defer ps.Unlock()
@@ -164,6 +205,21 @@ func (ps *PairingSecret) IsPaired() bool {
 	return ps.EnclavePublicKey != nil
 }
 
+//	Short identifier of the paired phone, derived from its public key. A new
+//	pairing, e.g. with another phone, gets a new fingerprint. Returns
+//	ErrWaitingForKey until the phone has scanned the pairing.
+func (ps *PairingSecret) Fingerprint() (fingerprint string, err error) {
+	ps.Lock()
+	defer ps.Unlock()
+	if ps.EnclavePublicKey == nil {
+		err = ErrWaitingForKey
+		return
+	}
+	keyDigest := sha256.Sum256(*ps.EnclavePublicKey)
+	fingerprint = hex.EncodeToString(keyDigest[:8])
+	return
+}
+
 func (ps *PairingSecret) DisplayName() string {
 	return strings.TrimSuffix(ps.WorkstationName, ".local")
 }
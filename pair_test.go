@@ -74,3 +74,47 @@ func TestGenWrapEncDec(t *testing.T) {
 		t.Fatal("decrypt failed")
 	}
 }
+
+func TestUpdateSNSEndpointARN(t *testing.T) {
+	ps, err := GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arn := "arn:aws:sns:us-east-1:0:endpoint/APNS/kr/0"
+	if !ps.UpdateSNSEndpointARN(&arn) {
+		t.Fatal("expected new ARN to be set")
+	}
+	sameArn := arn
+	if ps.UpdateSNSEndpointARN(&sameArn) {
+		t.Fatal("expected unchanged ARN to be skipped")
+	}
+	if ps.UpdateSNSEndpointARN(nil) {
+		t.Fatal("expected missing ARN to be skipped")
+	}
+	otherArn := arn + "1"
+	if !ps.UpdateSNSEndpointARN(&otherArn) || *ps.GetSNSEndpointARN() != otherArn {
+		t.Fatal("expected changed ARN to be set")
+	}
+}
+
+func TestPairingSecretFingerprint(t *testing.T) {
+	ps, err := GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.Fingerprint(); err != ErrWaitingForKey {
+		t.Fatal("expected ErrWaitingForKey before the phone's key, got", err)
+	}
+	enclavePublicKey, err := RandNBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.EnclavePublicKey = &enclavePublicKey
+	fingerprint, err := ps.Fingerprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := ps.Fingerprint(); len(fingerprint) != 16 || again != fingerprint {
+		t.Fatal("expected a stable 16 character fingerprint, got", fingerprint, again)
+	}
+}
@@ -0,0 +1,117 @@
+package kr
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+var ErrPassphraseRequired = fmt.Errorf("exported pairing is encrypted, passphrase required")
+var ErrBadPassphrase = fmt.Errorf("incorrect passphrase or corrupted pairing export")
+
+const PAIRING_EXPORT_KDF_ITERATIONS = 100000
+
+//	Exports asking for more iterations are rejected rather than tying up
+//	the importer
+const PAIRING_EXPORT_MAX_KDF_ITERATIONS = 10 * PAIRING_EXPORT_KDF_ITERATIONS
+
+//	Either Pairing is set, or the persisted pairing JSON is sealed in Sealed
+//	with a key derived from a passphrase
+type pairingExport struct {
+	Pairing    *persistedPairing `json:"pairing,omitempty"`
+	Salt       []byte            `json:"salt,omitempty"`
+	Iterations int               `json:"iterations,omitempty"`
+	Sealed     []byte            `json:"sealed,omitempty"`
+}
+
+//	PBKDF2 with HMAC-SHA256, for a single 32 byte block
+func passphraseKey(passphrase string, salt []byte, iterations int) (key [32]byte) {
+	mac := hmac.New(sha256.New, []byte(passphrase))
+	mac.Write(salt)
+	var blockIndex [4]byte
+	binary.BigEndian.PutUint32(blockIndex[:], 1)
+	mac.Write(blockIndex[:])
+	u := mac.Sum(nil)
+	copy(key[:], u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return
+}
+
+//	Serialize a pairing, including its secret key, so it can be installed on
+//	another workstation. An empty passphrase leaves the export unencrypted.
+func ExportPairingSecret(ps *PairingSecret, passphrase string) (data []byte, err error) {
+	ps.Lock()
+	persisted := pairingToPersisted(ps)
+	ps.Unlock()
+	if passphrase == "" {
+		data, err = json.Marshal(pairingExport{Pairing: &persisted})
+		return
+	}
+	pairingJson, err := json.Marshal(persisted)
+	if err != nil {
+		return
+	}
+	salt, err := RandNBytes(16)
+	if err != nil {
+		return
+	}
+	var nonce [24]byte
+	_, err = rand.Read(nonce[:])
+	if err != nil {
+		return
+	}
+	key := passphraseKey(passphrase, salt, PAIRING_EXPORT_KDF_ITERATIONS)
+	data, err = json.Marshal(pairingExport{
+		Salt:       salt,
+		Iterations: PAIRING_EXPORT_KDF_ITERATIONS,
+		Sealed:     secretbox.Seal(nonce[:], pairingJson, &nonce, &key),
+	})
+	return
+}
+
+func ImportPairingSecret(data []byte, passphrase string) (ps *PairingSecret, err error) {
+	var export pairingExport
+	err = json.Unmarshal(data, &export)
+	if err != nil {
+		return
+	}
+	if export.Pairing != nil {
+		ps = pairingFromPersisted(export.Pairing)
+		return
+	}
+	if passphrase == "" {
+		err = ErrPassphraseRequired
+		return
+	}
+	if len(export.Sealed) < 24 || export.Iterations < 1 || export.Iterations > PAIRING_EXPORT_MAX_KDF_ITERATIONS {
+		err = ErrBadPassphrase
+		return
+	}
+	var nonce [24]byte
+	copy(nonce[:], export.Sealed[:24])
+	key := passphraseKey(passphrase, export.Salt, export.Iterations)
+	pairingJson, ok := secretbox.Open(nil, export.Sealed[24:], &nonce, &key)
+	if !ok {
+		err = ErrBadPassphrase
+		return
+	}
+	var persisted persistedPairing
+	err = json.Unmarshal(pairingJson, &persisted)
+	if err != nil {
+		return
+	}
+	ps = pairingFromPersisted(&persisted)
+	return
+}
@@ -0,0 +1,70 @@
+package kr
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestPassphraseKey(t *testing.T) {
+	//	PBKDF2-HMAC-SHA256 test vector from RFC 7914
+	key := passphraseKey("passwd", []byte("salt"), 1)
+	if hex.EncodeToString(key[:]) != "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" {
+		t.Fatal("unexpected key", hex.EncodeToString(key[:]))
+	}
+	for iterations, expected := range map[int]string{
+		2:    "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+		4096: "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a",
+	} {
+		key := passphraseKey("password", []byte("salt"), iterations)
+		if hex.EncodeToString(key[:]) != expected {
+			t.Fatal("unexpected key after", iterations, "iterations", hex.EncodeToString(key[:]))
+		}
+	}
+}
+
+func TestExportImportPairingSecret(t *testing.T) {
+	pairing, err := GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arn := "arn"
+	pairing.SetSNSEndpointARN(&arn)
+
+	for _, passphrase := range []string{"", "correct horse"} {
+		data, err := ExportPairingSecret(pairing, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, err := ImportPairingSecret(data, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pairing.Equals(imported) || imported.GetSNSEndpointARN() == nil || *imported.GetSNSEndpointARN() != arn {
+			t.Fatal("imported pairing differs")
+		}
+	}
+
+	data, err := ExportPairingSecret(pairing, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ImportPairingSecret(data, ""); err != ErrPassphraseRequired {
+		t.Fatal("expected passphrase required, got", err)
+	}
+	if _, err = ImportPairingSecret(data, "wrong"); err != ErrBadPassphrase {
+		t.Fatal("expected bad passphrase, got", err)
+	}
+
+	var export pairingExport
+	if err = json.Unmarshal(data, &export); err != nil {
+		t.Fatal(err)
+	}
+	export.Iterations = PAIRING_EXPORT_MAX_KDF_ITERATIONS + 1
+	if data, err = json.Marshal(export); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ImportPairingSecret(data, "correct horse"); err != ErrBadPassphrase {
+		t.Fatal("expected too many iterations rejected, got", err)
+	}
+}
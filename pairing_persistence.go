@@ -1,8 +1,20 @@
 package kr
 
+import (
+	"time"
+)
+
 const PAIRING_FILENAME = "pairing.json"
+
+//	Copy of the last pairing file written successfully, used if the pairing
+//	file is corrupt
+const PAIRING_BACKUP_FILENAME = "pairing.json.bak"
 const ID_KRYPTON_FILENAME = "id_krypton.pub"
 
+//	Requests queued for a pairing to complete, as plaintext JSON
+const OUTGOING_QUEUE_FILENAME = "outgoing_queue.json"
+const HOST_APPROVALS_FILENAME = "host_approvals.json"
+
 const PAIRING_TRANSFER_OLD_FILENAME = "pairing_transfer_old.json"
 const PAIRING_TRANSFER_NEW_FILENAME = "pairing_transfer_new.json"
 
@@ -13,6 +25,9 @@ type persistedPairing struct {
 	WorkstationName      string
 	SNSEndpointARN       *string
 	TrackingID           *string
+	ReadOnly             bool
+	Relay                *RelayConfig `json:",omitempty"`
+	CreatedAt            *time.Time   `json:",omitempty"`
 }
 
 func pairingToPersisted(ps *PairingSecret) persistedPairing {
@@ -23,6 +38,9 @@ func pairingToPersisted(ps *PairingSecret) persistedPairing {
 		WorkstationName:      ps.WorkstationName,
 		SNSEndpointARN:       ps.snsEndpointARN,
 		TrackingID:           ps.trackingID,
+		ReadOnly:             ps.ReadOnly,
+		Relay:                ps.Relay,
+		CreatedAt:            ps.createdAt,
 	}
 }
 
@@ -34,5 +52,8 @@ func pairingFromPersisted(pp *persistedPairing) *PairingSecret {
 		WorkstationName:      pp.WorkstationName,
 		snsEndpointARN:       pp.SNSEndpointARN,
 		trackingID:           pp.TrackingID,
+		ReadOnly:             pp.ReadOnly,
+		Relay:                pp.Relay,
+		createdAt:            pp.CreatedAt,
 	}
 }
@@ -1,6 +1,10 @@
 package kr
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -15,4 +19,138 @@ func TestPairingPersistence(t *testing.T) {
 	if !pairing.Equals(pairing2) {
 		t.Fatal()
 	}
+	since, ok := pairing.PairedSince()
+	if restored, restoredOK := pairing2.PairedSince(); !ok || !restoredOK || !restored.Equal(since) {
+		t.Fatal("expected the pairing time persisted")
+	}
+
+	persisted.CreatedAt = nil
+	if _, ok := pairingFromPersisted(&persisted).PairedSince(); ok {
+		t.Fatal("expected no pairing time for a pairing saved without one")
+	}
+}
+
+func TestPairingDirOverride(t *testing.T) {
+	dirA, err := ioutil.TempDir("", "kr-pairing-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirA)
+	dirB, err := ioutil.TempDir("", "kr-pairing-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirB)
+
+	os.Setenv(PAIRING_DIR_ENV, dirA)
+	defer os.Unsetenv(PAIRING_DIR_ENV)
+	pairingDir, err := PairingDir()
+	if err != nil || pairingDir != dirA {
+		t.Fatal("expected pairing dir override, got", pairingDir, err)
+	}
+
+	pairingA, err := GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairingB, err := GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = (FilePersister{PairingDir: dirA}).SavePairings([]*PairingSecret{pairingA}); err != nil {
+		t.Fatal(err)
+	}
+	if err = (FilePersister{PairingDir: dirB}).SavePairings([]*PairingSecret{pairingB}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := FilePersister{PairingDir: dirA}.LoadPairings()
+	if err != nil || len(loaded) != 1 || !loaded[0].Equals(pairingA) {
+		t.Fatal("pairing clobbered by another pairing dir", err)
+	}
+}
+
+func TestSinglePairingSavedAsArray(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kr-pairing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pairing, err := GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = (FilePersister{PairingDir: dir}).SavePairings([]*PairingSecret{pairing}); err != nil {
+		t.Fatal(err)
+	}
+	pairingJson, err := ioutil.ReadFile(filepath.Join(dir, PAIRING_FILENAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pps []persistedPairing
+	if err = json.Unmarshal(pairingJson, &pps); err != nil || len(pps) != 1 {
+		t.Fatal("expected an array of one pairing, got", string(pairingJson))
+	}
+}
+
+func TestCorruptPairingLoadsBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kr-pairing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	persister := FilePersister{PairingDir: dir}
+
+	pairing, err := GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = persister.SavePairings([]*PairingSecret{pairing}); err != nil {
+		t.Fatal(err)
+	}
+	//	simulate a write interrupted by a crash
+	if err = ioutil.WriteFile(filepath.Join(dir, PAIRING_FILENAME), []byte(`{"Workstat`), 0700); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := persister.LoadPairings()
+	if err != nil || len(loaded) != 1 || !loaded[0].Equals(pairing) {
+		t.Fatal("expected backup pairing to load", err)
+	}
+
+	if err = persister.DeletePairings(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = persister.LoadPairings(); err == nil {
+		t.Fatal("expected deleted pairing not to be restored from backup")
+	}
+}
+
+func TestQueuedMessagesPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kr-pairing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	persister := FilePersister{PairingDir: dir}
+
+	queue := []QueuedMessage{{Message: []byte("request"), RequestID: "id"}}
+	if err = persister.SaveQueuedMessages(queue); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := persister.LoadQueuedMessages()
+	if err != nil || len(loaded) != 1 || loaded[0].RequestID != "id" {
+		t.Fatal("expected the queue to round trip", err)
+	}
+	//	written through a temporary file renamed into place
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 || files[0].Name() != OUTGOING_QUEUE_FILENAME {
+		t.Fatal("expected only the queue file, got", files, err)
+	}
+
+	if err = persister.SaveQueuedMessages(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = persister.LoadQueuedMessages(); !os.IsNotExist(err) {
+		t.Fatal("expected an empty queue to remove the file, got", err)
+	}
 }
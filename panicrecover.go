@@ -3,11 +3,9 @@ package kr
 import (
 	"fmt"
 	"runtime/debug"
-
-	"github.com/op/go-logging"
 )
 
-func RecoverToLog(f func(), log *logging.Logger) {
+func RecoverToLog(f func(), log Logger) {
 	defer func() {
 		if x := recover(); x != nil {
 			if log != nil {
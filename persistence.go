@@ -6,7 +6,13 @@ type Persister interface {
 	DeleteMe() (err error)
 	SaveMySSHPubKey(me Profile) (err error)
 
-	LoadPairing() (pairingSecret *PairingSecret, err error)
-	SavePairing(pairingSecret *PairingSecret) (err error)
-	DeletePairing() (pairingSecret *PairingSecret, err error)
+	LoadPairings() (pairingSecrets []*PairingSecret, err error)
+	SavePairings(pairingSecrets []*PairingSecret) (err error)
+	DeletePairings() (err error)
+
+	LoadQueuedMessages() (queue []QueuedMessage, err error)
+	SaveQueuedMessages(queue []QueuedMessage) (err error)
+
+	LoadHostApprovals() (approvals HostApprovals, err error)
+	SaveHostApprovals(approvals HostApprovals) (err error)
 }
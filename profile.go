@@ -60,6 +60,25 @@ func (p Profile) Equal(other Profile) bool {
 	return bytes.Equal(p.SSHWirePublicKey, other.SSHWirePublicKey) && p.Email == other.Email
 }
 
+//	The profile after a MeResponse carrying update. The SSH key and email
+//	are authoritative and taken from every response. The PGP key and team
+//	checkpoint are optional: the phone may omit them, so they are kept from p
+//	unless update sets them. A different SSH key is a different identity,
+//	and replaces p entirely.
+func (p Profile) Merge(update Profile) (merged Profile) {
+	if !bytes.Equal(p.SSHWirePublicKey, update.SSHWirePublicKey) {
+		return update
+	}
+	merged = update
+	if merged.PGPPublicKey == nil {
+		merged.PGPPublicKey = p.PGPPublicKey
+	}
+	if merged.TeamCheckpoint == nil {
+		merged.TeamCheckpoint = p.TeamCheckpoint
+	}
+	return
+}
+
 var KRYPTONITE_ASCII_ARMOR_HEADERS = map[string]string{"Comment": "Created with Kryptonite"}
 var KRYPTON_ASCII_ARMOR_HEADERS = map[string]string{"Comment": "Created with Krypton"}
 
@@ -2,6 +2,7 @@ package kr
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"golang.org/x/crypto/ssh"
@@ -47,3 +48,47 @@ func TestRSAProfile(t *testing.T) {
 		t.Fatal("authorized key != ssh public key")
 	}
 }
+
+func TestEd25519Profile(t *testing.T) {
+	profile, _, pk := TestMeEd25519(t)
+	authKey, err := profile.AuthorizedKeyString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authKey, ssh.KeyAlgoED25519+" ") {
+		t.Fatal("expected an ssh-ed25519 authorized key, got", authKey)
+	}
+	key1, email, _, _, err := ssh.ParseAuthorizedKey([]byte(authKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email != profile.Email || !bytes.Equal(key1.Marshal(), pk.Marshal()) {
+		t.Fatal("authorized key != public key")
+	}
+
+	if _, err := profile.RSAPublicKey(); err != ErrNotRSAKey {
+		t.Fatal("expected ErrNotRSAKey, got", err)
+	}
+}
+
+func TestProfileMerge(t *testing.T) {
+	pgp := []byte("pgp")
+	cached := Profile{
+		SSHWirePublicKey: pubWire,
+		Email:            "old@krypt.co",
+		PGPPublicKey:     &pgp,
+	}
+
+	merged := cached.Merge(Profile{SSHWirePublicKey: pubWire, Email: "new@krypt.co"})
+	if merged.Email != "new@krypt.co" {
+		t.Fatal("expected email from the update, got", merged.Email)
+	}
+	if merged.PGPPublicKey == nil || !bytes.Equal(*merged.PGPPublicKey, pgp) {
+		t.Fatal("expected PGP key omitted by the update to be kept")
+	}
+
+	otherKey := Profile{SSHWirePublicKey: []byte("other key"), Email: "new@krypt.co"}
+	if merged := cached.Merge(otherKey); merged.PGPPublicKey != nil {
+		t.Fatal("expected a different key to replace the profile")
+	}
+}
@@ -15,6 +15,18 @@ import (
 var ENCLAVE_VERSION_SUPPORTS_RSA_SHA2_256_512 = semver.MustParse("2.1.0")
 var ENCLAVE_VERSION_SUPPORTS_KRYPTON_ASCII_ARMOR_HEADERS = semver.MustParse("2.3.1")
 
+//	Previous enclave versions expect each bluetooth write to carry a bare ciphertext
+var ENCLAVE_VERSION_SUPPORTS_FRAMED_BLUETOOTH = semver.MustParse("2.5.0")
+
+//	Previous enclave versions ignore batch and certificate sign requests
+var ENCLAVE_VERSION_SUPPORTS_SIGN_BATCH = semver.MustParse("2.5.0")
+var ENCLAVE_VERSION_SUPPORTS_CERT_SIGN = semver.MustParse("2.5.0")
+
+//	Previous enclave versions always prompt
+var ENCLAVE_VERSION_SUPPORTS_REMEMBER_HOST = semver.MustParse("2.6.0")
+
+var ErrUnsupportedByEnclave = fmt.Errorf("Request not supported by this version of the Krypton app, update it to continue")
+
 type Request struct {
 	RequestID      string          `json:"request_id"`
 	UnixSeconds    int64           `json:"unix_seconds"`
@@ -25,6 +37,16 @@ type Request struct {
 	MeRequest      *MeRequest      `json:"me_request,omitempty"`
 	UnpairRequest  *UnpairRequest  `json:"unpair_request,omitempty"`
 	HostsRequest   *HostsRequest   `json:"hosts_request,omitempty"`
+	ListRequest    *ListRequest    `json:"list_request,omitempty"`
+
+	//	Identical for requests asking for the same approval, even when sent
+	//	under different RequestIDs, so the phone can answer a resent request
+	//	without prompting again
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	RotatePairingRequest *RotatePairingRequest `json:"rotate_pairing_request,omitempty"`
+	SignBatchRequest     *SignBatchRequest     `json:"sign_batch_request,omitempty"`
+	CertSignRequest      *CertSignRequest      `json:"cert_sign_request,omitempty"`
 
 	ReadTeamRequest      *ReadTeamRequest      `json:"read_team_request,omitempty"`
 	TeamOperationRequest *TeamOperationRequest `json:"team_operation_request,omitempty"`
@@ -51,6 +73,24 @@ func (r *Request) Prepare() (err error) {
 	return
 }
 
+//	The request as an enclave at version can parse it. Optional fields it
+//	predates are dropped; requests it cannot answer fail with
+//	ErrUnsupportedByEnclave.
+func (r Request) ForEnclaveVersion(version semver.Version) (compatible Request, err error) {
+	compatible = r
+	if r.SignBatchRequest != nil && version.LT(ENCLAVE_VERSION_SUPPORTS_SIGN_BATCH) ||
+		r.CertSignRequest != nil && version.LT(ENCLAVE_VERSION_SUPPORTS_CERT_SIGN) {
+		err = ErrUnsupportedByEnclave
+		return
+	}
+	if r.SignRequest != nil && r.SignRequest.RememberHost != nil && version.LT(ENCLAVE_VERSION_SUPPORTS_REMEMBER_HOST) {
+		signRequest := *r.SignRequest
+		signRequest.RememberHost = nil
+		compatible.SignRequest = &signRequest
+	}
+	return
+}
+
 func (r Request) NotifyPrefix() string {
 	return fmt.Sprintf("[%s]", r.RequestID)
 }
@@ -73,11 +113,30 @@ func (r Request) RequestParameters(timeouts Timeouts) RequestParameters {
 			Timeout:   timeouts.Sign,
 		}
 	}
+	if r.CertSignRequest != nil {
+		return RequestParameters{
+			AlertText: "Incoming SSH certificate request. Open Krypton to continue.",
+			Timeout:   timeouts.Sign,
+		}
+	}
+	if r.SignBatchRequest != nil {
+		return RequestParameters{
+			AlertText: fmt.Sprintf("%d incoming SSH requests. Open Krypton to continue.", len(r.SignBatchRequest.Requests)),
+			Timeout:   timeouts.Sign,
+		}
+	}
 
 	if r.HostsRequest != nil {
 		return RequestParameters{
 			AlertText: "Incoming host list request. Open Krypton to continue.",
-			Timeout:   timeouts.Sign,
+			Timeout:   timeouts.List,
+		}
+	}
+
+	if r.ListRequest != nil {
+		return RequestParameters{
+			AlertText: "Incoming key list request. Open Krypton to continue.",
+			Timeout:   timeouts.List,
 		}
 	}
 
@@ -96,26 +155,85 @@ type Response struct {
 	UnpairResponse  *UnpairResponse  `json:"unpair_response,omitempty"`
 	AckResponse     *AckResponse     `json:"ack_response,omitempty"`
 	HostsResponse   *HostsResponse   `json:"hosts_response,omitempty"`
+	ListResponse    *ListResponse    `json:"list_response,omitempty"`
 	SNSEndpointARN  *string          `json:"sns_endpoint_arn,omitempty"`
 	TrackingID      *string          `json:"tracking_id,omitempty"`
 
+	RotatePairingResponse *RotatePairingResponse `json:"rotate_pairing_response,omitempty"`
+	SignBatchResponse     *SignBatchResponse     `json:"sign_batch_response,omitempty"`
+	CertSignResponse      *SignResponse          `json:"cert_sign_response,omitempty"`
+	//	Sent before the final response while the request awaits the user's
+	//	approval on the phone
+	ApprovalPendingResponse *ApprovalPendingResponse `json:"approval_pending_response,omitempty"`
+
+	//	Echoed from the request
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
 	ReadTeamResponse      *json.RawMessage `json:"read_team_response,omitempty"`
 	TeamOperationResponse *json.RawMessage `json:"team_operation_response,omitempty"`
 	LogDecryptionResponse *json.RawMessage `json:"log_decryption_response,omitempty"`
+
+	//	Medium the response arrived on, e.g. bluetooth, set by the receiver
+	Transport string `json:"-"`
 }
 
 type SignRequest struct {
 	//	N.B. []byte marshals to base64 encoding in JSON
 	Data []byte `json:"data"`
-	//	SHA256 hash of SSH wire format
+	//	SHA256 hash of SSH wire format of the phone's key to sign with. krd
+	//	fills in the paired key if empty and the profile is cached.
 	PublicKeyFingerprint []byte    `json:"public_key_fingerprint"`
 	Command              *string   `json:"command,omitempty"`
 	HostAuth             *HostAuth `json:"host_auth,omitempty"`
+	//	SSH signature algorithm to sign with, e.g. rsa-sha2-256. Omitted to
+	//	let the phone choose based on the key type.
+	Algorithm *string `json:"algorithm,omitempty"`
+	//	Omitted to always prompt
+	RememberHost *RememberHost `json:"remember_host,omitempty"`
+	//	Context shown when approving on the phone and kept in the audit log,
+	//	e.g. the git remote or the PID of the requesting process
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+//	Lets the phone approve a sign request without prompting if the user
+//	approved one for the same host within the last TTLSeconds
+type RememberHost struct {
+	Host       string `json:"host"`
+	TTLSeconds int64  `json:"ttl_seconds"`
 }
 
+//	Several sign requests approved together on the phone
+type SignBatchRequest struct {
+	Requests []SignRequest `json:"requests"`
+}
+
+//	One response per request, in request order. Error is set only if the
+//	whole batch failed.
+type SignBatchResponse struct {
+	Responses []SignResponse `json:"responses,omitempty"`
+	Error     *string        `json:"error,omitempty"`
+}
+
+//	Asks the phone to sign an SSH certificate as its certificate authority
+type CertSignRequest struct {
+	//	SSH wire format certificate up to, but excluding, the signature
+	Certificate []byte `json:"certificate"`
+	//	SHA256 hash of SSH wire format of the CA key
+	PublicKeyFingerprint []byte `json:"public_key_fingerprint"`
+	//	SSH signature algorithm to sign with, e.g. rsa-sha2-512
+	Algorithm *string `json:"algorithm,omitempty"`
+}
+
+//	SignResponse.Error of a phone that does not have the requested key
+const SIGN_ERROR_UNKNOWN_KEY = "unknown public key"
+
 type SignResponse struct {
 	Signature *[]byte `json:"signature,omitempty"`
 	Error     *string `json:"error,omitempty"`
+	//	SSH signature algorithm the phone signed with
+	Algorithm *string `json:"algorithm,omitempty"`
+	//	Approved under RememberHost without prompting the user
+	AutoApproved bool `json:"auto_approved,omitempty"`
 }
 
 type GitSignRequest struct {
@@ -145,6 +263,23 @@ type HostsResponse struct {
 	Error    *string   `json:"error,omitempty"`
 }
 
+//	Lists the keys held by the phone
+type ListRequest struct {
+	//	Ask for the keys in responses of at most PageSize keys each, all
+	//	under the request's RequestID. Zero, or a phone that predates paging,
+	//	answers with a single response.
+	PageSize int `json:"page_size,omitempty"`
+}
+
+type ListResponse struct {
+	Keys  []Profile `json:"keys,omitempty"`
+	Error *string   `json:"error,omitempty"`
+	//	Position of this page in a paged list, counting from zero
+	Page int `json:"page,omitempty"`
+	//	Set on every page of a paged list but the last
+	More bool `json:"more,omitempty"`
+}
+
 func (gsr GitSignResponse) AsciiArmorSignature(protocolVersion semver.Version) (s string, err error) {
 	if gsr.Signature == nil {
 		err = fmt.Errorf("no signature")
@@ -210,15 +345,31 @@ func (request Request) HTTPRequest() (httpRequest *http.Request, err error) {
 }
 
 func (request Request) IsNoOp() bool {
-	return request.SignRequest == nil && request.MeRequest == nil && request.UnpairRequest == nil
+	return request.SignRequest == nil && request.MeRequest == nil && request.UnpairRequest == nil && request.ListRequest == nil && request.RotatePairingRequest == nil && request.SignBatchRequest == nil && request.CertSignRequest == nil
 }
 
 type UnpairRequest struct{}
 
+//	Asks the phone to move an existing pairing to a freshly generated
+//	workstation key. The phone completes the rotation by pairing with the new
+//	key as if it had been scanned.
+type RotatePairingRequest struct {
+	WorkstationPublicKey []byte `json:"pk"`
+	WorkstationName      string `json:"n"`
+	Version              string `json:"v"`
+	ReadOnly             bool   `json:"ro,omitempty"`
+}
+
+type RotatePairingResponse struct {
+	Error *string `json:"error,omitempty"`
+}
+
 type UnpairResponse struct{}
 
 type AckResponse struct{}
 
+type ApprovalPendingResponse struct{}
+
 func (r Response) Error() *string {
 	if r.GitSignResponse != nil {
 		return r.GitSignResponse.Error
@@ -226,9 +377,24 @@ func (r Response) Error() *string {
 	if r.SignResponse != nil {
 		return r.SignResponse.Error
 	}
+	if r.SignBatchResponse != nil {
+		return r.SignBatchResponse.Error
+	}
+	if r.CertSignResponse != nil {
+		return r.CertSignResponse.Error
+	}
 	if r.HostsResponse != nil {
 		return r.HostsResponse.Error
 	}
+	if r.ListResponse != nil {
+		return r.ListResponse.Error
+	}
 
 	return nil
 }
+
+//	Whether more responses to the same request follow this one, i.e. it is
+//	a page of a paged list other than the last
+func (r Response) IsPartial() bool {
+	return r.ListResponse != nil && r.ListResponse.More
+}
@@ -0,0 +1,32 @@
+package kr
+
+import (
+	"testing"
+
+	"github.com/blang/semver"
+)
+
+func TestRequestForEnclaveVersion(t *testing.T) {
+	old := semver.MustParse("2.4.0")
+	request := Request{SignRequest: &SignRequest{RememberHost: &RememberHost{Host: "example.com", TTLSeconds: 60}}}
+	compatible, err := request.ForEnclaveVersion(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compatible.SignRequest.RememberHost != nil {
+		t.Fatal("expected RememberHost dropped for an older phone")
+	}
+	if request.SignRequest.RememberHost == nil {
+		t.Fatal("original request modified")
+	}
+	if compatible, _ := request.ForEnclaveVersion(ENCLAVE_VERSION_SUPPORTS_REMEMBER_HOST); compatible.SignRequest.RememberHost == nil {
+		t.Fatal("expected RememberHost kept for a current phone")
+	}
+
+	if _, err := (Request{SignBatchRequest: &SignBatchRequest{}}).ForEnclaveVersion(old); err != ErrUnsupportedByEnclave {
+		t.Fatal("expected ErrUnsupportedByEnclave for a batch, got", err)
+	}
+	if _, err := (Request{CertSignRequest: &CertSignRequest{}}).ForEnclaveVersion(old); err != ErrUnsupportedByEnclave {
+		t.Fatal("expected ErrUnsupportedByEnclave for a certificate, got", err)
+	}
+}
@@ -0,0 +1,42 @@
+package kr
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//	Queued messages are sent highest priority first
+const (
+	PRIORITY_BACKGROUND = iota
+	PRIORITY_NORMAL
+	PRIORITY_INTERACTIVE
+)
+
+//	A message waiting for a pairing to complete before it can be encrypted
+type QueuedMessage struct {
+	Message   []byte    `json:"message"`
+	QueuedAt  time.Time `json:"queued_at"`
+	Priority  int       `json:"priority,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+//	Requests a user is waiting on are interactive, listing requests made in
+//	the background by e.g. ssh-agent enumeration are not
+func (r Request) Priority() int {
+	switch {
+	case r.SignRequest != nil, r.GitSignRequest != nil, r.SignBatchRequest != nil, r.CertSignRequest != nil:
+		return PRIORITY_INTERACTIVE
+	case r.ListRequest != nil, r.HostsRequest != nil:
+		return PRIORITY_BACKGROUND
+	}
+	return PRIORITY_NORMAL
+}
+
+//	Priority of a serialized Request, PRIORITY_NORMAL for other messages
+func MessagePriority(message []byte) int {
+	var request Request
+	if json.Unmarshal(message, &request) != nil {
+		return PRIORITY_NORMAL
+	}
+	return request.Priority()
+}
@@ -0,0 +1,82 @@
+package kr
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const DEFAULT_AWS_REGION = "us-east-1"
+const DEFAULT_AWS_ACCOUNT_ID = "911777333295"
+
+//	Environment variables overriding the relay new pairings are created on
+const AWS_REGION_ENV = "KR_AWS_REGION"
+const AWS_ACCOUNT_ID_ENV = "KR_AWS_ACCOUNT_ID"
+const SQS_ENDPOINT_ENV = "KR_SQS_ENDPOINT"
+const SNS_ENDPOINT_ENV = "KR_SNS_ENDPOINT"
+
+//	SQS and SNS infrastructure relaying a pairing's messages, for
+//	organizations running their own. Empty fields, or a nil RelayConfig, use
+//	the hosted Krypton relay.
+type RelayConfig struct {
+	Region string `json:"region,omitempty"`
+	//	account owning the queues, part of each queue URL
+	AccountID   string `json:"account_id,omitempty"`
+	SQSEndpoint string `json:"sqs_endpoint,omitempty"`
+	SNSEndpoint string `json:"sns_endpoint,omitempty"`
+}
+
+//	The relay configured by KR_AWS_REGION, KR_AWS_ACCOUNT_ID, KR_SQS_ENDPOINT
+//	and KR_SNS_ENDPOINT, or nil if none are set
+func RelayConfigFromEnv() (relay *RelayConfig) {
+	config := RelayConfig{
+		Region:      os.Getenv(AWS_REGION_ENV),
+		AccountID:   os.Getenv(AWS_ACCOUNT_ID_ENV),
+		SQSEndpoint: os.Getenv(SQS_ENDPOINT_ENV),
+		SNSEndpoint: os.Getenv(SNS_ENDPOINT_ENV),
+	}
+	if config == (RelayConfig{}) {
+		return
+	}
+	relay = &config
+	return
+}
+
+func (relay *RelayConfig) region() string {
+	if relay == nil || relay.Region == "" {
+		return DEFAULT_AWS_REGION
+	}
+	return relay.Region
+}
+
+func (relay *RelayConfig) queueURL(name string) string {
+	if relay == nil {
+		return SQS_BASE_QUEUE_URL + name
+	}
+	endpoint := relay.SQSEndpoint
+	if endpoint == "" {
+		endpoint = "https://sqs." + relay.region() + ".amazonaws.com"
+	}
+	accountID := relay.AccountID
+	if accountID == "" {
+		accountID = DEFAULT_AWS_ACCOUNT_ID
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/" + accountID + "/" + name
+}
+
+func (relay *RelayConfig) sqsConfig() (cfg *aws.Config) {
+	cfg = aws.NewConfig()
+	if relay != nil && relay.SQSEndpoint != "" {
+		cfg = cfg.WithEndpoint(relay.SQSEndpoint)
+	}
+	return
+}
+
+func (relay *RelayConfig) snsConfig() (cfg *aws.Config) {
+	cfg = aws.NewConfig()
+	if relay != nil && relay.SNSEndpoint != "" {
+		cfg = cfg.WithEndpoint(relay.SNSEndpoint)
+	}
+	return
+}
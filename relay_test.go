@@ -0,0 +1,67 @@
+package kr
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRelayQueueURL(t *testing.T) {
+	var hosted *RelayConfig
+	if hosted.queueURL("q") != SQS_BASE_QUEUE_URL+"q" {
+		t.Fatal("unexpected hosted queue URL", hosted.queueURL("q"))
+	}
+	if (&RelayConfig{}).queueURL("q") != SQS_BASE_QUEUE_URL+"q" {
+		t.Fatal("expected empty relay config to use the hosted relay")
+	}
+	selfHosted := &RelayConfig{Region: "eu-west-1", AccountID: "123", SQSEndpoint: "https://sqs.example.com/"}
+	if selfHosted.queueURL("q") != "https://sqs.example.com/123/q" {
+		t.Fatal("unexpected self-hosted queue URL", selfHosted.queueURL("q"))
+	}
+	if selfHosted.region() != "eu-west-1" {
+		t.Fatal("unexpected region", selfHosted.region())
+	}
+}
+
+func TestRelayConfigFromEnv(t *testing.T) {
+	if RelayConfigFromEnv() != nil {
+		t.Fatal("expected hosted relay without environment overrides")
+	}
+	os.Setenv(AWS_REGION_ENV, "eu-west-1")
+	defer os.Unsetenv(AWS_REGION_ENV)
+	relay := RelayConfigFromEnv()
+	if relay == nil || relay.Region != "eu-west-1" || relay.SQSEndpoint != "" {
+		t.Fatal("unexpected relay config", relay)
+	}
+
+	pairing, err := GeneratePairingSecret(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairing.Relay = relay
+	persisted := pairingToPersisted(pairing)
+	if restored := pairingFromPersisted(&persisted); restored.Relay == nil || *restored.Relay != *relay {
+		t.Fatal("relay config not persisted")
+	}
+}
+
+func TestAWSServicesReusedPerRelay(t *testing.T) {
+	hosted, err := getSQSService(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := getSQSService(&RelayConfig{}); again != hosted {
+		t.Fatal("expected the hosted SQS client reused")
+	}
+	relay := &RelayConfig{Region: "eu-west-1"}
+	selfHosted, err := getSNSService(relay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := getSNSService(relay); again != selfHosted {
+		t.Fatal("expected the SNS client reused")
+	}
+	relay.Region = "ap-south-1"
+	if moved, _ := getSNSService(relay); moved == selfHosted || *moved.Config.Region != "ap-south-1" {
+		t.Fatal("expected a new SNS client after the region changed")
+	}
+}
@@ -0,0 +1,101 @@
+package kr
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrSignRequestNoData = errors.New("Sign request has no data to sign")
+
+//	Signature algorithms the phone accepts in SignRequest.Algorithm
+var SIGN_REQUEST_ALGORITHMS = []string{ssh.KeyAlgoRSA, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512}
+
+//	Assembles a SignRequest, checking each field as it is set. The first
+//	invalid field is reported by Build.
+type SignRequestBuilder struct {
+	request SignRequest
+	err     error
+}
+
+func NewSignRequestBuilder() *SignRequestBuilder {
+	return &SignRequestBuilder{}
+}
+
+func (b *SignRequestBuilder) fail(err error) *SignRequestBuilder {
+	if b.err == nil {
+		b.err = err
+	}
+	return b
+}
+
+func (b *SignRequestBuilder) WithData(data []byte) *SignRequestBuilder {
+	b.request.Data = append([]byte{}, data...)
+	return b
+}
+
+//	SHA256 hash of the SSH wire format of the key to sign with. Left unset,
+//	krd fills in the paired key.
+func (b *SignRequestBuilder) WithKeyFingerprint(fingerprint []byte) *SignRequestBuilder {
+	if len(fingerprint) != sha256.Size {
+		return b.fail(fmt.Errorf("Public key fingerprint has length %d, expected %d", len(fingerprint), sha256.Size))
+	}
+	b.request.PublicKeyFingerprint = append([]byte{}, fingerprint...)
+	return b
+}
+
+func (b *SignRequestBuilder) WithPublicKey(pk ssh.PublicKey) *SignRequestBuilder {
+	fingerprint := sha256.Sum256(pk.Marshal())
+	return b.WithKeyFingerprint(fingerprint[:])
+}
+
+//	The host's proof that the request is for a session with it, shown on
+//	the phone and used to remember the host
+func (b *SignRequestBuilder) WithHost(hostAuth HostAuth) *SignRequestBuilder {
+	if len(hostAuth.HostKey) == 0 || len(hostAuth.Signature) == 0 {
+		return b.fail(errors.New("Host auth needs a host key and signature"))
+	}
+	b.request.HostAuth = &hostAuth
+	return b
+}
+
+//	One of SIGN_REQUEST_ALGORITHMS, only meaningful for RSA keys
+func (b *SignRequestBuilder) WithAlgorithm(algorithm string) *SignRequestBuilder {
+	for _, supported := range SIGN_REQUEST_ALGORITHMS {
+		if algorithm == supported {
+			b.request.Algorithm = &algorithm
+			return b
+		}
+	}
+	return b.fail(fmt.Errorf("Unsupported signature algorithm %q", algorithm))
+}
+
+func (b *SignRequestBuilder) WithCommand(command string) *SignRequestBuilder {
+	b.request.Command = &command
+	return b
+}
+
+func (b *SignRequestBuilder) WithMetadata(key, value string) *SignRequestBuilder {
+	if b.request.Metadata == nil {
+		b.request.Metadata = map[string]string{}
+	}
+	b.request.Metadata[key] = value
+	return b
+}
+
+//	The assembled request, or the first error in setting it up. Data is
+//	required.
+func (b *SignRequestBuilder) Build() (request SignRequest, err error) {
+	if b.err != nil {
+		err = b.err
+		return
+	}
+	if len(b.request.Data) == 0 {
+		err = ErrSignRequestNoData
+		return
+	}
+	request = b.request
+	return
+}
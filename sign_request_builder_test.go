@@ -0,0 +1,54 @@
+package kr
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignRequestBuilder(t *testing.T) {
+	me, _, pk := TestMe(t)
+	hostAuth := HostAuth{HostKey: []byte("host key"), Signature: []byte("signature"), HostNames: []string{"example.com"}}
+	request, err := NewSignRequestBuilder().
+		WithData([]byte("data")).
+		WithPublicKey(pk).
+		WithHost(hostAuth).
+		WithAlgorithm(ssh.KeyAlgoRSASHA256).
+		WithCommand("git push").
+		WithMetadata("pid", "1").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(request.Data, []byte("data")) {
+		t.Fatal("wrong data", request.Data)
+	}
+	if !bytes.Equal(request.PublicKeyFingerprint, me.PublicKeyFingerprint()) {
+		t.Fatal("expected the fingerprint of the profile's key")
+	}
+	if request.HostAuth == nil || request.HostAuth.HostNames[0] != "example.com" {
+		t.Fatal("expected host auth, got", request.HostAuth)
+	}
+	if request.Algorithm == nil || *request.Algorithm != ssh.KeyAlgoRSASHA256 {
+		t.Fatal("wrong algorithm", request.Algorithm)
+	}
+	if request.Command == nil || *request.Command != "git push" || request.Metadata["pid"] != "1" {
+		t.Fatal("expected command and metadata, got", request)
+	}
+}
+
+func TestSignRequestBuilderErrors(t *testing.T) {
+	if _, err := NewSignRequestBuilder().Build(); err != ErrSignRequestNoData {
+		t.Fatal("expected ErrSignRequestNoData, got", err)
+	}
+	for _, builder := range []*SignRequestBuilder{
+		NewSignRequestBuilder().WithData([]byte("data")).WithKeyFingerprint([]byte("short")),
+		NewSignRequestBuilder().WithData([]byte("data")).WithAlgorithm("rsa-md5"),
+		NewSignRequestBuilder().WithData([]byte("data")).WithHost(HostAuth{HostNames: []string{"example.com"}}),
+	} {
+		if _, err := builder.Build(); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+}
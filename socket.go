@@ -49,6 +49,29 @@ func KrDir() (krPath string, err error) {
 	return
 }
 
+//	Overrides the directory pairing state is stored in, so that several krd
+//	instances can run side by side
+const PAIRING_DIR_ENV = "KR_PAIRING_DIR"
+
+//	KR_PAIRING_DIR if set, otherwise ~/.kr
+func PairingDir() (pairingPath string, err error) {
+	pairingPath = os.Getenv(PAIRING_DIR_ENV)
+	if pairingPath == "" {
+		return KrDir()
+	}
+	err = os.MkdirAll(pairingPath, os.FileMode(0700))
+	return
+}
+
+func PairingDirFile(file string) (fullPath string, err error) {
+	pairingPath, err := PairingDir()
+	if err != nil {
+		return
+	}
+	fullPath = filepath.Join(pairingPath, file)
+	return
+}
+
 func NotifyDir() (krPath string, err error) {
 	home := HomeDir()
 	if err != nil {
@@ -172,7 +195,8 @@ func DaemonDialWithTimeout(unixFile string) (conn net.Conn, err error) {
 func DaemonSocketOrFatal() (unixFile string) {
 	unixFile, err := KrDirFile(DAEMON_SOCKET_FILENAME)
 	if err != nil {
-		log.Fatal("Could not open connection to daemon. Make sure it is running by typing \"kr restart\".")
+		log.Error("Could not open connection to daemon. Make sure it is running by typing \"kr restart\".")
+		os.Exit(1)
 	}
 	return
 }
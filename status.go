@@ -0,0 +1,88 @@
+package kr
+
+import (
+	"time"
+)
+
+//	Pairing and daemon state served by krd at /status
+type DaemonStatus struct {
+	Paired bool `json:"paired"`
+	//	Set once the phone's profile has been fetched
+	Email                *string `json:"email,omitempty"`
+	PublicKeyFingerprint *string `json:"public_key_fingerprint,omitempty"`
+	Bluetooth            string  `json:"bluetooth"`
+	BluetoothError       *string `json:"bluetooth_error,omitempty"`
+	//	Time of the last successful signature since krd started
+	LastSignature *time.Time `json:"last_signature,omitempty"`
+	//	Times the phone's key was unwrapped, i.e. pairings completed or
+	//	rotated, since krd started
+	KeyUnwraps    uint64     `json:"key_unwraps"`
+	LastKeyUnwrap *time.Time `json:"last_key_unwrap,omitempty"`
+
+	PendingRequests    int `json:"pending_requests"`
+	OutgoingQueueDepth int `json:"outgoing_queue_depth"`
+}
+
+//	A phone paired, or pairing, with this workstation, served by krd at
+//	/pair/devices
+type PairedDevice struct {
+	//	Bluetooth service UUID of the pairing, stable for its lifetime
+	ID string `json:"id"`
+	//	false until the phone scans the pairing
+	Paired   bool `json:"paired"`
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+//	A completed signature request, successful or not, served by krd at /audit
+type SignatureAuditEntry struct {
+	RequestID            string    `json:"request_id"`
+	Time                 time.Time `json:"time"`
+	PublicKeyFingerprint []byte    `json:"public_key_fingerprint"`
+	//	Empty unless the request carried a verified HostAuth
+	HostNames []string `json:"host_names,omitempty"`
+	Command   *string  `json:"command,omitempty"`
+	Succeeded bool     `json:"succeeded"`
+	Error     *string  `json:"error,omitempty"`
+	//	Approved without prompting because the host was remembered
+	AutoApproved bool `json:"auto_approved,omitempty"`
+	//	As attached to the sign request
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+//	Snapshot of krd for bug reports, served by krd at /diagnostics. Leaves
+//	out pairing keys, push endpoints and anything attached to signature
+//	requests.
+type Diagnostics struct {
+	//	Protocol version of this workstation
+	Version         string                 `json:"version"`
+	Paired          bool                   `json:"paired"`
+	Pairings        []PairingDiagnostics   `json:"pairings"`
+	Bluetooth       string                 `json:"bluetooth"`
+	BluetoothError  *string                `json:"bluetooth_error,omitempty"`
+	Transports      []TransportDiagnostics `json:"transports"`
+	PendingRequests int                    `json:"pending_requests"`
+	//	Messages waiting for the phone's key
+	OutgoingQueueDepth int `json:"outgoing_queue_depth"`
+	//	The most recent signature requests, oldest first, without commands
+	//	or metadata
+	RecentSignatures []SignatureAuditEntry `json:"recent_signatures"`
+	//	Set when the diagnostics include a live ping of the phone
+	PingLatencyMillis *int64  `json:"ping_latency_ms,omitempty"`
+	PingError         *string `json:"ping_error,omitempty"`
+}
+
+type PairingDiagnostics struct {
+	//	As in PairedDevice
+	ID       string `json:"id"`
+	Paired   bool   `json:"paired"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	//	Set once the phone has responded
+	EnclaveVersion *string `json:"enclave_version,omitempty"`
+	//	Whether the phone can be woken with a push notification
+	HasSNSEndpoint bool `json:"has_sns_endpoint"`
+}
+
+type TransportDiagnostics struct {
+	Medium    string `json:"medium"`
+	Connected bool   `json:"connected"`
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"testing"
 
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -14,6 +15,10 @@ var testPK ssh.PublicKey
 var testMe *Profile
 var testMeMutex sync.Mutex
 
+var testEd25519SK ed25519.PrivateKey
+var testEd25519PK ssh.PublicKey
+var testMeEd25519 *Profile
+
 func TestMe(t *testing.T) (profile Profile, sk *rsa.PrivateKey, pk ssh.PublicKey) {
 	testMeMutex.Lock()
 	defer testMeMutex.Unlock()
@@ -34,3 +39,24 @@ func TestMe(t *testing.T) (profile Profile, sk *rsa.PrivateKey, pk ssh.PublicKey
 	}
 	return *testMe, testSK, testPK
 }
+
+func TestMeEd25519(t *testing.T) (profile Profile, sk ed25519.PrivateKey, pk ssh.PublicKey) {
+	testMeMutex.Lock()
+	defer testMeMutex.Unlock()
+	if testMeEd25519 == nil {
+		_, edSK, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testEd25519SK = edSK
+		testEd25519PK, err = ssh.NewPublicKey(edSK.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		testMeEd25519 = &Profile{
+			SSHWirePublicKey: testEd25519PK.Marshal(),
+			Email:            "kevin@krypt.co",
+		}
+	}
+	return *testMeEd25519, testEd25519SK, testEd25519PK
+}
@@ -13,6 +13,7 @@ type Timeouts struct {
 	Me       TimeoutPhases
 	Pair     TimeoutPhases
 	Sign     TimeoutPhases
+	List     TimeoutPhases
 	ACKDelay time.Duration
 }
 
@@ -30,6 +31,10 @@ func DefaultTimeouts() Timeouts {
 			Alert: 2 * time.Second,
 			Fail:  30 * time.Second,
 		},
+		List: TimeoutPhases{
+			Alert: 2 * time.Second,
+			Fail:  30 * time.Second,
+		},
 		ACKDelay: 60 * time.Second,
 	}
 }
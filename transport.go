@@ -15,11 +15,11 @@ type Transport interface {
 type AWSTransport struct{}
 
 func (t AWSTransport) Setup(ps *PairingSecret) (err error) {
-	_, err = CreateQueue(ps.SQSSendQueueName())
+	_, err = CreateQueue(ps.Relay, ps.SQSSendQueueName())
 	if err != nil {
 		return
 	}
-	_, err = CreateQueue(ps.SQSRecvQueueName())
+	_, err = CreateQueue(ps.Relay, ps.SQSRecvQueueName())
 	if err != nil {
 		return
 	}
@@ -36,12 +36,12 @@ func (t AWSTransport) PushAlert(ps *PairingSecret, alertText string, message []b
 	go func() {
 		arn := ps.GetSNSEndpointARN()
 		if arn != nil {
-			if pushErr := PushAlertToSNSEndpoint(alertText, ctxtString, *arn, ps.SQSSendQueueName()); pushErr != nil {
+			if pushErr := PushAlertToSNSEndpoint(ps.Relay, alertText, ctxtString, *arn, ps.SQSSendQueueName()); pushErr != nil {
 				log.Error("Push error:", pushErr)
 			}
 		}
 	}()
-	err = SendToQueue(ps.SQSSendQueueName(), ctxtString)
+	err = SendToQueue(ps.Relay, ps.SQSSendQueueName(), ctxtString)
 	if err != nil {
 		return
 	}
@@ -59,13 +59,13 @@ func (t AWSTransport) SendMessage(ps *PairingSecret, message []byte) (err error)
 		arn := ps.snsEndpointARN
 		ps.Unlock()
 		if arn != nil {
-			if pushErr := PushToSNSEndpoint(ctxtString, *arn, ps.SQSSendQueueName()); pushErr != nil {
+			if pushErr := PushToSNSEndpoint(ps.Relay, ctxtString, *arn, ps.SQSSendQueueName()); pushErr != nil {
 				log.Error("Push error:", pushErr)
 			}
 		}
 	}()
 
-	err = SendToQueue(ps.SQSSendQueueName(), ctxtString)
+	err = SendToQueue(ps.Relay, ps.SQSSendQueueName(), ctxtString)
 	if err != nil {
 		return
 	}
@@ -82,7 +82,7 @@ func notifyIfSignatureExpiredErr(err error, notifier *Notifier) {
 }
 
 func (t AWSTransport) Read(notifier *Notifier, ps *PairingSecret) (ciphertexts [][]byte, err error) {
-	ctxtStrings, err := ReceiveAndDeleteFromQueue(ps.SQSRecvQueueName())
+	ctxtStrings, err := ReceiveAndDeleteFromQueue(ps.Relay, ps.SQSRecvQueueName())
 	notifyIfSignatureExpiredErr(err, notifier)
 	if err != nil {
 		return
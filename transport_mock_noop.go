@@ -14,6 +14,6 @@ func (t NoopTransport) SendMessage(ps *PairingSecret, message []byte) (err error
 	return
 }
 
-func (t NoopTransport) Read(ps *PairingSecret) (ciphertexts [][]byte, err error) {
+func (t NoopTransport) Read(notifier *Notifier, ps *PairingSecret) (ciphertexts [][]byte, err error) {
 	return
 }
@@ -4,31 +4,85 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/blang/semver"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
 )
 
 var SHORT_ACK_DELAY = 500 * time.Millisecond
 
+//	A phone version supporting every request
+var MOCK_ENCLAVE_VERSION = semver.MustParse("2.6.0")
+
 type ResponseTransport struct {
 	ImmediatePairTransport
 	*testing.T
 	sync.Mutex
-	responses             [][]byte
+	//	responses by the workstation public key of the pairing they answer,
+	//	or under "" for any pairing
+	responses             map[string][][]byte
 	sentNoOps             int
+	sentSignRequests      int
+	reads                 int
 	RespondToAlertOnly    bool
 	DoNotRespond          bool
 	Ack                   bool
 	SendAfterHalfAckDelay bool
+	//	number of requests to ignore before responding
+	DropRequests int
+	//	signature algorithm to report instead of the requested one
+	SignatureAlgorithm *string
+	//	ignore pairing rotation requests, as older phones do
+	RotationUnsupported bool
+	//	surround every batch of responses with ciphertexts that fail to decrypt
+	CorruptCiphertexts bool
+	//	fail every send and push as if SNS/SQS were unreachable
+	FailSends bool
+	//	number of reads to fail as if throttled by SQS
+	ThrottleReads int
+	//	errors to fail successive reads with, e.g. network or permission errors
+	ReadErrors []error
+	//	corrupt every signature, as a buggy or tampering phone would
+	CorruptSignatures bool
+	//	report sign requests as awaiting approval before answering them
+	ApprovalPending bool
+	//	push endpoint to report in every response
+	SNSEndpointARN *string
+	//	version to report in every response instead of MOCK_ENCLAVE_VERSION
+	EnclaveVersion *semver.Version
+	//	last approval time by host, for requests with RememberHost
+	hostApprovals map[string]time.Time
+	//	keys the phone holds besides TestMe's, listed and signed with when
+	//	requested by fingerprint
+	ExtraKeys []crypto.Signer
+	//	use TestMeEd25519's key and profile instead of TestMe's
+	Ed25519 bool
+	//	delay before each page of a paged list, as a phone sending a long
+	//	list would take
+	PageDelay time.Duration
+}
+
+//	The profile and key the phone is paired with
+func (t *ResponseTransport) me() (me Profile, sk crypto.Signer, pk ssh.PublicKey) {
+	if t.Ed25519 {
+		return TestMeEd25519(t.T)
+	}
+	return TestMe(t.T)
 }
 
 func (t *ResponseTransport) respondToMessage(ps *PairingSecret, m []byte, ackSent bool) (err error) {
 	if t.DoNotRespond {
 		return
 	}
-	me, sk, _ := TestMe(t.T)
+	me, sk, _ := t.me()
 	var request Request
 	err = json.Unmarshal(m, &request)
 	if err != nil {
@@ -38,8 +92,21 @@ func (t *ResponseTransport) respondToMessage(ps *PairingSecret, m []byte, ackSen
 		t.sentNoOps += 1
 		return
 	}
+	if request.SignRequest != nil {
+		t.sentSignRequests++
+	}
+	if t.DropRequests > 0 {
+		t.DropRequests--
+		return
+	}
 	response := Response{
-		RequestID: request.RequestID,
+		RequestID:      request.RequestID,
+		IdempotencyKey: request.IdempotencyKey,
+		SNSEndpointARN: t.SNSEndpointARN,
+		Version:        MOCK_ENCLAVE_VERSION,
+	}
+	if t.EnclaveVersion != nil {
+		response.Version = *t.EnclaveVersion
 	}
 	if request.SendACK && !ackSent && t.Ack {
 		response.AckResponse = &AckResponse{}
@@ -57,17 +124,81 @@ func (t *ResponseTransport) respondToMessage(ps *PairingSecret, m []byte, ackSen
 				Me: me,
 			}
 		}
+		if request.RotatePairingRequest != nil && !t.RotationUnsupported {
+			response.RotatePairingResponse = &RotatePairingResponse{}
+		}
+		if request.ListRequest != nil {
+			keys := []Profile{me}
+			for _, extraKey := range t.ExtraKeys {
+				pk, err := ssh.NewPublicKey(extraKey.Public())
+				if err != nil {
+					t.T.Fatal(err)
+				}
+				keys = append(keys, Profile{SSHWirePublicKey: pk.Marshal()})
+			}
+			pageSize := request.ListRequest.PageSize
+			if pageSize <= 0 {
+				pageSize = len(keys)
+			}
+			//	every page but the last is queued ahead of the response
+			var pages [][]byte
+			page := 0
+			for ; len(keys) > pageSize; page++ {
+				partial := response
+				partial.ListResponse = &ListResponse{Keys: keys[:pageSize], Page: page, More: true}
+				partialJson, err := json.Marshal(partial)
+				if err != nil {
+					t.T.Fatal(err)
+				}
+				pages = append(pages, partialJson)
+				keys = keys[pageSize:]
+			}
+			response.ListResponse = &ListResponse{Keys: keys, Page: page}
+			if t.PageDelay > 0 {
+				respJson, jsonErr := json.Marshal(response)
+				if jsonErr != nil {
+					t.T.Fatal(jsonErr)
+				}
+				pages = append(pages, respJson)
+				go func() {
+					for _, pageJson := range pages {
+						<-time.After(t.PageDelay)
+						t.Lock()
+						t.queueResponse(string(ps.WorkstationPublicKey), pageJson)
+						t.Unlock()
+					}
+				}()
+				return
+			}
+			for _, pageJson := range pages {
+				t.queueResponse(string(ps.WorkstationPublicKey), pageJson)
+			}
+		}
 		if request.SignRequest != nil {
+			if t.ApprovalPending {
+				pending := response
+				pending.ApprovalPendingResponse = &ApprovalPendingResponse{}
+				pendingJson, err := json.Marshal(pending)
+				if err != nil {
+					t.T.Fatal(err)
+				}
+				t.queueResponse(string(ps.WorkstationPublicKey), pendingJson)
+			}
+			signResponse := t.signWithKey(*request.SignRequest)
+			response.SignResponse = &signResponse
+		}
+		if request.CertSignRequest != nil {
 			fp := me.PublicKeyFingerprint()
-			if !bytes.Equal(request.SignRequest.PublicKeyFingerprint, fp[:]) {
+			if !bytes.Equal(request.CertSignRequest.PublicKeyFingerprint, fp[:]) {
 				t.Fatal("wrong public key")
 			}
-			sig, err := sk.Sign(rand.Reader, request.SignRequest.Data, crypto.SHA256)
-			if err != nil {
-				t.T.Fatal(err)
-			}
-			response.SignResponse = &SignResponse{
-				Signature: &sig,
+			signResponse := t.signCert(sk, *request.CertSignRequest)
+			response.CertSignResponse = &signResponse
+		}
+		if request.SignBatchRequest != nil {
+			response.SignBatchResponse = &SignBatchResponse{}
+			for _, signRequest := range request.SignBatchRequest.Requests {
+				response.SignBatchResponse.Responses = append(response.SignBatchResponse.Responses, t.signWithKey(signRequest))
 			}
 		}
 	}
@@ -75,13 +206,109 @@ func (t *ResponseTransport) respondToMessage(ps *PairingSecret, m []byte, ackSen
 	if err != nil {
 		t.T.Fatal(err)
 	}
-	t.responses = append(t.responses, respJson)
+	t.queueResponse(string(ps.WorkstationPublicKey), respJson)
 	return
 }
 
+//	SSH userauth payloads without their public key, as sent by the agent
+type strippedSignaturePayload struct {
+	Session []byte
+	Type    byte
+	User    string
+	Service string
+	Method  string
+	Sign    bool
+	Algo    []byte
+}
+
+//	Sign with the phone's key matching the request's fingerprint, or fail
+//	with SIGN_ERROR_UNKNOWN_KEY as the phone does if it has no such key
+func (t *ResponseTransport) signWithKey(signRequest SignRequest) SignResponse {
+	_, sk, pk := t.me()
+	signers, pks := []crypto.Signer{sk}, []ssh.PublicKey{pk}
+	for _, extraKey := range t.ExtraKeys {
+		extraPK, err := ssh.NewPublicKey(extraKey.Public())
+		if err != nil {
+			t.T.Fatal(err)
+		}
+		signers, pks = append(signers, extraKey), append(pks, extraPK)
+	}
+	for i, keyPK := range pks {
+		fingerprint := sha256.Sum256(keyPK.Marshal())
+		if bytes.Equal(signRequest.PublicKeyFingerprint, fingerprint[:]) {
+			return t.sign(signers[i], keyPK, signRequest)
+		}
+	}
+	unknownKey := SIGN_ERROR_UNKNOWN_KEY
+	return SignResponse{Error: &unknownKey}
+}
+
+//	SSH signature payloads are signed as the phone would, with the public key
+//	restored and hashed according to the algorithm. Any other data is signed
+//	as a SHA256 digest. Ed25519 keys sign the payload or data itself.
+func (t *ResponseTransport) sign(sk crypto.Signer, pk ssh.PublicKey, signRequest SignRequest) SignResponse {
+	algorithm := signRequest.Algorithm
+	if t.SignatureAlgorithm != nil {
+		algorithm = t.SignatureAlgorithm
+	}
+	digest, hash := signRequest.Data, crypto.SHA256
+	var stripped strippedSignaturePayload
+	if err := ssh.Unmarshal(signRequest.Data, &stripped); err == nil {
+		payload := append(ssh.Marshal(stripped), ssh.Marshal(struct{ PubKey []byte }{pk.Marshal()})...)
+		hash = crypto.SHA1
+		if algorithm != nil && *algorithm == ssh.KeyAlgoRSASHA256 {
+			hash = crypto.SHA256
+		} else if algorithm != nil && *algorithm == ssh.KeyAlgoRSASHA512 {
+			hash = crypto.SHA512
+		}
+		h := hash.New()
+		h.Write(payload)
+		digest = h.Sum(nil)
+		if pk.Type() == ssh.KeyAlgoED25519 {
+			digest, hash = payload, crypto.Hash(0)
+		}
+	} else if pk.Type() == ssh.KeyAlgoED25519 {
+		hash = crypto.Hash(0)
+	}
+	sig, err := sk.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		t.T.Fatal(err)
+	}
+	if t.CorruptSignatures {
+		sig[len(sig)-1] ^= 1
+	}
+	signResponse := SignResponse{
+		Signature: &sig,
+		Algorithm: algorithm,
+	}
+	if remember := signRequest.RememberHost; remember != nil {
+		if t.hostApprovals == nil {
+			t.hostApprovals = map[string]time.Time{}
+		}
+		approvedAt, ok := t.hostApprovals[remember.Host]
+		if ok && time.Since(approvedAt) < time.Duration(remember.TTLSeconds)*time.Second {
+			signResponse.AutoApproved = true
+		} else {
+			t.hostApprovals[remember.Host] = time.Now()
+		}
+	}
+	return signResponse
+}
+
+func (t *ResponseTransport) queueResponse(pairingKey string, response []byte) {
+	if t.responses == nil {
+		t.responses = map[string][][]byte{}
+	}
+	t.responses[pairingKey] = append(t.responses[pairingKey], response)
+}
+
 func (t *ResponseTransport) SendMessage(ps *PairingSecret, m []byte) (err error) {
 	t.Lock()
 	defer t.Unlock()
+	if t.FailSends {
+		err = errors.New("send failed")
+		return
+	}
 	if t.RespondToAlertOnly {
 		return
 	}
@@ -92,23 +319,55 @@ func (t *ResponseTransport) SendMessage(ps *PairingSecret, m []byte) (err error)
 func (t *ResponseTransport) PushAlert(ps *PairingSecret, alertText string, message []byte) (err error) {
 	t.Lock()
 	defer t.Unlock()
+	if t.FailSends {
+		err = errors.New("push failed")
+		return
+	}
 	err = t.respondToMessage(ps, message, false)
 	return
 }
 
 func (t *ResponseTransport) Read(notifier *Notifier, ps *PairingSecret) (ciphertexts [][]byte, err error) {
+	t.Lock()
+	if t.ThrottleReads > 0 {
+		t.ThrottleReads--
+		t.Unlock()
+		err = awserr.New("RequestThrottled", "Request is throttled.", nil)
+		return
+	}
+	if len(t.ReadErrors) > 0 {
+		err = t.ReadErrors[0]
+		t.ReadErrors = t.ReadErrors[1:]
+		t.Unlock()
+		return
+	}
+	t.Unlock()
 	pairCiphertexts, err := t.ImmediatePairTransport.Read(notifier, ps)
 	ciphertexts = append(ciphertexts, pairCiphertexts...)
 	t.Lock()
 	defer t.Unlock()
-	for _, responseBytes := range t.responses {
+	t.reads++
+	if !ps.IsPaired() {
+		//	leave responses for a pairing that can encrypt them
+		return
+	}
+	pairingKey := string(ps.WorkstationPublicKey)
+	responses := append(t.responses[""], t.responses[pairingKey]...)
+	if t.CorruptCiphertexts && len(responses) > 0 {
+		ciphertexts = append(ciphertexts, []byte("corrupt"))
+	}
+	for _, responseBytes := range responses {
 		ctxt, err := ps.EncryptMessage(responseBytes)
 		if err != nil {
 			t.T.Fatal(err)
 		}
 		ciphertexts = append(ciphertexts, ctxt)
 	}
-	t.responses = [][]byte{}
+	if t.CorruptCiphertexts && len(responses) > 0 {
+		ciphertexts = append(ciphertexts, []byte("corrupt"))
+	}
+	delete(t.responses, "")
+	delete(t.responses, pairingKey)
 	return
 }
 
@@ -118,6 +377,18 @@ func (t *ResponseTransport) GetSentNoOps() int {
 	return t.sentNoOps
 }
 
+func (t *ResponseTransport) GetSentSignRequests() int {
+	t.Lock()
+	defer t.Unlock()
+	return t.sentSignRequests
+}
+
+func (t *ResponseTransport) GetReads() int {
+	t.Lock()
+	defer t.Unlock()
+	return t.reads
+}
+
 func (t *ResponseTransport) RemoteUnpair() {
 	t.Lock()
 	defer t.Unlock()
@@ -128,5 +399,31 @@ func (t *ResponseTransport) RemoteUnpair() {
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.responses = append(t.responses, respJson)
+	t.queueResponse("", respJson)
+}
+
+//	Unlike SignRequest data, certificates are sent unhashed
+func (t *ResponseTransport) signCert(sk crypto.Signer, certSignRequest CertSignRequest) SignResponse {
+	hash := crypto.SHA256
+	if certSignRequest.Algorithm != nil && *certSignRequest.Algorithm == "rsa-sha2-512" {
+		hash = crypto.SHA512
+	}
+	h := hash.New()
+	h.Write(certSignRequest.Certificate)
+	digest := h.Sum(nil)
+	if _, ok := sk.(ed25519.PrivateKey); ok {
+		digest, hash = certSignRequest.Certificate, crypto.Hash(0)
+	}
+	sig, err := sk.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		t.T.Fatal(err)
+	}
+	algorithm := certSignRequest.Algorithm
+	if t.SignatureAlgorithm != nil {
+		algorithm = t.SignatureAlgorithm
+	}
+	return SignResponse{
+		Signature: &sig,
+		Algorithm: algorithm,
+	}
 }